@@ -1,212 +1,458 @@
 package libhac
 
 import (
-	_ "crypto/sha256"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	_ "crypto/sha512"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	gohash "hash"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-func (c *HacClient) download(url, path string) error {
-	resp, err := c.DoRequest("GET", url, []tls.Certificate{c.DeviceCert}, false, true)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+func (c *HacClient) Download(url, path string) error {
+	return c.DownloadContext(context.Background(), url, path)
+}
 
-	out, err := os.Create(path)
-	if err != nil {
-		return err
+func (c *HacClient) DownloadContext(ctx context.Context, url, path string) error {
+	ctx, span := c.startSpan(ctx, "Download", map[string]interface{}{"url": url, "path": path})
+	err := c.downloadURL(ctx, url, path)
+	span.End(err)
+
+	return err
+}
+
+func (c *HacClient) downloadURL(ctx context.Context, url, path string) error {
+	if c.CheckDiskSpace {
+		size, err := c.contentLength(ctx, url)
+		if err != nil {
+			return err
+		}
+
+		err = checkSpace(filepath.Dir(path), c.remainingSize(path, size))
+		if err != nil {
+			return err
+		}
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
+	if c.CDN != nil {
+		return c.CDN.DownloadContext(ctx, url, path)
 	}
 
-	return nil
+	return c.downloadFile(ctx, url, path, nil)
 }
 
-func (c *HacClient) TestEdgeToken() error {
-	id, err := c.GetCNMTID("0100000000010000", 0)
-	if err != nil || id == "" {
-		return errors.New("edge token is invalid!")
+// partSuffix is appended to files while they're downloaded, they're only
+// renamed to their final path once complete.
+const partSuffix = ".part"
+
+// isPartialDownload reports if name is a file left by an unfinished
+// download, streamed or segmented.
+func isPartialDownload(name string) bool {
+	return strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, segmentSuffix)
+}
+
+// downloadFile downloads url to path, if hash is set the SHA-256 of the
+// result must match it or the file is removed. The data is written to
+// path.part first, so path only ever exists complete.
+func (c *HacClient) downloadFile(ctx context.Context, url, path string, hash []byte) error {
+	if c.Resume {
+		if _, err := os.Stat(path); err == nil {
+			if hash == nil || verifyDownload(path, hash, sha256.New(), false) == nil {
+				logf(c.Log, "%s is already downloaded", path)
+				return nil
+			}
+		}
 	}
 
-	return nil
+	part := path + partSuffix
+
+	for attempt := 1; ; attempt++ {
+		var h gohash.Hash
+		if hash != nil {
+			h = sha256.New()
+		}
+
+		streamed, err := c.download(ctx, url, part, c.Resume || attempt > 1, h)
+		if err == nil && hash != nil {
+			err = verifyDownload(part, hash, h, streamed)
+		}
+		if err == nil {
+			logf(c.Log, "renaming %s to %s", part, path)
+			return os.Rename(part, path)
+		}
+
+		var te *transferError
+		if !errors.As(err, &te) || attempt >= c.Retry.attempts() || ctx.Err() != nil {
+			return err
+		}
+
+		logf(c.Log, "retrying %s after %v, attempt %d of %d", url, err, attempt+1, c.Retry.attempts())
+		c.stats.retry(url)
+
+		err = c.Retry.wait(ctx, attempt-1)
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func (c *HacClient) GetCNMTID(tid string, ver int) (string, error) {
-	resp, err := c.DoRequest("HEAD", fmt.Sprintf("https://atum.hac.lp1.d4c.nintendo.net/t/a/%s/%d", tid, ver),
-		[]tls.Certificate{c.DeviceCert}, false, true)
-	if err != nil {
-		return "", err
+// download returns true if every byte of the file was written to h.
+func (c *HacClient) download(ctx context.Context, url, path string, resume bool, h gohash.Hash) (bool, error) {
+	var offset int64
+	if resume {
+		fi, err := os.Stat(path)
+		if err == nil {
+			offset = fi.Size()
+		}
 	}
 
-	cnmtID := resp.Header.Get("X-Nintendo-Content-ID")
+	if c.Segments > 1 && offset == 0 {
+		size, err := c.getSegmentableSize(ctx, url)
+		if err != nil {
+			return false, err
+		}
 
-	if cnmtID == "" {
-		return "", errors.New("title not on cdn")
+		if size > 0 {
+			return false, c.downloadSegmented(ctx, url, path, size)
+		}
 	}
 
-	return cnmtID, nil
-}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
-func (c *HacClient) DownloadCNMT(cnmtID string, out string) error {
-	err := c.download(fmt.Sprintf("https://atum.hac.lp1.d4c.nintendo.net/c/a/%s", cnmtID), out)
+	resp, err := c.doRequest(ctx, "GET", url, []tls.Certificate{c.DeviceCert}, false, true, header, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer resp.Body.Close()
 
-	return nil
-}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial file is already complete if it has the size the server
+		// reports, only streamed downloads leave one behind since segmented
+		// ones are written to path.seg
+		if unsatisfiedRangeSize(resp) == offset {
+			return false, nil
+		}
+
+		logf(c.Log, "%s doesn't match the size of %s, restarting", path, url)
+		resp.Body.Close()
+
+		return c.download(ctx, url, path, false, h)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		// checked before the file is opened, so error pages never end up in it
+		return false, statusError(ServiceAtum, resp)
+	}
 
-func DecryptNCA(path, out, hactoolPath string) error {
-	err := os.MkdirAll(out, 0700)
+	if flags&os.O_APPEND == 0 {
+		offset = 0
+	} else {
+		logf(c.Log, "resuming %s at byte %d", path, offset)
+	}
+
+	if offset > 0 && h != nil {
+		err = hashFilePrefix(path, offset, h)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	out, err := os.OpenFile(path, flags, 0666)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer out.Close()
+
+	var w io.Writer = out
+	if h != nil {
+		w = io.MultiWriter(out, h)
 	}
 
-	err = exec.Command(hactoolPath, "--exefsdir="+out+"/exefs", "--romfsdir="+out+"/romfs",
-		"--section0dir="+out+"/section0", "--section1dir="+out+"/section1",
-		"--section2dir="+out+"/section2", "--section3dir="+out+"/section3",
-		"--header="+out+"/header.bin", path).Run()
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	p := newProgressTracker(c.Progress, offset, total)
+	n, err := io.Copy(&progressWriter{w, p}, &contextReader{ctx, c.Bandwidth.reader(ctx, resp.Body)})
+	logf(c.Log, "wrote %d bytes to %s", n, path)
 	if err != nil {
-		return err
+		return false, err
 	}
+	p.finish()
 
-	return nil
+	return true, nil
 }
 
-func ParseCNMT(path, headerPath string) (CNMT, error) {
-	cnmt, err := os.Open(path)
-	if err != nil {
-		return CNMT{}, err
+// unsatisfiedRangeSize returns the size of the file from the Content-Range
+// of a 416 response, "bytes */<size>", or -1 if it doesn't have one.
+func unsatisfiedRangeSize(resp *http.Response) int64 {
+	v := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes */")
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || size < 0 {
+		return -1
 	}
-	defer cnmt.Close()
 
-	t, err := readHex(cnmt, 0xC, 1, 0)
-	if err != nil {
-		return CNMT{}, err
+	return size
+}
+
+func (c *HacClient) TestEdgeToken() error {
+	return c.TestEdgeTokenContext(context.Background())
+}
+
+func (c *HacClient) TestEdgeTokenContext(ctx context.Context) error {
+	_, err := c.GetCNMTIDContext(ctx, "0100000000010000", 0)
+	if errors.Is(err, ErrTitleNotOnCDN) {
+		// the cdn hides titles from requests with a bad edge token
+		return ErrInvalidEdgeToken
 	}
 
-	tid, err := readHex(cnmt, 0x0, 8, 0)
-	if err != nil {
-		return CNMT{}, err
+	return err
+}
+
+func (c *HacClient) GetCNMTID(tid string, ver int) (string, error) {
+	return c.GetCNMTIDContext(context.Background(), tid, ver)
+}
+
+func (c *HacClient) GetCNMTIDContext(ctx context.Context, tid string, ver int) (string, error) {
+	if c.CDN != nil {
+		return c.CDN.GetCNMTIDContext(ctx, tid, ver)
 	}
 
-	version, err := readHex(cnmt, 0x8, 4, 0)
-	if err != nil {
-		return CNMT{}, err
+	return c.getCNMTID(ctx, "a", tid, ver)
+}
+
+func (c *HacClient) getCNMTID(ctx context.Context, kind, tid string, ver int) (string, error) {
+	key := fmt.Sprintf("t/%s/%s/%d", kind, strings.ToLower(tid), ver)
+
+	var cnmtID string
+	if !c.Cache.get(key, &cnmtID) {
+		resp, err := c.DoRequestContext(ctx, "HEAD", c.serviceURL(ServiceAtum, "/t/%s/%s/%d", kind, tid, ver),
+			[]tls.Certificate{c.DeviceCert}, false, true)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			return "", statusError(ServiceAtum, resp)
+		}
+
+		cnmtID = resp.Header.Get("X-Nintendo-Content-ID")
+		c.Cache.set(key, cnmtID)
 	}
 
-	sysv, err := readHex(cnmt, 0x28, 8, 0)
-	if err != nil {
-		return CNMT{}, err
+	if cnmtID == "" {
+		return "", ErrTitleNotOnCDN
 	}
 
-	dlsysv, err := readHex(cnmt, 0x18, 8, 0)
+	return cnmtID, nil
+}
+
+func (c *HacClient) DownloadCNMT(cnmtID string, out string) error {
+	return c.DownloadCNMTContext(context.Background(), cnmtID, out)
+}
+
+func (c *HacClient) DownloadCNMTContext(ctx context.Context, cnmtID string, out string) error {
+	return DownloadCNMTFrom(ctx, c, cnmtID, out)
+}
+
+func ParseCNMT(path, headerPath string) (CNMT, error) {
+	cnmt, err := ioutil.ReadFile(path)
 	if err != nil {
 		return CNMT{}, err
 	}
 
-	digest, err := readHex(cnmt, -0x20, 0x20, 2)
+	header, err := ParseNCAHeader(headerPath, nil)
 	if err != nil {
 		return CNMT{}, err
 	}
 
-	to, err := readHex(cnmt, 0xE, 1, 0)
+	return parseCNMT(cnmt, path, header.KeyGeneration)
+}
+
+func parseCNMT(data []byte, path string, mKeyRev uint8) (CNMT, error) {
+	r := bytes.NewReader(data)
+
+	h := cnmtHeader{}
+	err := binary.Read(r, binary.LittleEndian, &h)
 	if err != nil {
 		return CNMT{}, err
 	}
 
-	tableOffset, err := strconv.ParseInt(to, 16, 64)
+	ext := make([]byte, h.ExtendedHeaderSize)
+	_, err = io.ReadFull(r, ext)
 	if err != nil {
 		return CNMT{}, err
 	}
 
-	cec, err := readHex(cnmt, 0x10, 1, 0)
+	extHeader, err := parseCNMTExtendedHeader(h.Type, ext)
 	if err != nil {
 		return CNMT{}, err
 	}
 
-	contentEntryCount, err := strconv.ParseInt(cec, 16, 64)
+	var sysv uint32
+	var extDataSize uint32
+	switch e := extHeader.(type) {
+	case ApplicationExtendedHeader:
+		sysv = e.RequiredSystemVersion
+	case PatchExtendedHeader:
+		sysv = e.RequiredSystemVersion
+		extDataSize = e.ExtendedDataSize
+	case DeltaExtendedHeader:
+		extDataSize = e.ExtendedDataSize
+	case SystemUpdateExtendedHeader:
+		extDataSize = e.ExtendedDataSize
+	}
+
+	raw := make([]cnmtContentEntry, h.ContentCount)
+	err = binary.Read(r, binary.LittleEndian, raw)
 	if err != nil {
 		return CNMT{}, err
 	}
 
 	ces := []ContentEntry{}
-	var i int64
-	for i = 0; i < contentEntryCount; i++ {
-		offset := 0x20 + tableOffset + 0x38*i
-
-		hash, err := readHex(cnmt, offset, 32, 0)
-		if err != nil {
-			return CNMT{}, err
-		}
-
-		id, err := readHex(cnmt, offset+0x20, 16, 0)
-		if err != nil {
-			return CNMT{}, err
-		}
-
-		size, err := readHex(cnmt, offset+0x30, 6, 0)
-		if err != nil {
-			return CNMT{}, err
-		}
-
-		ty, err := readHex(cnmt, offset+0x36, 1, 0)
-		if err != nil {
-			return CNMT{}, err
+	for _, v := range raw {
+		var size int64
+		for i := len(v.Size) - 1; i >= 0; i-- {
+			size = size<<8 | int64(v.Size[i])
 		}
 
 		ces = append(ces, ContentEntry{
-			hash,
-			id,
+			append([]byte{}, v.Hash[:]...),
+			append([]byte{}, v.ID[:]...),
 			size,
-			getNCAType(ty),
+			getNCAType(v.Type),
 		})
 	}
 
-	header, err := os.Open(headerPath)
+	rawMetas := make([]cnmtContentMetaEntry, h.ContentMetaCount)
+	err = binary.Read(r, binary.LittleEndian, rawMetas)
 	if err != nil {
 		return CNMT{}, err
 	}
-	defer header.Close()
 
-	mKeyRev, err := readHex(header, 0x220, 0x1, 0)
+	cmes := []ContentMetaEntry{}
+	for _, v := range rawMetas {
+		cmes = append(cmes, ContentMetaEntry{v.ID, v.Version, getCNMTType(v.Type), v.Attributes})
+	}
+
+	extData := make([]byte, extDataSize)
+	_, err = io.ReadFull(r, extData)
 	if err != nil {
 		return CNMT{}, err
 	}
 
+	if len(data) < 0x20 {
+		return CNMT{}, errors.New("cnmt is too short")
+	}
+
 	return CNMT{
-		path,
-		getCNMTType(t),
-		tid,
-		version,
-		sysv,
-		dlsysv,
-		digest,
-		mKeyRev,
-		ces,
+		Path:                          path,
+		Type:                          getCNMTType(h.Type),
+		ID:                            h.TitleID,
+		Version:                       h.Version,
+		RequiredSystemVersion:         sysv,
+		RequiredDownloadSystemVersion: h.RequiredDownloadSystemVersion,
+		Digest:                        append([]byte{}, data[len(data)-0x20:]...),
+		MasterKeyRevision:             mKeyRev,
+		ContentEntries:                ces,
+		ExtendedHeader:                extHeader,
+		ContentMetaEntries:            cmes,
+		ExtendedData:                  extData,
 	}, nil
 }
 
+func parseCNMTExtendedHeader(t uint8, ext []byte) (interface{}, error) {
+	var v interface{}
+	switch t {
+	case 0x80:
+		v = &ApplicationExtendedHeader{}
+	case 0x81:
+		v = &PatchExtendedHeader{}
+	case 0x82:
+		v = &AddOnContentExtendedHeader{}
+	case 0x83:
+		v = &DeltaExtendedHeader{}
+	case 0x03:
+		v = &SystemUpdateExtendedHeader{}
+	default:
+		return nil, nil
+	}
+
+	// newer firmwares grew some of these headers, older metas may be shorter
+	buf := make([]byte, binary.Size(v))
+	copy(buf, ext)
+
+	err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e := v.(type) {
+	case *ApplicationExtendedHeader:
+		return *e, nil
+	case *PatchExtendedHeader:
+		return *e, nil
+	case *AddOnContentExtendedHeader:
+		return *e, nil
+	case *DeltaExtendedHeader:
+		return *e, nil
+	case *SystemUpdateExtendedHeader:
+		return *e, nil
+	}
+
+	return nil, nil
+}
+
 func (c *HacClient) DownloadContentEntry(ce ContentEntry, out string) error {
-	err := c.download(fmt.Sprintf("https://atum.hac.lp1.d4c.nintendo.net/c/c/%s", ce.ID), out)
-	if err != nil {
-		return err
+	return c.DownloadContentEntryContext(context.Background(), ce, out)
+}
+
+func (c *HacClient) DownloadContentEntryContext(ctx context.Context, ce ContentEntry, out string) error {
+	ctx, span := c.startSpan(ctx, "DownloadContentEntry", map[string]interface{}{
+		"content_id": ce.IDString(), "size": ce.Size, "path": out})
+	err := c.downloadContentEntry(ctx, ce, out)
+	span.End(err)
+
+	return err
+}
+
+func (c *HacClient) downloadContentEntry(ctx context.Context, ce ContentEntry, out string) error {
+	if c.CheckDiskSpace {
+		err := checkSpace(filepath.Dir(out), c.remainingSize(out, ce.Size))
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return DownloadContentEntryFrom(ctx, c, ce, out, c.VerifyHashes)
+}
+
+// downloadVerified downloads url to path, if hash is set the SHA-256 of the
+// result must match it. Without a CDN the hash is computed while streaming.
+func (c *HacClient) downloadVerified(ctx context.Context, url, path string, hash []byte) error {
+	if c.CDN != nil {
+		return downloadVerified(ctx, c.CDN, url, path, hash)
+	}
+
+	return c.downloadFile(ctx, url, path, hash)
 }
 
 func GetRightsID(tid, mKeyRev string) string {
@@ -215,87 +461,40 @@ func GetRightsID(tid, mKeyRev string) string {
 }
 
 func (c *HacClient) DownloadCetk(rightsID, out string) error {
-	err := c.download(fmt.Sprintf("https://atum.hac.lp1.d4c.nintendo.net/r/t/%s", rightsID),
-		out)
-	if err != nil {
-		return err
-	}
+	return c.DownloadCetkContext(context.Background(), rightsID, out)
+}
 
-	return nil
+func (c *HacClient) DownloadCetkContext(ctx context.Context, rightsID, out string) error {
+	return DownloadCetkFrom(ctx, c, rightsID, out)
 }
 
 func GetTitleKeyFromCetk(path string) (string, error) {
-	cetk, err := os.Open(path)
+	t, err := ReadTicket(path)
 	if err != nil {
 		return "", err
 	}
-	defer cetk.Close()
 
-	tk, err := readHex(cetk, 0x180, 16, 0)
+	tk, err := t.TitleKey()
 	if err != nil {
 		return "", err
 	}
 
-	return tk, nil
+	return hex.EncodeToString(tk), nil
 }
 
+// GenerateTicket writes a ticket for rightsID to out. in is an optional
+// template ticket, a common ticket is generated when it's empty.
 func GenerateTicket(in, titleKey, mKeyRev, rightsID, out string) error {
-	inf, err := ioutil.ReadFile(in)
+	inf, err := ticketTemplate(in)
 	if err != nil {
 		return err
 	}
 
-	tk, err := getHexBytes(titleKey)
-	if err != nil {
-		return err
-	}
-
-	mkr, err := getHexBytes(mKeyRev)
+	inf, err = patchTicket(inf, titleKey, mKeyRev, rightsID)
 	if err != nil {
 		return err
 	}
 
-	rid, err := getHexBytes(rightsID)
-	if err != nil {
-		return err
-	}
-
-	inf[0x180] = tk[0]
-	inf[0x181] = tk[1]
-	inf[0x182] = tk[2]
-	inf[0x183] = tk[3]
-	inf[0x184] = tk[4]
-	inf[0x185] = tk[5]
-	inf[0x186] = tk[6]
-	inf[0x187] = tk[7]
-	inf[0x188] = tk[8]
-	inf[0x189] = tk[9]
-	inf[0x18A] = tk[10]
-	inf[0x18B] = tk[11]
-	inf[0x18C] = tk[12]
-	inf[0x18D] = tk[13]
-	inf[0x18E] = tk[14]
-	inf[0x18F] = tk[15]
-
-	inf[0x285] = mkr[0]
-
-	inf[0x2A0] = rid[0]
-	inf[0x2A1] = rid[1]
-	inf[0x2A2] = rid[2]
-	inf[0x2A3] = rid[3]
-	inf[0x2A4] = rid[4]
-	inf[0x2A5] = rid[5]
-	inf[0x2A6] = rid[6]
-	inf[0x2A7] = rid[7]
-	inf[0x2A8] = rid[8]
-	inf[0x2A9] = rid[9]
-	inf[0x2AA] = rid[10]
-	inf[0x2AB] = rid[11]
-	inf[0x2AC] = rid[12]
-	inf[0x2AD] = rid[13]
-	inf[0x2AE] = rid[14]
-	inf[0x2AF] = rid[15]
-
 	tik, err := os.Create(out)
 	if err != nil {
 		return err
@@ -310,59 +509,104 @@ func GenerateTicket(in, titleKey, mKeyRev, rightsID, out string) error {
 	return nil
 }
 
-func PackToNSP(path, out string) error {
-	dir, err := ioutil.ReadDir(path)
-	if err != nil {
-		return err
-	}
-
-	n := []string{}
-	for _, v := range dir {
-		n = append(n, v.Name())
+func ticketTemplate(path string) ([]byte, error) {
+	if path != "" {
+		return ioutil.ReadFile(path)
 	}
 
-	stringTable := strings.Join(n, "\x00")
-	headerSize := 0x10 + (len(dir) * 0x18) + len(stringTable)
-	remainder := 0x10 - headerSize%0x10
-	headerSize += remainder
+	return NewCommonTicket(nil, nil, 0).Bytes()
+}
 
-	fileSizes := []int64{}
-	for _, v := range dir {
-		fileSizes = append(fileSizes, v.Size())
+func patchTicket(inf []byte, titleKey, mKeyRev, rightsID string) ([]byte, error) {
+	tk, err := getHexBytes(titleKey)
+	if err != nil {
+		return nil, err
 	}
 
-	fileOffsets := []int{}
+	mkr, err := getHexBytes(mKeyRev)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(dir); i++ {
-		fileOffsets = append(fileOffsets, sum64(fileSizes[:i]))
+	rid, err := getHexBytes(rightsID)
+	if err != nil {
+		return nil, err
 	}
 
-	fileNameLengths := []int{}
-	for _, v := range dir {
-		fileNameLengths = append(fileNameLengths, len(v.Name())+1)
+	if len(tk) != 0x10 || len(mkr) != 1 || len(rid) != 0x10 {
+		return nil, errors.New("invalid titlekey, master key revision or rights id")
 	}
 
-	stringTableOffsets := []int{}
-	for i := 0; i < len(dir); i++ {
-		stringTableOffsets = append(stringTableOffsets, sum(fileNameLengths[:i]))
+	t, err := ParseTicket(inf)
+	if err != nil {
+		return nil, err
 	}
 
-	header := [][]byte{[]byte("PFS0"),
-		toBinary32(int32(len(dir))),
-		toBinary32(int32(len(stringTable) + remainder)),
-		[]byte("\x00\x00\x00\x00"),
+	t.SetTitleKey(tk)
+	t.KeyGeneration = mkr[0]
+	t.RightsID = rid
+
+	return t.Bytes()
+}
+
+// NSPOrder reports whether the entry named a is packed before b.
+type NSPOrder func(a, b string) bool
+
+// DefaultNSPOrder packs the content NCAs first, followed by the meta NCA, the
+// cnmt xml, the ticket, the certificate and anything else, which is what
+// installers expect. Entries of the same kind are sorted by name.
+func DefaultNSPOrder(a, b string) bool {
+	if ra, rb := nspRank(a), nspRank(b); ra != rb {
+		return ra < rb
 	}
 
-	for i := 0; i < len(dir); i++ {
-		header = append(header, toBinary64(int64(fileOffsets[i])))
-		header = append(header, toBinary64(fileSizes[i]))
-		header = append(header, toBinary32(int32(stringTableOffsets[i])))
-		header = append(header, []byte("\x00\x00\x00\x00"))
+	return a < b
+}
+
+func nspRank(name string) int {
+	switch {
+	case strings.HasSuffix(name, ".cnmt.nca"), strings.HasSuffix(name, ".cnmt.ncz"):
+		return 1
+	case strings.HasSuffix(name, ".nca"), strings.HasSuffix(name, ".ncz"):
+		return 0
+	case strings.HasSuffix(name, ".cnmt.xml"):
+		return 2
+	case strings.HasSuffix(name, ".tik"):
+		return 3
+	case strings.HasSuffix(name, ".cert"):
+		return 4
+	}
+
+	return 5
+}
+
+// packable leaves subdirectories, hidden files and leftovers of interrupted
+// downloads out of NSPs packed from a directory.
+func packable(fi os.FileInfo) bool {
+	name := fi.Name()
+
+	return fi.Mode().IsRegular() && !strings.HasPrefix(name, ".") && !isPartialDownload(name)
+}
+
+// PackToNSP packs the files in path into the NSP out in DefaultNSPOrder.
+func PackToNSP(path, out string) error {
+	return PackToNSPOrder(path, out, DefaultNSPOrder)
+}
+
+// PackToNSPOrder packs the files in path into the NSP out, sorted by order.
+// Subdirectories, hidden files and partial downloads are left out, so the
+// same files always give the same NSP.
+func PackToNSPOrder(path, out string, order NSPOrder) error {
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
 	}
 
-	header = append(header, []byte(stringTable))
-	for i := 1; i <= remainder; i++ {
-		header = append(header, []byte("\x00"))
+	files := []os.FileInfo{}
+	for _, v := range dir {
+		if packable(v) {
+			files = append(files, v)
+		}
 	}
 
 	nsp, err := os.Create(out)
@@ -371,25 +615,17 @@ func PackToNSP(path, out string) error {
 	}
 	defer nsp.Close()
 
-	for _, v := range header {
-		_, err = nsp.Write(v)
-		if err != nil {
-			return err
-		}
-	}
-
-	for _, v := range dir {
-		f, err := os.Open(fmt.Sprintf("%s/%s", path, v.Name()))
+	entries := []NSPEntry{}
+	for _, v := range files {
+		f, err := os.Open(filepath.Join(path, v.Name()))
 		if err != nil {
 			return err
 		}
 		defer f.Close()
 
-		_, err = io.Copy(nsp, f)
-		if err != nil {
-			return err
-		}
+		entries = append(entries, NSPEntry{v.Name(), v.Size(), f})
 	}
+	SortNSPEntries(entries, order)
 
-	return nil
+	return PackEntries(nsp, entries)
 }
@@ -1,20 +1,236 @@
 package libhac
 
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
 type CNMT struct {
 	Path                          string
 	Type                          string
-	ID                            string
-	Version                       string
-	RequiredSystemVersion         string
-	RequiredDownloadSystemVersion string
-	Digest                        string
-	MasterKeyRevision             string
+	ID                            uint64
+	Version                       uint32
+	RequiredSystemVersion         uint32
+	RequiredDownloadSystemVersion uint32
+	Digest                        []byte
+	MasterKeyRevision             uint8
 	ContentEntries                []ContentEntry
+	// ExtendedHeader holds one of the *ExtendedHeader types below depending
+	// on Type, or nil for types without one.
+	ExtendedHeader     interface{}
+	ContentMetaEntries []ContentMetaEntry
+	ExtendedData       []byte
 }
 
 type ContentEntry struct {
-	Hash string
-	ID   string
-	Size string
+	Hash []byte
+	ID   []byte
+	Size int64
 	Type string
 }
+
+type ContentMetaEntry struct {
+	ID         uint64
+	Version    uint32
+	Type       string
+	Attributes uint8
+}
+
+type ApplicationExtendedHeader struct {
+	PatchID                    uint64 `json:"patch_id"`
+	RequiredSystemVersion      uint32 `json:"required_system_version"`
+	RequiredApplicationVersion uint32 `json:"required_application_version"`
+}
+
+type PatchExtendedHeader struct {
+	ApplicationID         uint64 `json:"application_id"`
+	RequiredSystemVersion uint32 `json:"required_system_version"`
+	ExtendedDataSize      uint32 `json:"extended_data_size"`
+	_                     [8]byte
+}
+
+type AddOnContentExtendedHeader struct {
+	ApplicationID              uint64 `json:"application_id"`
+	RequiredApplicationVersion uint32 `json:"required_application_version"`
+	_                          uint32
+}
+
+type DeltaExtendedHeader struct {
+	ApplicationID    uint64 `json:"application_id"`
+	ExtendedDataSize uint32 `json:"extended_data_size"`
+	_                uint32
+}
+
+type SystemUpdateExtendedHeader struct {
+	ExtendedDataSize uint32 `json:"extended_data_size"`
+}
+
+type cnmtHeader struct {
+	TitleID                       uint64
+	Version                       uint32
+	Type                          uint8
+	_                             uint8
+	ExtendedHeaderSize            uint16
+	ContentCount                  uint16
+	ContentMetaCount              uint16
+	Attributes                    uint8
+	StorageID                     uint8
+	InstallType                   uint8
+	_                             uint8
+	RequiredDownloadSystemVersion uint32
+	_                             uint32
+}
+
+type cnmtContentEntry struct {
+	Hash     [0x20]byte
+	ID       [0x10]byte
+	Size     [6]byte
+	Type     uint8
+	IDOffset uint8
+}
+
+type cnmtContentMetaEntry struct {
+	ID         uint64
+	Version    uint32
+	Type       uint8
+	Attributes uint8
+	_          uint16
+}
+
+func (c CNMT) IDString() string {
+	return fmt.Sprintf("%016x", c.ID)
+}
+
+func (c CNMT) DigestString() string {
+	return hex.EncodeToString(c.Digest)
+}
+
+func (c CNMT) MasterKeyRevisionString() string {
+	return fmt.Sprintf("%02x", c.MasterKeyRevision)
+}
+
+// RequiredFirmware returns the system version the title needs, 0 if it
+// doesn't need any.
+func (c CNMT) RequiredFirmware() Version {
+	return Version(c.RequiredSystemVersion)
+}
+
+// RequiredFirmwareString describes RequiredFirmware for display, like
+// "requires firmware >= 16.0.0".
+func (c CNMT) RequiredFirmwareString() string {
+	if c.RequiredSystemVersion == 0 {
+		return "requires no specific firmware"
+	}
+
+	return "requires firmware >= " + c.RequiredFirmware().FirmwareString()
+}
+
+// SupportedBy reports whether a console on firmware sv can run the title.
+func (c CNMT) SupportedBy(sv SystemVersion) bool {
+	// the bugfix part is a build number the SystemVersion doesn't have
+	return (c.RequiredFirmware() &^ 0xFFFF).Compare(sv.Version()) <= 0
+}
+
+func (ce ContentEntry) IDString() string {
+	return hex.EncodeToString(ce.ID)
+}
+
+func (ce ContentEntry) HashString() string {
+	return hex.EncodeToString(ce.Hash)
+}
+
+// MarshalJSON writes IDs, hashes and the digest as hex strings the same way
+// they appear in file names and the cnmt xml, and the required system
+// version in its display form too.
+func (c CNMT) MarshalJSON() ([]byte, error) {
+	firmware := ""
+	if c.RequiredSystemVersion != 0 {
+		firmware = c.RequiredFirmware().FirmwareString()
+	}
+
+	return json.Marshal(struct {
+		Type                          string             `json:"type"`
+		ID                            string             `json:"id"`
+		Version                       uint32             `json:"version"`
+		RequiredSystemVersion         uint32             `json:"required_system_version"`
+		RequiredFirmware              string             `json:"required_firmware,omitempty"`
+		RequiredDownloadSystemVersion uint32             `json:"required_download_system_version"`
+		Digest                        string             `json:"digest"`
+		MasterKeyRevision             uint8              `json:"master_key_revision"`
+		ContentEntries                []ContentEntry     `json:"content_entries"`
+		ExtendedHeader                interface{}        `json:"extended_header,omitempty"`
+		ContentMetaEntries            []ContentMetaEntry `json:"content_meta_entries,omitempty"`
+	}{
+		c.Type,
+		c.IDString(),
+		c.Version,
+		c.RequiredSystemVersion,
+		firmware,
+		c.RequiredDownloadSystemVersion,
+		c.DigestString(),
+		c.MasterKeyRevision,
+		c.ContentEntries,
+		c.ExtendedHeader,
+		c.ContentMetaEntries,
+	})
+}
+
+func (ce ContentEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID   string `json:"id"`
+		Hash string `json:"hash"`
+		Size int64  `json:"size"`
+		Type string `json:"type"`
+	}{
+		ce.IDString(),
+		ce.HashString(),
+		ce.Size,
+		ce.Type,
+	})
+}
+
+func (ce *ContentEntry) UnmarshalJSON(data []byte) error {
+	v := struct {
+		ID   string `json:"id"`
+		Hash string `json:"hash"`
+		Size int64  `json:"size"`
+		Type string `json:"type"`
+	}{}
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	ce.ID, err = hex.DecodeString(v.ID)
+	if err != nil {
+		return err
+	}
+
+	ce.Hash = nil
+	if v.Hash != "" {
+		ce.Hash, err = hex.DecodeString(v.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	ce.Size, ce.Type = v.Size, v.Type
+
+	return nil
+}
+
+func (cme ContentMetaEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID         string `json:"id"`
+		Version    uint32 `json:"version"`
+		Type       string `json:"type"`
+		Attributes uint8  `json:"attributes"`
+	}{
+		fmt.Sprintf("%016x", cme.ID),
+		cme.Version,
+		cme.Type,
+		cme.Attributes,
+	})
+}
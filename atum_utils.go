@@ -3,26 +3,8 @@ package libhac
 import (
 	"encoding/binary"
 	"encoding/hex"
-	"os"
 )
 
-func readHex(file *os.File, offset int64, size int64, whence int) (string, error) {
-	_, err := file.Seek(offset, whence)
-	if err != nil {
-		return "", err
-	}
-
-	s := make([]byte, size)
-	_, err = file.Read(s)
-	if err != nil {
-		return "", err
-	}
-
-	d := hex.EncodeToString(s)
-
-	return d, nil
-}
-
 func getHexBytes(in string) ([]byte, error) {
 	d, err := hex.DecodeString(in)
 	if err != nil {
@@ -46,56 +28,48 @@ func toBinary64(in int64) []byte {
 	return out
 }
 
-func getCNMTType(val string) string {
+func getCNMTType(val uint8) string {
 	switch val {
-	case "80":
+	case 0x01:
+		return "SystemProgram"
+	case 0x02:
+		return "SystemData"
+	case 0x03:
+		return "SystemUpdate"
+	case 0x04:
+		return "BootImagePackage"
+	case 0x05:
+		return "BootImagePackageSafe"
+	case 0x80:
 		return "Application"
-	case "81":
+	case 0x81:
 		return "Patch"
-	case "82":
+	case 0x82:
 		return "AddOnContent"
-	case "83":
+	case 0x83:
 		return "Delta"
 	}
 
 	return ""
 }
 
-func getNCAType(val string) string {
+func getNCAType(val uint8) string {
 	switch val {
-	case "00":
+	case 0x0:
 		return "Meta"
-	case "01":
+	case 0x1:
 		return "Program"
-	case "02":
+	case 0x2:
 		return "Data"
-	case "03":
+	case 0x3:
 		return "Control"
-	case "04":
+	case 0x4:
 		return "HtmlDocument"
-	case "05":
+	case 0x5:
 		return "LegalInformation"
-	case "06":
+	case 0x6:
 		return "DeltaFragment"
 	}
 
 	return ""
 }
-
-func sum(array []int) int {
-	n := 0
-	for _, v := range array {
-		n += v
-	}
-
-	return n
-}
-
-func sum64(array []int64) int {
-	n := 0
-	for _, v := range array {
-		n += int(v)
-	}
-
-	return n
-}
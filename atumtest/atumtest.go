@@ -0,0 +1,201 @@
+// Package atumtest provides a fake cdn for testing code built on libhac
+// without network access or real credentials.
+//
+//	s := atumtest.NewServer()
+//	defer s.Close()
+//	s.AddTitle("0100000000010000", 0, cnmtID, cnmtNCA)
+//	s.AddContent(ncaID, nca)
+//
+//	c, err := s.Client()
+//	id, err := c.GetCNMTID("0100000000010000", 0)
+//
+// Titles downloaded with DownloadTitle have their meta decrypted, so they
+// need NCAs encrypted with the keys the client is given.
+package atumtest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakibaki/libhac"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// DeviceCert is the only client certificate the server accepts.
+	DeviceCert tls.Certificate
+	// EdgeToken is required on every request if set, requests without it
+	// get a 401 like the real cdn returns.
+	EdgeToken string
+
+	mu       sync.Mutex
+	titles   map[string]string
+	content  map[string][]byte
+	requests []string
+}
+
+// NewServer starts a fake cdn which requires client certificates.
+func NewServer() *Server {
+	s := &Server{
+		titles:  map[string]string{},
+		content: map[string][]byte{},
+	}
+
+	device, err := newDeviceCert()
+	if err != nil {
+		panic(fmt.Sprintf("atumtest: %v", err))
+	}
+	s.DeviceCert = device
+
+	pool := x509.NewCertPool()
+	pool.AddCert(device.Leaf)
+
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.Server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	s.Server.StartTLS()
+
+	return s
+}
+
+func newDeviceCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "NX0000000000000000"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// Client returns a client using the server's device certificate and edge
+// token, with atum requests pointed at the server.
+func (s *Server) Client() (libhac.HacClient, error) {
+	c, err := libhac.NewHacClientFromCertificate(s.DeviceCert, "", s.EdgeToken)
+	if err != nil {
+		return libhac.HacClient{}, err
+	}
+
+	c.RootCAs = x509.NewCertPool()
+	c.RootCAs.AddCert(s.Certificate())
+	c.BaseURLs = map[string]string{libhac.ServiceAtum: s.URL}
+
+	return c, nil
+}
+
+// AddTitle publishes version ver of tid with the meta nca cnmt.
+func (s *Server) AddTitle(tid string, ver int, cnmtID string, cnmt []byte) {
+	s.addTitle("a", tid, ver, cnmtID, cnmt)
+}
+
+// AddSystemTitle is AddTitle for system titles, which the cdn serves from
+// different paths.
+func (s *Server) AddSystemTitle(tid string, ver int, cnmtID string, cnmt []byte) {
+	s.addTitle("s", tid, ver, cnmtID, cnmt)
+}
+
+func (s *Server) addTitle(kind, tid string, ver int, cnmtID string, cnmt []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.titles[fmt.Sprintf("/t/%s/%s/%d", kind, strings.ToLower(tid), ver)] = strings.ToLower(cnmtID)
+	s.content["/c/"+kind+"/"+strings.ToLower(cnmtID)] = cnmt
+}
+
+// AddContent publishes a content entry's nca under its id.
+func (s *Server) AddContent(id string, nca []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.content["/c/c/"+strings.ToLower(id)] = nca
+}
+
+// AddCetk publishes the ticket with its certificate chain appended for
+// rightsID.
+func (s *Server) AddCetk(rightsID string, cetk []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.content["/r/t/"+strings.ToLower(rightsID)] = cetk
+}
+
+// Requests returns "METHOD path" for every request served so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	cnmtID, isTitle := s.titles[r.URL.Path]
+	data, isContent := s.content[r.URL.Path]
+	s.mu.Unlock()
+
+	if s.EdgeToken != "" && r.Header.Get("X-Nintendo-DenebEdgeToken") != s.EdgeToken {
+		writeError(w, http.StatusUnauthorized, "invalid edge token")
+		return
+	}
+
+	switch {
+	case isTitle && (r.Method == "HEAD" || r.Method == "GET"):
+		w.Header().Set("X-Nintendo-Content-ID", cnmtID)
+	case isContent && (r.Method == "HEAD" || r.Method == "GET"):
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(libhac.ErrorResponse{Errors: []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{{strconv.Itoa(status), msg}}})
+}
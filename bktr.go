@@ -0,0 +1,366 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Update NCAs store their RomFS as a patch on the RomFS of the base NCA.
+// The relocation table maps virtual offsets of the patched section to
+// either the base section or the patch section, the subsection table gives
+// the AES-CTR counter for ranges of the patch section.
+
+const bktrBucketSize = 0x4000
+
+type bktrHeader struct {
+	Offset     uint64
+	Size       uint64
+	Magic      [4]byte
+	Version    uint32
+	NumEntries uint32
+	_          uint32
+}
+
+type bktrBlockHeader struct {
+	_          uint32
+	NumBuckets uint32
+	TotalSize  uint64
+}
+
+type bktrBucketHeader struct {
+	_          uint32
+	NumEntries uint32
+	EndOffset  uint64
+}
+
+type bktrRelocationEntry struct {
+	VirtualOffset  uint64
+	PhysicalOffset uint64
+	IsPatch        uint32
+}
+
+type bktrSubsectionEntry struct {
+	Offset uint64
+	_      uint32
+	Ctr    uint32
+}
+
+// bktrReader presents the patched section of an update NCA.
+type bktrReader struct {
+	base        io.ReaderAt
+	patch       *bktrPatchReader
+	relocations []bktrRelocationEntry
+	size        int64
+}
+
+func (b *bktrReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	read := 0
+	for read < len(p) && off < b.size {
+		i := sort.Search(len(b.relocations), func(i int) bool {
+			return int64(b.relocations[i].VirtualOffset) > off
+		}) - 1
+		if i < 0 {
+			return read, errors.New("bktr offset before the first relocation")
+		}
+
+		e := b.relocations[i]
+		end := b.size
+		if i+1 < len(b.relocations) {
+			end = int64(b.relocations[i+1].VirtualOffset)
+		}
+
+		chunk := p[read:]
+		if int64(len(chunk)) > end-off {
+			chunk = chunk[:end-off]
+		}
+
+		src := b.base
+		if e.IsPatch != 0 {
+			src = b.patch
+		}
+
+		n, err := src.ReadAt(chunk, int64(e.PhysicalOffset)+off-int64(e.VirtualOffset))
+		read += n
+		off += int64(n)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// bktrPatchReader decrypts the patch section, relative to its start, with the
+// counter of the subsection of each offset.
+type bktrPatchReader struct {
+	r           io.ReaderAt
+	block       cipher.Block
+	upper       uint64
+	start       int64
+	size        int64
+	subsections []bktrSubsectionEntry
+}
+
+func (b *bktrPatchReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	read := 0
+	for read < len(p) && off < b.size {
+		i := sort.Search(len(b.subsections), func(i int) bool {
+			return int64(b.subsections[i].Offset) > off
+		}) - 1
+		if i < 0 {
+			return read, errors.New("bktr offset before the first subsection")
+		}
+
+		end := b.size
+		if i+1 < len(b.subsections) {
+			end = int64(b.subsections[i+1].Offset)
+		}
+
+		chunk := p[read:]
+		if int64(len(chunk)) > end-off {
+			chunk = chunk[:end-off]
+		}
+
+		// the counter of the subsection replaces the lower half of the
+		// section's upper counter
+		upper := b.upper&^0xFFFFFFFF | uint64(b.subsections[i].Ctr)
+
+		n, err := b.r.ReadAt(chunk, b.start+off)
+		xorCTR(b.block, upper, b.start+off, chunk[:n])
+		read += n
+		off += int64(n)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// patchedSection returns the patched section i of the update NCA n on top
+// of the same section of base.
+func (n *ncaFile) patchedSection(i int, base *ncaFile) (*bktrReader, error) {
+	fs := n.header.FsHeaders[i]
+	if fs.EncryptionType != 4 {
+		return nil, fmt.Errorf("nca section %d is not a bktr section", i)
+	}
+
+	if fs.sparse() || fs.compressed() {
+		return nil, &StorageError{i, fs.sparse(), fs.compressed()}
+	}
+
+	headers := [2]bktrHeader{}
+	err := binary.Read(bytes.NewReader(fs.PatchInfo[:]), binary.LittleEndian, &headers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range headers {
+		if string(v.Magic[:]) != "BKTR" {
+			return nil, errors.New("invalid bktr magic")
+		}
+	}
+
+	baseSection, err := base.sectionReader(i)
+	if err != nil {
+		return nil, fmt.Errorf("base nca: %v", err)
+	}
+
+	key, err := n.ctrKey()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := n.header.Sections[i]
+	start := int64(s.StartOffset) * 0x200
+	size := int64(s.EndOffset-s.StartOffset) * 0x200
+
+	// the tables themselves use the section's own counter
+	ctr, err := newCTRReaderAt(n.r, key, fs.UpperCounter)
+	if err != nil {
+		return nil, err
+	}
+	tables := io.NewSectionReader(ctr, start, size)
+
+	relocations := []bktrRelocationEntry{}
+	total, err := readBKTRBlock(tables, headers[0], 0x14, func(r io.Reader) error {
+		e := bktrRelocationEntry{}
+		err := binary.Read(r, binary.LittleEndian, &e)
+		relocations = append(relocations, e)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subsections := []bktrSubsectionEntry{}
+	_, err = readBKTRBlock(tables, headers[1], 0x10, func(r io.Reader) error {
+		e := bktrSubsectionEntry{}
+		err := binary.Read(r, binary.LittleEndian, &e)
+		subsections = append(subsections, e)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// everything from the relocation table on isn't covered by a subsection
+	subsections = append(subsections, bktrSubsectionEntry{Offset: headers[0].Offset, Ctr: uint32(fs.UpperCounter)})
+
+	patch := &bktrPatchReader{n.r, block, fs.UpperCounter, start, size, subsections}
+
+	return &bktrReader{baseSection, patch, relocations, int64(total)}, nil
+}
+
+// readBKTRBlock reads the buckets of a relocation or subsection table,
+// calling entry for each of their entries in order, and returns the total
+// size the table covers.
+func readBKTRBlock(r io.ReaderAt, h bktrHeader, entrySize int, entry func(io.Reader) error) (uint64, error) {
+	if h.Size < bktrBucketSize || h.Size > 0x7FF*bktrBucketSize {
+		return 0, fmt.Errorf("invalid bktr table size %d", h.Size)
+	}
+
+	data := make([]byte, h.Size)
+	_, err := r.ReadAt(data, int64(h.Offset))
+	if err != nil {
+		return 0, err
+	}
+
+	bh := bktrBlockHeader{}
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &bh)
+	if err != nil {
+		return 0, err
+	}
+
+	if (uint64(bh.NumBuckets)+1)*bktrBucketSize > h.Size {
+		return 0, errors.New("bktr table has more buckets than fit")
+	}
+
+	maxEntries := (bktrBucketSize - 0x10) / entrySize
+	for b := 0; b < int(bh.NumBuckets); b++ {
+		bucket := bytes.NewReader(data[(b+1)*bktrBucketSize : (b+2)*bktrBucketSize])
+
+		bu := bktrBucketHeader{}
+		err = binary.Read(bucket, binary.LittleEndian, &bu)
+		if err != nil {
+			return 0, err
+		}
+
+		if int(bu.NumEntries) > maxEntries {
+			return 0, errors.New("bktr bucket has more entries than fit")
+		}
+
+		for i := 0; i < int(bu.NumEntries); i++ {
+			err = entry(bucket)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return bh.TotalSize, nil
+}
+
+// OpenPatchedRomFS returns the RomFS of an update NCA applied on top of the
+// RomFS of its base NCA, ready for ReadRomFS or ExtractRomFS.
+func OpenPatchedRomFS(base, update io.ReaderAt, keys *Keyset) (*io.SectionReader, error) {
+	bn, err := openNCA(base, keys)
+	if err != nil {
+		return nil, fmt.Errorf("base nca: %v", err)
+	}
+
+	un, err := openNCA(update, keys)
+	if err != nil {
+		return nil, fmt.Errorf("update nca: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		fs := un.header.FsHeaders[i]
+		if !un.sectionExists(i) || fs.FsType != 0 || fs.EncryptionType != 4 {
+			continue
+		}
+
+		section, err := un.patchedSection(i, bn)
+		if err != nil {
+			return nil, err
+		}
+
+		ivfc := ivfcSuperblock{}
+		err = binary.Read(bytes.NewReader(fs.HashInfo[:]), binary.LittleEndian, &ivfc)
+		if err != nil {
+			return nil, err
+		}
+
+		if string(ivfc.Magic[:]) != "IVFC" {
+			return nil, errors.New("invalid ivfc magic")
+		}
+
+		l, err := ivfc.dataLevel()
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NewSectionReader(section, int64(l.Offset), int64(l.Size)), nil
+	}
+
+	return nil, errors.New("update nca has no bktr romfs section")
+}
+
+// ExtractPatchedRomFS writes the RomFS of the update NCA at updatePath,
+// patched onto the base NCA at basePath, to out.
+func ExtractPatchedRomFS(basePath, updatePath, out string, keys *Keyset) error {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	update, err := os.Open(updatePath)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	romfs, err := OpenPatchedRomFS(base, update, keys)
+	if err != nil {
+		return err
+	}
+
+	return ExtractRomFS(romfs, out)
+}
@@ -0,0 +1,280 @@
+package libhac
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// BuildOptions configures BuildNSPFromCDN, the zero value downloads to a
+// temporary directory and only packs titles that have a cetk on the cdn or
+// don't need a ticket.
+type BuildOptions struct {
+	// WorkDir holds the downloads while building and is left as is
+	// afterwards. If empty a temporary directory is used and removed once
+	// the NSP is packed.
+	WorkDir string
+	// TitleKey is used to generate a ticket for titles without a cetk on
+	// the cdn, if TitleKeyDB doesn't know the titlekey. TicketTemplate and
+	// CertChain work like in ConvertOptions.
+	TitleKey       string
+	TitleKeyDB     *TitleKeyDB
+	TicketTemplate string
+	CertChain      string
+	// Namer is optional and names the NSP if outPath is a directory, the
+	// default Namer is used otherwise.
+	Namer *Namer
+	// Order defaults to DefaultNSPOrder.
+	Order NSPOrder
+}
+
+// BuildNSPFromCDN downloads version ver of a title, generates its cnmt.xml
+// and, for titles without a cetk, its ticket from a known titlekey, and
+// packs it all into an installable NSP at outPath. If outPath is an
+// existing directory the NSP is named by Namer inside it. The path of the
+// NSP is returned. Titles that need a ticket which can't be downloaded or
+// generated fail rather than producing an NSP that can't be installed.
+func (c *HacClient) BuildNSPFromCDN(tid string, ver int, outPath string, opts BuildOptions) (string, error) {
+	return c.BuildNSPFromCDNContext(context.Background(), tid, ver, outPath, opts)
+}
+
+func (c *HacClient) BuildNSPFromCDNContext(ctx context.Context, tid string, ver int, outPath string, opts BuildOptions) (string, error) {
+	ctx, span := c.startSpan(ctx, "BuildNSPFromCDN", map[string]interface{}{"title_id": tid, "version": ver, "out": outPath})
+	path, err := c.buildNSPFromCDN(ctx, tid, ver, outPath, opts)
+	span.End(err)
+
+	return path, err
+}
+
+func (c *HacClient) buildNSPFromCDN(ctx context.Context, tid string, ver int, outPath string, opts BuildOptions) (string, error) {
+	dir := opts.WorkDir
+	if dir == "" {
+		tmp, err := ioutil.TempDir("", "libhac")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	m, err := c.DownloadTitleContext(ctx, tid, ver, dir)
+	if err != nil {
+		return "", err
+	}
+
+	entries := []NSPEntry{}
+	for _, v := range m.Files {
+		f, err := os.Open(v)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+
+		entries = append(entries, NSPEntry{filepath.Base(v), fi.Size(), f})
+	}
+
+	xml, err := cnmtXMLFor(m)
+	if err != nil {
+		return "", err
+	}
+	entries = append(entries, NSPEntry{m.CNMTID + ".cnmt.xml", int64(len(xml)), bytes.NewReader(xml)})
+
+	if m.TicketPath == "" {
+		extra, err := buildTicketEntries(m.CNMT, opts)
+		if err != nil {
+			return "", err
+		}
+
+		if len(extra) == 0 {
+			err = c.checkNoRightsID(m)
+			if err != nil {
+				return "", err
+			}
+		}
+		entries = append(entries, extra...)
+	}
+
+	out, err := c.nspPath(m, outPath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	order := opts.Order
+	if order == nil {
+		order = DefaultNSPOrder
+	}
+	SortNSPEntries(entries, order)
+
+	logf(c.Log, "packing %s", out)
+	f, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	err = PackEntries(f, entries)
+	if err != nil {
+		return "", err
+	}
+
+	return out, f.Close()
+}
+
+func cnmtXMLFor(m TitleManifest) ([]byte, error) {
+	f, err := os.Open(m.CNMTPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return generateCNMTXML(m.CNMT, size, h.Sum(nil))
+}
+
+// buildTicketEntries generates the ticket and certificate of a title whose
+// cetk isn't on the cdn, if its titlekey is known.
+func buildTicketEntries(cnmt CNMT, opts BuildOptions) ([]NSPEntry, error) {
+	rightsID := GetRightsID(cnmt.IDString(), cnmt.MasterKeyRevisionString())
+
+	titleKey := opts.TitleKey
+	if opts.TitleKeyDB != nil {
+		if tk, ok := opts.TitleKeyDB.Get(rightsID); ok {
+			titleKey = tk
+		}
+	}
+
+	if titleKey == "" {
+		return nil, nil
+	}
+
+	tik, _, err := titleTicket(cnmt, ConvertOptions{TitleKey: titleKey, TicketTemplate: opts.TicketTemplate})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []NSPEntry{{rightsID + ".tik", int64(len(tik)), bytes.NewReader(tik)}}
+
+	if opts.CertChain != "" {
+		cert, err := ReadCertChain(opts.CertChain)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, NSPEntry{rightsID + ".cert", int64(len(cert)), bytes.NewReader(cert)})
+	}
+
+	return entries, nil
+}
+
+// checkNoRightsID fails if content of a title without a ticket uses a
+// titlekey, an NSP of it couldn't be installed. Without keys the headers
+// can't be read, so a ticket is assumed to be needed.
+func (c *HacClient) checkNoRightsID(m TitleManifest) error {
+	rightsID := GetRightsID(m.CNMT.IDString(), m.CNMT.MasterKeyRevisionString())
+	if c.Keys == nil {
+		return fmt.Errorf("no ticket for rights id %s and no keys to check if it needs one, set TitleKey or TitleKeyDB", rightsID)
+	}
+
+	for _, v := range m.Files {
+		h, err := ParseNCAHeader(v, c.Keys)
+		if err != nil {
+			return err
+		}
+
+		if h.HasRightsID() {
+			return fmt.Errorf("%s needs a ticket for rights id %s but the titlekey isn't known, set TitleKey or TitleKeyDB",
+				filepath.Base(v), h.RightsID)
+		}
+	}
+
+	return nil
+}
+
+// nspPath returns outPath, or a name from the Namer inside it if it's a
+// directory.
+func (c *HacClient) nspPath(m TitleManifest, outPath string, opts BuildOptions) (string, error) {
+	fi, err := os.Stat(outPath)
+	if err != nil || !fi.IsDir() {
+		return outPath, nil
+	}
+
+	namer := Namer{TitleDB: c.TitleDB}
+	if opts.Namer != nil {
+		namer = *opts.Namer
+	}
+
+	name, err := namer.Name(m.CNMT, c.controlNACP(m), "nsp")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(outPath, name), nil
+}
+
+// controlNACP reads the NACP of a downloaded title, or returns nil if it
+// has none or it can't be decrypted.
+func (c *HacClient) controlNACP(m TitleManifest) *NACP {
+	if c.Keys == nil {
+		return nil
+	}
+
+	keys := *c.Keys
+	if m.TicketPath != "" {
+		t, err := ReadTicket(m.TicketPath)
+		if err != nil {
+			return nil
+		}
+
+		tk, err := t.TitleKey()
+		if err != nil {
+			return nil
+		}
+
+		keys.TitleKeys = map[string][]byte{}
+		for k, v := range c.Keys.TitleKeys {
+			keys.TitleKeys[k] = v
+		}
+		keys.TitleKeys[t.RightsIDString()] = tk
+	}
+
+	for _, ce := range m.CNMT.ContentEntries {
+		if ce.Type != "Control" {
+			continue
+		}
+
+		nca, err := OpenNCA(filepath.Join(filepath.Dir(m.CNMTPath), ce.IDString()+".nca"), &keys)
+		if err != nil {
+			return nil
+		}
+		defer nca.Close()
+
+		romfs, err := nca.RomFS()
+		if err != nil {
+			return nil
+		}
+
+		nacp, err := ControlNACP(romfs)
+		if err != nil {
+			return nil
+		}
+
+		return &nacp
+	}
+
+	return nil
+}
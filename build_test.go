@@ -0,0 +1,185 @@
+package libhac_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jakibaki/libhac"
+	"github.com/jakibaki/libhac/atumtest"
+)
+
+const (
+	buildTestTitleID = "0100000000010000"
+	buildTestCNMTID  = "aabbccddeeff00112233445566778899"
+)
+
+// newBuildTestServer publishes version 0 of a title with a single program
+// nca and no cetk.
+func newBuildTestServer(t *testing.T, keys *libhac.Keyset) (*atumtest.Server, string) {
+	pfs := &bytes.Buffer{}
+	w := libhac.NewNSPWriter(pfs)
+	w.Add("main.npdm", 5, strings.NewReader("hello"))
+	w.Close()
+
+	b := libhac.NewNCABuilder(0, 0, keys)
+	b.ProgramID = 0x0100000000010000
+	b.AddPFS0(bytes.NewReader(pfs.Bytes()), int64(pfs.Len()))
+	program := &bytes.Buffer{}
+	_, err := b.WriteTo(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(program.Bytes())
+	contentID := hex.EncodeToString(hash[:16])
+
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(program.Len()))
+
+	cnmt := &bytes.Buffer{}
+	binary.Write(cnmt, binary.LittleEndian, uint64(0x0100000000010000))
+	binary.Write(cnmt, binary.LittleEndian, uint32(0))
+	cnmt.Write([]byte{0x80, 0})
+	binary.Write(cnmt, binary.LittleEndian, uint16(0x10))
+	binary.Write(cnmt, binary.LittleEndian, uint16(1))
+	binary.Write(cnmt, binary.LittleEndian, uint16(0))
+	cnmt.Write(make([]byte, 12))
+	binary.Write(cnmt, binary.LittleEndian, uint64(0x0100000000010800))
+	cnmt.Write(make([]byte, 8))
+	cnmt.Write(hash[:])
+	cnmt.Write(hash[:16])
+	cnmt.Write(size[:6])
+	cnmt.Write([]byte{1, 0})
+	cnmt.Write(make([]byte, 0x20))
+
+	meta := &bytes.Buffer{}
+	w = libhac.NewNSPWriter(meta)
+	w.Add("Application_"+buildTestTitleID+".cnmt", int64(cnmt.Len()), bytes.NewReader(cnmt.Bytes()))
+	w.Close()
+
+	b = libhac.NewNCABuilder(1, 0, keys)
+	b.ProgramID = 0x0100000000010000
+	b.AddPFS0(bytes.NewReader(meta.Bytes()), int64(meta.Len()))
+	metaNCA := &bytes.Buffer{}
+	_, err = b.WriteTo(metaNCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := atumtest.NewServer()
+	s.AddTitle(buildTestTitleID, 0, buildTestCNMTID, metaNCA.Bytes())
+	s.AddContent(contentID, program.Bytes())
+
+	return s, contentID
+}
+
+func newBuildTestKeys() *libhac.Keyset {
+	keys := libhac.NewKeyset()
+	keys.HeaderKey = bytes.Repeat([]byte{1}, 0x20)
+	keys.KeyAreaKeyApplication[0] = bytes.Repeat([]byte{2}, 0x10)
+
+	return keys
+}
+
+func nspEntryNames(t *testing.T, path string) []string {
+	nsp, err := libhac.OpenNSP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nsp.Close()
+
+	names := []string{}
+	for _, v := range nsp.Entries {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestBuildNSPFromCDN(t *testing.T) {
+	keys := newBuildTestKeys()
+	s, contentID := newBuildTestServer(t, keys)
+	defer s.Close()
+
+	c, err := s.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Keys = keys
+
+	out := filepath.Join(t.TempDir(), "title.nsp")
+	path, err := c.BuildNSPFromCDN(buildTestTitleID, 0, out, libhac.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != out {
+		t.Errorf("got path %s, want %s", path, out)
+	}
+
+	want := []string{buildTestCNMTID + ".cnmt.nca", buildTestCNMTID + ".cnmt.xml", contentID + ".nca"}
+	sort.Strings(want)
+	if got := nspEntryNames(t, path); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+
+	results, err := libhac.VerifyNSP(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range results {
+		if !v.OK {
+			t.Errorf("%s failed verification: %s", v.Name, v.Reason)
+		}
+	}
+}
+
+func TestBuildNSPFromCDNTitleKey(t *testing.T) {
+	keys := newBuildTestKeys()
+	s, _ := newBuildTestServer(t, keys)
+	defer s.Close()
+
+	c, err := s.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Keys = keys
+
+	opts := libhac.BuildOptions{TitleKey: strings.Repeat("ab", 0x10)}
+	path, err := c.BuildNSPFromCDN(buildTestTitleID, 0, filepath.Join(t.TempDir(), "title.nsp"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tik := libhac.GetRightsID(buildTestTitleID, "00") + ".tik"
+	for _, v := range nspEntryNames(t, path) {
+		if v == tik {
+			return
+		}
+	}
+	t.Errorf("%s is missing from the nsp", tik)
+}
+
+func TestBuildNSPFromCDNNoTicket(t *testing.T) {
+	keys := newBuildTestKeys()
+	s, _ := newBuildTestServer(t, keys)
+	defer s.Close()
+
+	c, err := s.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without keys the content can't be checked for a rights id, so the
+	// missing ticket has to be an error
+	c.Decryptor = libhac.NativeDecryptor{Keys: keys}
+
+	_, err = c.BuildNSPFromCDN(buildTestTitleID, 0, filepath.Join(t.TempDir(), "title.nsp"), libhac.BuildOptions{})
+	if err == nil || !strings.Contains(err.Error(), "ticket") {
+		t.Fatalf("got %v, want an error about the missing ticket", err)
+	}
+}
@@ -0,0 +1,162 @@
+package libhac
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// MetadataCache keeps cdn lookups like content meta ids and the version list
+// for TTL, in memory and optionally on disk so they survive between runs.
+// Titles missing from the cdn are cached too.
+type MetadataCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	f       *os.File
+}
+
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Expires time.Time       `json:"expires"`
+}
+
+func NewMetadataCache(ttl time.Duration) *MetadataCache {
+	return &MetadataCache{TTL: ttl, entries: map[string]cacheEntry{}}
+}
+
+// OpenMetadataCache loads the cache at path, a json-lines file every new
+// entry is appended to. Expired entries are dropped when it's opened.
+func OpenMetadataCache(path string, ttl time.Duration) (*MetadataCache, error) {
+	m := NewMetadataCache(ttl)
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		now := time.Now()
+
+		s := bufio.NewScanner(f)
+		s.Buffer(nil, 16<<20)
+		for s.Scan() {
+			e := cacheEntry{}
+			if json.Unmarshal(s.Bytes(), &e) == nil && e.Expires.After(now) {
+				m.entries[e.Key] = e
+			}
+		}
+		f.Close()
+
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp := path + ".tmp"
+	f, err = os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, v := range m.entries {
+		err = enc.Encode(v)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *MetadataCache) Close() error {
+	if m == nil || m.f == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.f.Close()
+}
+
+// Clear drops every entry, on disk only once the cache is opened again.
+func (m *MetadataCache) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = map[string]cacheEntry{}
+	if m.f != nil {
+		m.f.Truncate(0)
+	}
+}
+
+// get unmarshals the value of key into v and returns true if it's cached
+// and not expired.
+func (m *MetadataCache) get(key string, v interface{}) bool {
+	if m == nil {
+		return false
+	}
+
+	m.mu.Lock()
+	e, ok := m.entries[key]
+	m.mu.Unlock()
+
+	if !ok || time.Now().After(e.Expires) {
+		return false
+	}
+
+	return json.Unmarshal(e.Value, v) == nil
+}
+
+// set caches v for key, failing to write it to disk only loses it for the
+// next run.
+func (m *MetadataCache) set(key string, v interface{}) {
+	if m == nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	e := cacheEntry{key, data, time.Now().Add(m.TTL)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = e
+
+	if m.f != nil {
+		line, err := json.Marshal(e)
+		if err == nil {
+			m.f.Write(append(line, '\n'))
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package libhac
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CDNClient is what the title helpers need from the cdn. HacClient implements
+// it, the From helpers take any implementation, e.g. a LocalMirror, and
+// setting HacClient.CDN routes all of the client's cdn requests there.
+type CDNClient interface {
+	DoRequestContext(ctx context.Context, method, url string, certs []tls.Certificate,
+		sendDauthToken, sendEdgeToken bool) (*http.Response, error)
+	DownloadContext(ctx context.Context, url, path string) error
+	GetCNMTIDContext(ctx context.Context, tid string, ver int) (string, error)
+}
+
+// verifyingCDN is implemented by CDNClients that check downloads against
+// their hash themselves, to try another source if one has a bad copy.
+type verifyingCDN interface {
+	downloadVerified(ctx context.Context, url, path string, hash []byte) error
+}
+
+// downloadVerified downloads url from cdn to path, if hash is set the
+// SHA-256 of the result must match it or the file is removed.
+func downloadVerified(ctx context.Context, cdn CDNClient, url, path string, hash []byte) error {
+	if v, ok := cdn.(verifyingCDN); ok {
+		return v.downloadVerified(ctx, url, path, hash)
+	}
+
+	err := cdn.DownloadContext(ctx, url, path)
+	if err != nil || hash == nil {
+		return err
+	}
+
+	return verifyDownload(path, hash, sha256.New(), false)
+}
+
+// cdnURL builds the url of path on service for cdn. HacClients use their
+// own hosts, everything else gets the default ones.
+func cdnURL(cdn CDNClient, service, path string, args ...interface{}) string {
+	c, ok := cdn.(*HacClient)
+	if !ok {
+		c = &HacClient{}
+	}
+
+	return c.serviceURL(service, path, args...)
+}
+
+// DownloadCNMTFrom downloads the meta NCA cnmtID from cdn to out.
+func DownloadCNMTFrom(ctx context.Context, cdn CDNClient, cnmtID, out string) error {
+	return cdn.DownloadContext(ctx, cdnURL(cdn, ServiceAtum, "/c/a/%s", cnmtID), out)
+}
+
+// DownloadContentEntryFrom downloads ce from cdn to out. With verify the
+// file has to match the hash of ce.
+func DownloadContentEntryFrom(ctx context.Context, cdn CDNClient, ce ContentEntry, out string, verify bool) error {
+	var hash []byte
+	if verify {
+		hash = ce.Hash
+	}
+
+	return downloadVerified(ctx, cdn, cdnURL(cdn, ServiceAtum, "/c/c/%s", ce.IDString()), out, hash)
+}
+
+// DownloadCetkFrom downloads the cetk of rightsID from cdn to out.
+func DownloadCetkFrom(ctx context.Context, cdn CDNClient, rightsID, out string) error {
+	return cdn.DownloadContext(ctx, cdnURL(cdn, ServiceAtum, "/r/t/%s", rightsID), out)
+}
+
+// LocalMirror serves cdn requests from a directory of previously downloaded
+// titles. Every directory named <tid>_v<version>, or v<version> inside one
+// named <tid>, holding a .cnmt.nca is a title. Content, metas and cetks are
+// looked up by file name anywhere below the directory, cetks are rebuilt
+// from <rights id>.tik and .cert if there's no .cetk.
+type LocalMirror struct {
+	files  map[string]string
+	titles map[string]string
+}
+
+func NewLocalMirror(dir string) (*LocalMirror, error) {
+	m := &LocalMirror{
+		files:  map[string]string{},
+		titles: map[string]string{},
+	}
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || isPartialDownload(fi.Name()) {
+			return nil
+		}
+
+		name := strings.ToLower(fi.Name())
+		m.files[name] = path
+
+		if strings.HasSuffix(name, ".cnmt.nca") {
+			if key := mirrorTitleKey(filepath.Dir(path)); key != "" {
+				m.titles[key] = strings.TrimSuffix(name, ".cnmt.nca")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// mirrorTitleKey returns "<tid>/<version>" for title directories.
+func mirrorTitleKey(dir string) string {
+	tid, ver := filepath.Base(dir), ""
+	if i := strings.LastIndex(tid, "_v"); i >= 0 {
+		tid, ver = tid[:i], tid[i+2:]
+	} else if strings.HasPrefix(tid, "v") {
+		tid, ver = filepath.Base(filepath.Dir(dir)), tid[1:]
+	}
+
+	if _, err := strconv.ParseUint(tid, 16, 64); err != nil || len(tid) != 16 {
+		return ""
+	}
+
+	v, err := strconv.Atoi(ver)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/%d", strings.ToLower(tid), v)
+}
+
+func (m *LocalMirror) GetCNMTIDContext(ctx context.Context, tid string, ver int) (string, error) {
+	id, ok := m.titles[fmt.Sprintf("%s/%d", strings.ToLower(tid), ver)]
+	if !ok {
+		return "", fmt.Errorf("%s v%d not in mirror: %w", tid, ver, ErrTitleNotOnCDN)
+	}
+
+	return id, nil
+}
+
+func (m *LocalMirror) DownloadContext(ctx context.Context, u, path string) error {
+	data, err := m.open(u)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	part := path + partSuffix
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, &contextReader{ctx, data})
+	out.Close()
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+
+	return os.Rename(part, path)
+}
+
+// DoRequestContext answers title and content requests with what the cdn
+// would, everything else gets a 404.
+func (m *LocalMirror) DoRequestContext(ctx context.Context, method, u string, certs []tls.Certificate,
+	sendDauthToken, sendEdgeToken bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return &http.Response{}, err
+	}
+
+	resp := &http.Response{
+		Status:     "404 Not Found",
+		StatusCode: http.StatusNotFound,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+
+	// /t/<kind>/<tid>/<version>
+	p := strings.Split(req.URL.Path, "/")
+	if len(p) >= 4 && p[len(p)-4] == "t" {
+		ver, err := strconv.Atoi(p[len(p)-1])
+		if err == nil {
+			if id, err := m.GetCNMTIDContext(ctx, p[len(p)-2], ver); err == nil {
+				resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+				resp.Header.Set("X-Nintendo-Content-ID", id)
+			}
+		}
+
+		return resp, nil
+	}
+
+	data, err := m.open(u)
+	if err != nil {
+		return resp, nil
+	}
+
+	resp.Status, resp.StatusCode = "200 OK", http.StatusOK
+	resp.ContentLength = data.size
+	if method == "HEAD" {
+		data.Close()
+	} else {
+		resp.Body = data
+	}
+
+	return resp, nil
+}
+
+type mirrorFile struct {
+	io.Reader
+	files []*os.File
+	size  int64
+}
+
+func (f *mirrorFile) Close() error {
+	for _, v := range f.files {
+		v.Close()
+	}
+
+	return nil
+}
+
+// open returns the file for a content (/c/<kind>/<id>) or cetk (/r/t/<rid>)
+// url.
+func (m *LocalMirror) open(u string) (*mirrorFile, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	p := strings.Split(parsed.Path, "/")
+	if len(p) < 3 {
+		return nil, fmt.Errorf("%s not in mirror", u)
+	}
+
+	id := strings.ToLower(p[len(p)-1])
+	names := []string{}
+	switch p[len(p)-3] + "/" + p[len(p)-2] {
+	case "c/c":
+		names = []string{id + ".nca"}
+	case "c/a", "c/s":
+		names = []string{id + ".cnmt.nca"}
+	case "r/t":
+		names = []string{id + ".cetk"}
+		if _, ok := m.files[names[0]]; !ok {
+			names = []string{id + ".tik", id + ".cert"}
+		}
+	}
+
+	f := &mirrorFile{}
+	readers := []io.Reader{}
+	for _, v := range names {
+		path, ok := m.files[v]
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("%s not in mirror", u)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.files = append(f.files, file)
+
+		fi, err := file.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.size += fi.Size()
+
+		readers = append(readers, file)
+	}
+
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("%s not in mirror", u)
+	}
+	f.Reader = io.MultiReader(readers...)
+
+	return f, nil
+}
@@ -0,0 +1,159 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// CertChainName is the issuer tickets are signed with, .cert files in NSPs
+// hold the chain for it.
+const CertChainName = "Root-CA00000003-XS00000020"
+
+type Certificate struct {
+	SignatureType uint32
+	Issuer        string
+	KeyType       uint32
+	Name          string
+	Raw           []byte
+}
+
+// FullName returns the issuer path of the certificate including its own name,
+// which is what signed data refers to.
+func (c Certificate) FullName() string {
+	return c.Issuer + "-" + c.Name
+}
+
+func certKeySize(t uint32) (int, error) {
+	switch t {
+	case 0:
+		return 0x200 + 0x4 + 0x34, nil
+	case 1:
+		return 0x100 + 0x4 + 0x34, nil
+	case 2:
+		return 0x3C + 0x3C, nil
+	}
+
+	return 0, fmt.Errorf("unknown certificate key type %d", t)
+}
+
+// ParseCertChain splits a concatenated certificate chain like the one
+// appended to cetks.
+func ParseCertChain(data []byte) ([]Certificate, error) {
+	certs := []Certificate{}
+
+	for off := 0; off < len(data); {
+		if len(data)-off < 4 {
+			return nil, errors.New("truncated certificate")
+		}
+
+		// unlike tickets, certificates store the signature type big endian
+		sigType := binary.BigEndian.Uint32(data[off:])
+		sigSize, padSize, err := signatureSizes(sigType)
+		if err != nil {
+			return nil, err
+		}
+
+		h := off + 4 + sigSize + padSize
+		if len(data) < h+0x88 {
+			return nil, errors.New("truncated certificate")
+		}
+
+		keyType := binary.BigEndian.Uint32(data[h+0x40:])
+		keySize, err := certKeySize(keyType)
+		if err != nil {
+			return nil, err
+		}
+
+		end := h + 0x88 + keySize
+		if len(data) < end {
+			return nil, errors.New("truncated certificate")
+		}
+
+		certs = append(certs, Certificate{
+			sigType,
+			strings.TrimRight(string(data[h:h+0x40]), "\x00"),
+			keyType,
+			strings.TrimRight(string(data[h+0x44:h+0x84]), "\x00"),
+			append([]byte{}, data[off:end]...),
+		})
+
+		off = end
+	}
+
+	return certs, nil
+}
+
+// SplitCetk splits a cetk as served by the CDN into the ticket and the
+// certificate chain following it.
+func SplitCetk(data []byte) ([]byte, []byte, error) {
+	t, err := ParseTicket(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tik, err := t.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(tik, data[:len(tik)]) {
+		return nil, nil, errors.New("cetk doesn't start with a ticket")
+	}
+
+	cert := data[len(tik):]
+	if len(cert) == 0 {
+		return nil, nil, errors.New("cetk has no certificate chain")
+	}
+
+	_, err = ParseCertChain(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tik, cert, nil
+}
+
+// ExtractCetk writes the ticket and certificate chain of a cetk to separate
+// files, the way they're expected inside NSPs.
+func ExtractCetk(cetkPath, tikOut, certOut string) error {
+	data, err := ioutil.ReadFile(cetkPath)
+	if err != nil {
+		return err
+	}
+
+	tik, cert, err := SplitCetk(data)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(tikOut, tik, 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(certOut, cert, 0600)
+}
+
+// ReadCertChain reads a .cert file or a cetk and returns the certificate
+// chain in it.
+func ReadCertChain(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ParseCertChain(data); err == nil {
+		return data, nil
+	}
+
+	_, cert, err := SplitCetk(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a certificate chain nor a cetk: %v", path, err)
+	}
+
+	return cert, nil
+}
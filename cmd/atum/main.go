@@ -0,0 +1,375 @@
+// Command atum downloads, packs and inspects titles using libhac.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jakibaki/libhac"
+)
+
+const usage = `usage: atum [flags] <command> [args]
+
+commands:
+  download <tid> [version]  download a title into a directory
+  pack <dir>                pack a directory into an nsp
+  merge <nsp|dir>...        pack several titles into one nsp, -o is required
+  repair <nsp>              fix missing tickets, certificates and cnmt xmls
+  verify <nsp|nca>          check the ncas of an nsp against its meta, or
+                            the section hashes of a single nca
+  cnmt <file> [header]      print a cnmt as json, either a .cnmt.nca or a
+                            decrypted .cnmt with its nca header
+  exefs <nca>               extract the exefs of a program nca
+  decrypt <nca>             write a plaintext copy of an nca
+
+flags:
+`
+
+type options struct {
+	cert       string
+	key        string
+	p12        string
+	p12Pass    string
+	dauth      string
+	edge       string
+	prodKeys   string
+	titleKeys  string
+	hactool    string
+	hactoolnet string
+	out        string
+	ca         string
+	insecure   bool
+	verbose    bool
+}
+
+func main() {
+	o := options{}
+	flag.StringVar(&o.cert, "cert", "", "device certificate")
+	flag.StringVar(&o.key, "key", "", "device certificate key")
+	flag.StringVar(&o.p12, "p12", "", "device certificate and key as .p12/.pfx, instead of -cert and -key")
+	flag.StringVar(&o.p12Pass, "p12pass", os.Getenv("ATUM_P12_PASSWORD"), "password of the -p12 file")
+	flag.StringVar(&o.dauth, "dauth", os.Getenv("ATUM_DAUTH_TOKEN"), "device auth token")
+	flag.StringVar(&o.edge, "edge", os.Getenv("ATUM_EDGE_TOKEN"), "edge token")
+	flag.StringVar(&o.prodKeys, "keys", defaultKeys("prod.keys"), "prod.keys for native decryption")
+	flag.StringVar(&o.titleKeys, "titlekeys", defaultKeys("title.keys"), "title.keys")
+	flag.StringVar(&o.hactool, "hactool", "", "hactool binary, used when no keys are given, searched for if not set")
+	flag.StringVar(&o.hactoolnet, "hactoolnet", "", "hactoolnet binary, used instead of native decryption or hactool")
+	flag.StringVar(&o.out, "o", "", "output path")
+	flag.StringVar(&o.ca, "ca", "", "pem bundle to verify servers against")
+	flag.BoolVar(&o.insecure, "insecure", false, "don't verify server certificates")
+	flag.BoolVar(&o.verbose, "v", false, "log requests and file operations")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args := flag.Args(); args[0] {
+	case "download":
+		err = download(o, args[1:])
+	case "pack":
+		err = pack(o, args[1])
+	case "merge":
+		err = merge(o, args[1:])
+	case "repair":
+		err = repair(o, args[1])
+	case "verify":
+		err = verify(o, args[1])
+	case "cnmt":
+		err = cnmt(o, args[1:])
+	case "exefs":
+		err = exefs(o, args[1])
+	case "decrypt":
+		err = decrypt(o, args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "atum:", err)
+		os.Exit(1)
+	}
+}
+
+// defaultKeys returns ~/.switch/name if it exists, like hactool does.
+func defaultKeys(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	path := filepath.Join(home, ".switch", name)
+	if _, err = os.Stat(path); err != nil {
+		return ""
+	}
+
+	return path
+}
+
+func keyset(o options) (*libhac.Keyset, error) {
+	if o.prodKeys == "" {
+		return nil, nil
+	}
+
+	return libhac.LoadKeyset(o.prodKeys, o.titleKeys)
+}
+
+func client(o options) (libhac.HacClient, error) {
+	if o.p12 == "" {
+		return libhac.NewHacClient(o.cert, o.key, o.dauth, o.edge)
+	}
+
+	device, err := libhac.LoadPKCS12(o.p12, o.p12Pass)
+	if err != nil {
+		return libhac.HacClient{}, err
+	}
+
+	return libhac.NewHacClientFromCertificate(device, o.dauth, o.edge)
+}
+
+func download(o options, args []string) error {
+	tid := strings.ToLower(args[0])
+
+	ver := 0
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %s", args[1])
+		}
+		ver = v
+	}
+
+	c, err := client(o)
+	if err != nil {
+		return err
+	}
+
+	c.InsecureSkipVerify = o.insecure
+	if o.verbose {
+		c.Log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if o.ca != "" {
+		c.RootCAs, err = libhac.LoadCABundle(o.ca)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Resume = true
+	c.VerifyHashes = true
+	c.CheckDiskSpace = true
+	c.Retry = libhac.DefaultRetryPolicy
+	c.HactoolPath = o.hactool
+	if o.hactoolnet != "" {
+		c.Decryptor = libhac.HactoolnetDecryptor{Path: o.hactoolnet, KeysPath: o.prodKeys}
+	}
+	c.Keys, err = keyset(o)
+	if err != nil {
+		return err
+	}
+
+	c.Progress = func(done, total int64, speed float64) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d MiB %.1f MiB/s ", done>>20, total>>20, speed/(1<<20))
+	}
+
+	dest := o.out
+	if dest == "" {
+		dest = fmt.Sprintf("%s_v%d", tid, ver)
+	}
+
+	m, err := c.DownloadTitle(tid, ver, dest)
+	fmt.Fprintln(os.Stderr)
+	if o.verbose {
+		s := c.Stats()
+		log.Printf("%d requests, %d errors, %d MiB downloaded in %v", s.Requests, s.Errors,
+			s.BytesDownloaded>>20, time.Since(s.Since).Round(time.Second))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, v := range m.Files {
+		fmt.Println(v)
+	}
+
+	return nil
+}
+
+func pack(o options, dir string) error {
+	out := o.out
+	if out == "" {
+		out = filepath.Clean(dir) + ".nsp"
+	}
+
+	return libhac.PackToNSP(dir, out)
+}
+
+func merge(o options, inputs []string) error {
+	if o.out == "" {
+		return errors.New("merge needs an output path set with -o")
+	}
+
+	return libhac.MergeNSPs(o.out, inputs...)
+}
+
+func repair(o options, nsp string) error {
+	keys, err := keyset(o)
+	if err != nil {
+		return err
+	}
+
+	if keys == nil {
+		return errors.New("repair needs a prod.keys")
+	}
+
+	out := o.out
+	if out == "" {
+		out = strings.TrimSuffix(nsp, filepath.Ext(nsp)) + ".repaired.nsp"
+	}
+
+	changes, err := libhac.RepairNSP(nsp, out, keys, libhac.RepairOptions{})
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("%s: nothing to repair\n", filepath.Base(nsp))
+		return nil
+	}
+
+	for _, v := range changes {
+		fmt.Println(v)
+	}
+	fmt.Println(out)
+
+	return nil
+}
+
+func verify(o options, nsp string) error {
+	keys, err := keyset(o)
+	if err != nil {
+		return err
+	}
+
+	if keys == nil {
+		return errors.New("verify needs a prod.keys")
+	}
+
+	if strings.HasSuffix(nsp, ".nca") {
+		err = libhac.VerifyNCA(nsp, keys)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: ok\n", filepath.Base(nsp))
+
+		return nil
+	}
+
+	results, err := libhac.VerifyNSP(nsp, keys)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, v := range results {
+		if v.OK {
+			fmt.Printf("%s: ok\n", v.Name)
+			continue
+		}
+
+		fmt.Printf("%s: %s\n", v.Name, v.Reason)
+		failed++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d contents failed verification", failed, len(results))
+	}
+
+	return nil
+}
+
+func cnmt(o options, args []string) error {
+	var (
+		m   libhac.CNMT
+		err error
+	)
+
+	if len(args) > 1 {
+		m, err = libhac.ParseCNMT(args[0], args[1])
+	} else {
+		keys, kerr := keyset(o)
+		if kerr != nil {
+			return kerr
+		}
+
+		if keys == nil {
+			return errors.New("reading a .cnmt.nca needs a prod.keys, or pass a decrypted cnmt and header")
+		}
+
+		m, err = libhac.ReadCNMTFromNCA(args[0], keys)
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(b))
+
+	return nil
+}
+
+func exefs(o options, nca string) error {
+	keys, err := keyset(o)
+	if err != nil {
+		return err
+	}
+
+	if keys == nil {
+		return errors.New("exefs needs a prod.keys")
+	}
+
+	out := o.out
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(nca), ".nca") + "_exefs"
+	}
+
+	return libhac.ExtractExeFS(nca, out, keys)
+}
+
+func decrypt(o options, nca string) error {
+	keys, err := keyset(o)
+	if err != nil {
+		return err
+	}
+
+	if keys == nil {
+		return errors.New("decrypt needs a prod.keys")
+	}
+
+	out := o.out
+	if out == "" {
+		out = strings.TrimSuffix(nca, ".nca") + ".plain.nca"
+	}
+
+	return libhac.WritePlaintextNCA(nca, out, keys)
+}
@@ -0,0 +1,131 @@
+package libhac
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CNMTDiff is the difference between the contents of two versions of a
+// title.
+type CNMTDiff struct {
+	Added     []ContentEntry
+	Removed   []ContentEntry
+	Changed   []ContentChange
+	Unchanged []ContentEntry
+}
+
+// ContentChange is a content replaced by a new one of the same type.
+type ContentChange struct {
+	Old ContentEntry
+	New ContentEntry
+}
+
+// DiffCNMT compares the content entries of a and b. Entries with the same
+// id are unchanged unless their size or hash differ. Of the rest, an entry
+// of a type that was only in a once and is only in b once counts as changed,
+// the others as added to or removed from b.
+func DiffCNMT(a, b CNMT) CNMTDiff {
+	d := CNMTDiff{}
+
+	old := map[string]ContentEntry{}
+	for _, v := range a.ContentEntries {
+		old[v.IDString()] = v
+	}
+
+	current := map[string]bool{}
+	rest := []ContentEntry{}
+	for _, v := range b.ContentEntries {
+		current[v.IDString()] = true
+
+		o, ok := old[v.IDString()]
+		switch {
+		case !ok:
+			rest = append(rest, v)
+		case o.Size != v.Size || !bytes.Equal(o.Hash, v.Hash):
+			d.Changed = append(d.Changed, ContentChange{o, v})
+		default:
+			d.Unchanged = append(d.Unchanged, v)
+		}
+	}
+
+	gone := []ContentEntry{}
+	for _, v := range a.ContentEntries {
+		if !current[v.IDString()] {
+			gone = append(gone, v)
+		}
+	}
+
+	count := func(entries []ContentEntry, t string) int {
+		n := 0
+		for _, v := range entries {
+			if v.Type == t {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	replaced := map[string]bool{}
+	for _, v := range rest {
+		if count(rest, v.Type) != 1 || count(gone, v.Type) != 1 {
+			d.Added = append(d.Added, v)
+			continue
+		}
+
+		for _, o := range gone {
+			if o.Type == v.Type {
+				d.Changed = append(d.Changed, ContentChange{o, v})
+				replaced[o.IDString()] = true
+			}
+		}
+	}
+
+	for _, v := range gone {
+		if !replaced[v.IDString()] {
+			d.Removed = append(d.Removed, v)
+		}
+	}
+
+	return d
+}
+
+// Download returns the entries of the newer CNMT that have to be downloaded
+// when the older version is already there.
+func (d CNMTDiff) Download() []ContentEntry {
+	entries := append([]ContentEntry{}, d.Added...)
+	for _, v := range d.Changed {
+		entries = append(entries, v.New)
+	}
+
+	return entries
+}
+
+// DownloadSize returns the size of Download.
+func (d CNMTDiff) DownloadSize() int64 {
+	var size int64
+	for _, v := range d.Download() {
+		size += v.Size
+	}
+
+	return size
+}
+
+// String lists the changes one per line, like a changelog.
+func (d CNMTDiff) String() string {
+	b := &strings.Builder{}
+	for _, v := range d.Added {
+		fmt.Fprintf(b, "+ %s %s, %d bytes\n", v.Type, v.IDString(), v.Size)
+	}
+
+	for _, v := range d.Changed {
+		fmt.Fprintf(b, "~ %s %s -> %s, %d -> %d bytes\n", v.New.Type, v.Old.IDString(), v.New.IDString(), v.Old.Size, v.New.Size)
+	}
+
+	for _, v := range d.Removed {
+		fmt.Fprintf(b, "- %s %s, %d bytes\n", v.Type, v.IDString(), v.Size)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,196 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type PatchExtendedData struct {
+	Histories      []PatchHistory
+	DeltaHistories []PatchDeltaHistory
+	Deltas         []PatchDelta
+	FragmentSets   []FragmentSet
+}
+
+type PatchHistory struct {
+	ID           uint64
+	Version      uint32
+	Type         string
+	Digest       []byte
+	ContentCount uint16
+}
+
+type PatchDeltaHistory struct {
+	SourceID           uint64
+	DestinationID      uint64
+	SourceVersion      uint32
+	DestinationVersion uint32
+	DownloadSize       uint64
+}
+
+type PatchDelta struct {
+	SourceID           uint64
+	DestinationID      uint64
+	SourceVersion      uint32
+	DestinationVersion uint32
+	FragmentSetCount   uint16
+	ContentCount       uint16
+}
+
+type FragmentSet struct {
+	SourceContentID      []byte
+	DestinationContentID []byte
+	SourceSize           int64
+	DestinationSize      int64
+	FragmentCount        uint16
+	TargetContentType    string
+	UpdateType           uint8
+}
+
+type patchExtendedDataHeader struct {
+	HistoryCount                uint32
+	HistoryContentTotalCount    uint32
+	DeltaHistoryCount           uint32
+	DeltaCount                  uint32
+	DeltaContentTotalCount      uint32
+	FragmentSetCount            uint32
+	FragmentIndicatorTotalCount uint32
+}
+
+type patchHistoryHeader struct {
+	ID           uint64
+	Version      uint32
+	Type         uint8
+	Attributes   uint8
+	_            uint16
+	Digest       [0x20]byte
+	ContentCount uint16
+	_            uint16
+	_            uint32
+}
+
+type patchDeltaHistory struct {
+	SourceID           uint64
+	DestinationID      uint64
+	SourceVersion      uint32
+	DestinationVersion uint32
+	DownloadSize       uint64
+	_                  uint64
+}
+
+type patchDeltaHeader struct {
+	SourceID           uint64
+	DestinationID      uint64
+	SourceVersion      uint32
+	DestinationVersion uint32
+	FragmentSetCount   uint16
+	_                  [6]byte
+	ContentCount       uint16
+	_                  [6]byte
+}
+
+type fragmentSet struct {
+	SourceContentID      [0x10]byte
+	DestinationContentID [0x10]byte
+	SourceSizeLow        uint32
+	SourceSizeHigh       uint16
+	DestinationSizeHigh  uint16
+	DestinationSizeLow   uint32
+	FragmentCount        uint16
+	TargetContentType    uint8
+	UpdateType           uint8
+	_                    uint32
+}
+
+// PatchExtendedData parses the delta and history information stored after
+// the content tables of patch metas.
+func (c CNMT) PatchExtendedData() (PatchExtendedData, error) {
+	if c.Type != "Patch" {
+		return PatchExtendedData{}, errors.New("only patch metas have patch extended data")
+	}
+
+	r := bytes.NewReader(c.ExtendedData)
+
+	h := patchExtendedDataHeader{}
+	err := binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return PatchExtendedData{}, err
+	}
+
+	// history contents are ContentInfos (0x18), delta contents are
+	// PackagedContentInfos (0x38) and fragment indicators are two u16s
+	size := binary.Size(h) +
+		int(h.HistoryCount)*binary.Size(patchHistoryHeader{}) +
+		int(h.DeltaHistoryCount)*binary.Size(patchDeltaHistory{}) +
+		int(h.DeltaCount)*binary.Size(patchDeltaHeader{}) +
+		int(h.FragmentSetCount)*binary.Size(fragmentSet{}) +
+		int(h.HistoryContentTotalCount)*0x18 +
+		int(h.DeltaContentTotalCount)*0x38 +
+		int(h.FragmentIndicatorTotalCount)*0x4
+	if size != len(c.ExtendedData) {
+		return PatchExtendedData{}, fmt.Errorf("patch extended data is %d bytes, expected %d", len(c.ExtendedData), size)
+	}
+
+	histories := make([]patchHistoryHeader, h.HistoryCount)
+	deltaHistories := make([]patchDeltaHistory, h.DeltaHistoryCount)
+	deltas := make([]patchDeltaHeader, h.DeltaCount)
+	fragmentSets := make([]fragmentSet, h.FragmentSetCount)
+	for _, v := range []interface{}{histories, deltaHistories, deltas, fragmentSets} {
+		err = binary.Read(r, binary.LittleEndian, v)
+		if err != nil {
+			return PatchExtendedData{}, err
+		}
+	}
+
+	p := PatchExtendedData{}
+	for _, v := range histories {
+		p.Histories = append(p.Histories, PatchHistory{
+			v.ID,
+			v.Version,
+			getCNMTType(v.Type),
+			append([]byte{}, v.Digest[:]...),
+			v.ContentCount,
+		})
+	}
+
+	for _, v := range deltaHistories {
+		p.DeltaHistories = append(p.DeltaHistories, PatchDeltaHistory{
+			v.SourceID,
+			v.DestinationID,
+			v.SourceVersion,
+			v.DestinationVersion,
+			v.DownloadSize,
+		})
+	}
+
+	for _, v := range deltas {
+		p.Deltas = append(p.Deltas, PatchDelta{
+			v.SourceID,
+			v.DestinationID,
+			v.SourceVersion,
+			v.DestinationVersion,
+			v.FragmentSetCount,
+			v.ContentCount,
+		})
+	}
+
+	for _, v := range fragmentSets {
+		p.FragmentSets = append(p.FragmentSets, FragmentSet{
+			append([]byte{}, v.SourceContentID[:]...),
+			append([]byte{}, v.DestinationContentID[:]...),
+			int64(v.SourceSizeHigh)<<32 | int64(v.SourceSizeLow),
+			int64(v.DestinationSizeHigh)<<32 | int64(v.DestinationSizeLow),
+			v.FragmentCount,
+			getNCAType(v.TargetContentType),
+			v.UpdateType,
+		})
+	}
+
+	return p, nil
+}
+
+func (ce ContentEntry) IsDeltaFragment() bool {
+	return ce.Type == "DeltaFragment"
+}
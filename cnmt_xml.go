@@ -0,0 +1,94 @@
+package libhac
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type cnmtXML struct {
+	XMLName                       xml.Name         `xml:"ContentMeta"`
+	Type                          string           `xml:"Type"`
+	ID                            string           `xml:"Id"`
+	Version                       int64            `xml:"Version"`
+	RequiredDownloadSystemVersion int64            `xml:"RequiredDownloadSystemVersion"`
+	Contents                      []cnmtXMLContent `xml:"Content"`
+	Digest                        string           `xml:"Digest"`
+	KeyGenerationMin              int64            `xml:"KeyGenerationMin"`
+	RequiredSystemVersion         int64            `xml:"RequiredSystemVersion"`
+	PatchID                       string           `xml:"PatchId,omitempty"`
+	OriginalID                    string           `xml:"OriginalId,omitempty"`
+}
+
+type cnmtXMLContent struct {
+	Type          string `xml:"Type"`
+	ID            string `xml:"Id"`
+	Size          int64  `xml:"Size"`
+	Hash          string `xml:"Hash"`
+	KeyGeneration int64  `xml:"KeyGeneration"`
+}
+
+func GenerateCNMTXML(cnmt CNMT, cnmtNCAPath, out string) error {
+	f, err := os.Open(cnmtNCAPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	x, err := generateCNMTXML(cnmt, size, h.Sum(nil))
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(out, x, 0666)
+}
+
+func generateCNMTXML(cnmt CNMT, metaSize int64, metaHash []byte) ([]byte, error) {
+	tid := cnmt.IDString()
+	keyGen := int64(cnmt.MasterKeyRevision)
+
+	x := cnmtXML{
+		Type:                          cnmt.Type,
+		ID:                            "0x" + tid,
+		Version:                       int64(cnmt.Version),
+		RequiredDownloadSystemVersion: int64(cnmt.RequiredDownloadSystemVersion),
+		Digest:                        cnmt.DigestString(),
+		KeyGenerationMin:              keyGen,
+		RequiredSystemVersion:         int64(cnmt.RequiredSystemVersion),
+	}
+
+	switch cnmt.Type {
+	case "Application":
+		x.PatchID = "0x" + TitleID(cnmt.ID).UpdateID().String()
+	case "Patch":
+		x.OriginalID = "0x" + TitleID(cnmt.ID).BaseID().String()
+	}
+
+	for _, ce := range cnmt.ContentEntries {
+		x.Contents = append(x.Contents, cnmtXMLContent{ce.Type, ce.IDString(), ce.Size, ce.HashString(), keyGen})
+	}
+
+	x.Contents = append(x.Contents, cnmtXMLContent{
+		"Meta",
+		hex.EncodeToString(metaHash[:0x10]),
+		metaSize,
+		hex.EncodeToString(metaHash),
+		keyGen,
+	})
+
+	b, err := xml.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), b...), nil
+}
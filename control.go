@@ -0,0 +1,103 @@
+package libhac
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ControlIcons returns the JPEG icons in a decrypted Control NCA RomFS by
+// language, e.g. "AmericanEnglish" for icon_AmericanEnglish.dat.
+func ControlIcons(romfs io.ReaderAt) (map[string][]byte, error) {
+	files, err := ReadRomFS(romfs)
+	if err != nil {
+		return nil, err
+	}
+
+	icons := map[string][]byte{}
+	for _, v := range files {
+		name := strings.TrimPrefix(v.Path, "/")
+		if !strings.HasPrefix(name, "icon_") || !strings.HasSuffix(name, ".dat") {
+			continue
+		}
+
+		b := make([]byte, v.Size)
+		_, err = romfs.ReadAt(b, v.Offset)
+		if err != nil {
+			return nil, err
+		}
+
+		icons[strings.TrimSuffix(strings.TrimPrefix(name, "icon_"), ".dat")] = b
+	}
+
+	return icons, nil
+}
+
+// ControlIconsFromRomFS is ControlIcons for a RomFS image on disk, like the
+// section0.bin written by DecryptNCANative.
+func ControlIconsFromRomFS(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ControlIcons(f)
+}
+
+// ControlIconsFromNCA decrypts the RomFS of a Control NCA natively and
+// returns its icons.
+func ControlIconsFromNCA(path string, keys *Keyset) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if nca.header.ContentType != 2 {
+		return nil, errors.New("not a control nca")
+	}
+
+	romfs, err := nca.dataReader(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return ControlIcons(romfs)
+}
+
+// WriteControlIcons writes each icon to out as <language>.jpg.
+func WriteControlIcons(icons map[string][]byte, out string) error {
+	err := os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	for lang, icon := range icons {
+		// the language comes from a file name in the romfs
+		if !isNACPLanguage(lang) {
+			return fmt.Errorf("unknown icon language %q", lang)
+		}
+
+		f, err := os.Create(filepath.Join(out, lang+".jpg"))
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(icon)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
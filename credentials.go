@@ -0,0 +1,200 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// offsets of the device certificate in a decrypted PRODINFO (CAL0)
+const (
+	prodinfoCertSizeOffset = 0x0AD0
+	prodinfoCertOffset     = 0x0AE0
+	prodinfoCertMaxSize    = 0x800
+)
+
+// LoadPKCS12 reads a device certificate and key from a .p12/.pfx file.
+func LoadPKCS12(path, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return ParsePKCS12(data, password)
+}
+
+func ParsePKCS12(data []byte, password string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	var certPEM, keyPEM []byte
+	for _, b := range blocks {
+		if b.Type == "PRIVATE KEY" || b.Type == "RSA PRIVATE KEY" {
+			keyPEM = append(keyPEM, pem.EncodeToMemory(b)...)
+		} else {
+			certPEM = append(certPEM, pem.EncodeToMemory(b)...)
+		}
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// LoadConsoleKeypair reads the console's ssl keypair as extracted from
+// PRODINFO. certPath is either the DER certificate or a decrypted PRODINFO
+// the certificate is taken from, keyPath has the private key either as the
+// raw 0x100 byte private exponent or DER encoded.
+func LoadConsoleKeypair(certPath, keyPath string) (tls.Certificate, error) {
+	cert, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if bytes.HasPrefix(cert, []byte("CAL0")) {
+		cert, err = prodinfoCertificate(cert)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return ParseConsoleKeypair(cert, key)
+}
+
+// ParseConsoleKeypair builds the device certificate from the DER certificate
+// and the private key, see LoadConsoleKeypair.
+func ParseConsoleKeypair(certDER, key []byte) (tls.Certificate, error) {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return tls.Certificate{}, errors.New("device certificate doesn't have an rsa key")
+	}
+
+	var priv *rsa.PrivateKey
+	switch {
+	case len(key) == pub.Size():
+		priv, err = rsaKeyFromExponent(pub, new(big.Int).SetBytes(key))
+	default:
+		priv, err = x509.ParsePKCS1PrivateKey(key)
+		if err != nil {
+			var k interface{}
+			k, err = x509.ParsePKCS8PrivateKey(key)
+			if err == nil {
+				if priv, ok = k.(*rsa.PrivateKey); !ok {
+					err = errors.New("device key isn't an rsa key")
+				}
+			}
+		}
+	}
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if priv.N.Cmp(pub.N) != 0 {
+		return tls.Certificate{}, errors.New("device key doesn't match the certificate")
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}, nil
+}
+
+// NewHacClientFromCertificate is like NewHacClient with an already loaded
+// device certificate, e.g. from LoadPKCS12 or LoadConsoleKeypair.
+func NewHacClientFromCertificate(device tls.Certificate, dauthToken, edgeToken string) (HacClient, error) {
+	c, err := NewHacClientFromPEM(nil, nil, dauthToken, edgeToken)
+	if err != nil {
+		return HacClient{}, err
+	}
+
+	c.DeviceCert = device
+
+	return c, nil
+}
+
+func prodinfoCertificate(prodinfo []byte) ([]byte, error) {
+	if len(prodinfo) < prodinfoCertOffset+prodinfoCertMaxSize {
+		return nil, errors.New("prodinfo too short")
+	}
+
+	size := binary.LittleEndian.Uint32(prodinfo[prodinfoCertSizeOffset:])
+	if size == 0 || size > prodinfoCertMaxSize {
+		return nil, fmt.Errorf("invalid certificate size %#x in prodinfo", size)
+	}
+
+	return prodinfo[prodinfoCertOffset : prodinfoCertOffset+size], nil
+}
+
+// rsaKeyFromExponent recovers the primes from the modulus and both exponents,
+// which crypto/rsa needs for a usable private key.
+func rsaKeyFromExponent(pub *rsa.PublicKey, d *big.Int) (*rsa.PrivateKey, error) {
+	one := big.NewInt(1)
+	n := pub.N
+	nm1 := new(big.Int).Sub(n, one)
+
+	if d.Cmp(one) <= 0 {
+		return nil, errors.New("device key doesn't match the certificate")
+	}
+
+	// k = d*e - 1 = 2^s * t
+	k := new(big.Int).Mul(d, big.NewInt(int64(pub.E)))
+	k.Sub(k, one)
+	t := new(big.Int).Set(k)
+	s := 0
+	for t.Bit(0) == 0 {
+		t.Rsh(t, 1)
+		s++
+	}
+
+	for g := int64(2); g < 100; g++ {
+		x := new(big.Int).Exp(big.NewInt(g), t, n)
+
+		// for a matching key x reaches 1 within s squarings, a key that
+		// doesn't match could cycle forever
+		for i := 0; i < s && x.Cmp(one) != 0 && x.Cmp(nm1) != 0; i++ {
+			y := new(big.Int).Exp(x, big.NewInt(2), n)
+			if y.Cmp(one) == 0 {
+				// x is a non-trivial square root of 1
+				p := new(big.Int).GCD(nil, nil, new(big.Int).Sub(x, one), n)
+				q := new(big.Int).Div(n, p)
+
+				priv := &rsa.PrivateKey{
+					PublicKey: *pub,
+					D:         d,
+					Primes:    []*big.Int{p, q},
+				}
+
+				err := priv.Validate()
+				if err != nil {
+					return nil, err
+				}
+				priv.Precompute()
+
+				return priv, nil
+			}
+			x = y
+		}
+	}
+
+	return nil, errors.New("device key doesn't match the certificate")
+}
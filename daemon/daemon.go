@@ -0,0 +1,345 @@
+// Package daemon runs downloads and update checks in the background and keeps
+// its watch list and download history in a json state file, so interrupted
+// downloads are picked up again after a restart.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakibaki/libhac"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+type Job struct {
+	ID       string    `json:"id"`
+	TitleID  string    `json:"title_id"`
+	Version  int       `json:"version"`
+	Dest     string    `json:"dest"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+type state struct {
+	Watch  map[string]int `json:"watch"`
+	Jobs   []*Job         `json:"jobs"`
+	NextID int            `json:"next_id"`
+}
+
+type Daemon struct {
+	// Interval is how often the watched titles are checked for updates.
+	Interval time.Duration
+	// OnJob is optional and called whenever a job changes state.
+	OnJob func(Job)
+
+	client      libhac.HacClient
+	statePath   string
+	downloadDir string
+
+	mu     sync.Mutex
+	state  state
+	notify chan struct{}
+}
+
+// New loads the state from statePath, starting empty if it doesn't exist.
+// Downloads go to downloadDir/<tid>_v<version>. The client is copied with
+// Resume enabled so interrupted downloads continue where they stopped.
+func New(c *libhac.HacClient, statePath, downloadDir string) (*Daemon, error) {
+	d := &Daemon{
+		Interval:    6 * time.Hour,
+		client:      *c,
+		statePath:   statePath,
+		downloadDir: downloadDir,
+		state:       state{Watch: map[string]int{}},
+		notify:      make(chan struct{}, 1),
+	}
+	d.client.Resume = true
+
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &d.state)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state file %s: %v", statePath, err)
+		}
+
+		if d.state.Watch == nil {
+			d.state.Watch = map[string]int{}
+		}
+	}
+
+	// jobs that were running when the daemon stopped start over, resuming
+	// their partial files
+	for _, v := range d.state.Jobs {
+		if v.State == JobRunning {
+			v.State = JobPending
+		}
+	}
+
+	return d, nil
+}
+
+// save writes the state, d.mu has to be held.
+func (d *Daemon) save() error {
+	data, err := json.MarshalIndent(d.state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(d.statePath), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := d.statePath + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.statePath)
+}
+
+func (d *Daemon) Watch(tid string, knownVersion int) error {
+	if _, err := strconv.ParseUint(tid, 16, 64); err != nil || len(tid) != 16 {
+		return fmt.Errorf("invalid title id %s", tid)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.Watch[strings.ToLower(tid)] = knownVersion
+	d.wake()
+
+	return d.save()
+}
+
+func (d *Daemon) Unwatch(tid string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.state.Watch, strings.ToLower(tid))
+	d.wake()
+
+	return d.save()
+}
+
+func (d *Daemon) Watched() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w := map[string]int{}
+	for k, v := range d.state.Watch {
+		w[k] = v
+	}
+
+	return w
+}
+
+// Enqueue adds a download job, which Run processes in order.
+func (d *Daemon) Enqueue(tid string, ver int) (Job, error) {
+	if _, err := strconv.ParseUint(tid, 16, 64); err != nil || len(tid) != 16 {
+		return Job{}, fmt.Errorf("invalid title id %s", tid)
+	}
+
+	tid = strings.ToLower(tid)
+
+	d.mu.Lock()
+	d.state.NextID++
+	j := &Job{
+		ID:      strconv.Itoa(d.state.NextID),
+		TitleID: tid,
+		Version: ver,
+		Dest:    filepath.Join(d.downloadDir, fmt.Sprintf("%s_v%d", tid, ver)),
+		State:   JobPending,
+		Created: time.Now(),
+	}
+	d.state.Jobs = append(d.state.Jobs, j)
+	err := d.save()
+	d.mu.Unlock()
+
+	if err != nil {
+		return Job{}, err
+	}
+
+	d.wake()
+
+	return *j, nil
+}
+
+// Jobs returns all jobs, oldest first.
+func (d *Daemon) Jobs() []Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	jobs := []Job{}
+	for _, v := range d.state.Jobs {
+		jobs = append(jobs, *v)
+	}
+
+	return jobs
+}
+
+func (d *Daemon) wake() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes jobs and checks for updates until ctx is canceled.
+func (d *Daemon) Run(ctx context.Context) error {
+	w := libhac.NewUpdateWatcher(&d.client, d.Interval)
+	for k, v := range d.Watched() {
+		w.Watch(k, v)
+	}
+
+	w.OnUpdate = func(e libhac.UpdateEvent) {
+		if e.Err != nil || e.TitleID == "" {
+			return
+		}
+
+		d.mu.Lock()
+		if _, ok := d.state.Watch[e.TitleID]; ok {
+			d.state.Watch[e.TitleID] = e.Version
+		}
+		d.mu.Unlock()
+
+		d.Enqueue(libhac.UpdateTitleID(e.TitleID, e.Version), e.Version)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.Run(ctx)
+	}()
+
+	for {
+		// titles watched or unwatched after Run started
+		watched, current := d.Watched(), w.Titles()
+		for k, v := range watched {
+			if _, ok := current[k]; !ok {
+				w.Watch(k, v)
+			}
+		}
+		for k := range current {
+			if _, ok := watched[k]; !ok {
+				w.Unwatch(k)
+			}
+		}
+
+		j := d.nextJob()
+		if j == nil {
+			select {
+			case <-ctx.Done():
+				<-errs
+				return ctx.Err()
+			case <-d.notify:
+			}
+			continue
+		}
+
+		d.runJob(ctx, j)
+
+		if ctx.Err() != nil {
+			<-errs
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *Daemon) nextJob() *Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, v := range d.state.Jobs {
+		if v.State == JobPending {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (d *Daemon) runJob(ctx context.Context, j *Job) {
+	d.setJobState(j, JobRunning, nil)
+
+	_, err := d.client.DownloadTitleContext(ctx, j.TitleID, j.Version, j.Dest)
+
+	switch {
+	case ctx.Err() != nil:
+		// stays running, so it's resumed on the next start
+		return
+	case err != nil:
+		d.setJobState(j, JobFailed, err)
+	default:
+		d.setJobState(j, JobDone, nil)
+	}
+}
+
+func (d *Daemon) setJobState(j *Job, s string, err error) {
+	d.mu.Lock()
+	j.State = s
+	j.Error = ""
+	if err != nil {
+		j.Error = err.Error()
+	}
+	if s == JobDone || s == JobFailed {
+		j.Finished = time.Now()
+	}
+	saveErr := d.save()
+	job := *j
+	d.mu.Unlock()
+
+	if saveErr != nil && job.Error == "" {
+		job.Error = saveErr.Error()
+	}
+
+	if d.OnJob != nil {
+		d.OnJob(job)
+	}
+}
+
+// Retry puts a failed job back into the queue.
+func (d *Daemon) Retry(id string) error {
+	d.mu.Lock()
+	var found *Job
+	for _, v := range d.state.Jobs {
+		if v.ID == id {
+			found = v
+		}
+	}
+
+	if found == nil || found.State != JobFailed {
+		d.mu.Unlock()
+		return errors.New("no failed job with that id")
+	}
+
+	found.State = JobPending
+	found.Error = ""
+	err := d.save()
+	d.mu.Unlock()
+
+	d.wake()
+
+	return err
+}
@@ -0,0 +1,313 @@
+package libhac
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DauthClientIDShop = "41f4a6491028e3c4"
+	DauthClientIDAtum = "93af0acb26258de9"
+)
+
+type DauthConfig struct {
+	Keys          *Keyset
+	KeyGeneration int
+	SystemVersion string
+	// ShopClientID and EdgeClientID default to DauthClientIDShop and
+	// DauthClientIDAtum when empty.
+	ShopClientID string
+	EdgeClientID string
+}
+
+type Token struct {
+	Token   string
+	Expires time.Time
+}
+
+type tokenCache struct {
+	mu         sync.Mutex
+	dauthToken Token
+	edgeToken  Token
+}
+
+type dauthChallenge struct {
+	Challenge string `json:"challenge"`
+	Data      string `json:"data"`
+}
+
+type dauthTokenResponse struct {
+	DeviceAuthToken string `json:"device_auth_token"`
+	EdgeToken       string `json:"dtoken"`
+	ExpiresIn       int64  `json:"expires_in"`
+	ErrorResponse
+}
+
+type ErrorResponse struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (c *HacClient) RequestDeviceAuthToken(clientID string) (Token, error) {
+	return c.RequestDeviceAuthTokenContext(context.Background(), clientID)
+}
+
+func (c *HacClient) RequestDeviceAuthTokenContext(ctx context.Context, clientID string) (Token, error) {
+	r, err := c.doDauthRequest(ctx, "/v6/device_auth_token", clientID)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{r.DeviceAuthToken, time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)}, nil
+}
+
+func (c *HacClient) RequestEdgeToken(clientID string) (Token, error) {
+	return c.RequestEdgeTokenContext(context.Background(), clientID)
+}
+
+func (c *HacClient) RequestEdgeTokenContext(ctx context.Context, clientID string) (Token, error) {
+	r, err := c.doDauthRequest(ctx, "/v6/edge_token", clientID)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{r.EdgeToken, time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)}, nil
+}
+
+// RefreshTokens fetches new device auth and edge tokens and stores them on
+// the client.
+func (c *HacClient) RefreshTokens() error {
+	return c.RefreshTokensContext(context.Background())
+}
+
+func (c *HacClient) RefreshTokensContext(ctx context.Context) error {
+	dauthToken, edgeToken, err := c.refreshTokens(ctx, true, true)
+	if err != nil {
+		return err
+	}
+
+	c.DauthToken = dauthToken
+	c.EdgeToken = edgeToken
+
+	return nil
+}
+
+// refreshTokens renews whichever of the tokens are requested and about to
+// expire, and returns the current ones. The cache is shared between copies of
+// the client, so requests must use the returned tokens rather than the
+// client's fields.
+func (c *HacClient) refreshTokens(ctx context.Context, dauth, edge bool) (string, string, error) {
+	if c.Dauth == nil {
+		return "", "", fmt.Errorf("no dauth config set")
+	}
+
+	if c.tokens == nil {
+		return "", "", fmt.Errorf("client has no token cache, create it with NewHacClient")
+	}
+
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+
+	// leave a margin so the token doesn't expire in flight
+	deadline := time.Now().Add(time.Minute)
+
+	if dauth && c.tokens.dauthToken.Expires.Before(deadline) {
+		t, err := c.RequestDeviceAuthTokenContext(ctx, defaultString(c.Dauth.ShopClientID, DauthClientIDShop))
+		if err != nil {
+			return "", "", err
+		}
+
+		c.tokens.dauthToken = t
+	}
+
+	if edge && c.tokens.edgeToken.Expires.Before(deadline) {
+		t, err := c.RequestEdgeTokenContext(ctx, defaultString(c.Dauth.EdgeClientID, DauthClientIDAtum))
+		if err != nil {
+			return "", "", err
+		}
+
+		c.tokens.edgeToken = t
+	}
+
+	return c.tokens.dauthToken.Token, c.tokens.edgeToken.Token, nil
+}
+
+func (c *HacClient) doDauthRequest(ctx context.Context, endpoint, clientID string) (dauthTokenResponse, error) {
+	if c.Dauth == nil || c.Dauth.Keys == nil {
+		return dauthTokenResponse{}, fmt.Errorf("no dauth config set")
+	}
+
+	ch := dauthChallenge{}
+	err := c.postDauth(ctx, "/v6/challenge", url.Values{
+		"key_generation": {strconv.Itoa(c.Dauth.KeyGeneration)},
+	}, &ch)
+	if err != nil {
+		return dauthTokenResponse{}, err
+	}
+
+	key, err := c.dauthKey(ch.Data)
+	if err != nil {
+		return dauthTokenResponse{}, err
+	}
+
+	body := fmt.Sprintf("challenge=%s&client_id=%s&ist=false&key_generation=%d&system_version=%s",
+		ch.Challenge, clientID, c.Dauth.KeyGeneration, c.Dauth.SystemVersion)
+
+	mac, err := cmacAES(key, []byte(body))
+	if err != nil {
+		return dauthTokenResponse{}, err
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return dauthTokenResponse{}, err
+	}
+	form.Set("mac", base64.RawURLEncoding.EncodeToString(mac))
+
+	r := dauthTokenResponse{}
+
+	err = c.postDauth(ctx, endpoint, form, &r)
+	if err != nil {
+		return dauthTokenResponse{}, err
+	}
+
+	return r, nil
+}
+
+func (c *HacClient) postDauth(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header.Set("User-Agent", "libcurl (nnDauth; 16f4553f-9eee-4e39-9b61-59bc7c99b7c8; SDK 5.3.0.0; Add-on 5.3.0.0)")
+
+	resp, err := c.doRequest(ctx, "POST", c.serviceURL(ServiceDauth, "%s", endpoint), []tls.Certificate{c.DeviceCert},
+		false, false, header, []byte(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := ErrorResponse{}
+		if json.Unmarshal(body, &e) == nil && len(e.Errors) > 0 {
+			return fmt.Errorf("dauth request to %s failed: %s %s", endpoint, e.Errors[0].Code, e.Errors[0].Message)
+		}
+
+		return fmt.Errorf("dauth request to %s returned %s", endpoint, resp.Status)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// dauthKey unwraps the per-challenge mac key using the dauth kek derived from
+// the master key of the configured key generation.
+func (c *HacClient) dauthKey(data string) ([]byte, error) {
+	source, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	k := c.Dauth.Keys
+	masterKey, err := k.Key(fmt.Sprintf("master_key_%02x", c.Dauth.KeyGeneration))
+	if err != nil {
+		return nil, err
+	}
+
+	kekSource, err := k.Key("aes_kek_generation_source")
+	if err != nil {
+		return nil, err
+	}
+
+	dauthKek, err := k.Key("dauth_kek")
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := decryptECB(masterKey, kekSource)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err = decryptECB(kek, dauthKek)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptECB(kek, source)
+}
+
+func cmacAES(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	shiftSubkey(k1)
+
+	k2 := append([]byte{}, k1...)
+	shiftSubkey(k2)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n == 0 || len(msg)%aes.BlockSize != 0 {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBlock(last, k2)
+	} else {
+		copy(last, msg[(n-1)*aes.BlockSize:])
+		xorBlock(last, k1)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBlock(mac, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	xorBlock(mac, last)
+	block.Encrypt(mac, mac)
+
+	return mac, nil
+}
+
+func shiftSubkey(k []byte) {
+	carry := k[0] >> 7
+	for i := 0; i < len(k)-1; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[len(k)-1] <<= 1
+
+	if carry != 0 {
+		k[len(k)-1] ^= 0x87
+	}
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
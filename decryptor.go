@@ -0,0 +1,254 @@
+package libhac
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Decryptor extracts an NCA into out the way hactool does: the decrypted
+// header to header.bin and the sections to section0-3, exefs and romfs.
+type Decryptor interface {
+	DecryptNCA(path, out string) error
+}
+
+// NativeDecryptor decrypts NCAs in process with Keys.
+type NativeDecryptor struct {
+	Keys *Keyset
+}
+
+func (d NativeDecryptor) DecryptNCA(path, out string) error {
+	return DecryptNCANative(path, out, d.Keys)
+}
+
+// HactoolDecryptor runs hactool at Path. KeysPath is optional, hactool
+// falls back to ~/.switch/prod.keys.
+type HactoolDecryptor struct {
+	Path     string
+	KeysPath string
+}
+
+func (d HactoolDecryptor) DecryptNCA(path, out string) error {
+	err := os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--exefsdir=" + filepath.Join(out, "exefs"), "--romfsdir=" + filepath.Join(out, "romfs"),
+		"--section0dir=" + filepath.Join(out, "section0"), "--section1dir=" + filepath.Join(out, "section1"),
+		"--section2dir=" + filepath.Join(out, "section2"), "--section3dir=" + filepath.Join(out, "section3"),
+		"--header=" + filepath.Join(out, "header.bin")}
+	if d.KeysPath != "" {
+		args = append(args, "--keyset="+d.KeysPath)
+	}
+
+	return runTool(d.Path, append(args, path)...)
+}
+
+// HactoolnetDecryptor runs LibHac's hactoolnet at Path, for platforms
+// without a hactool build. KeysPath is optional like for hactool.
+type HactoolnetDecryptor struct {
+	Path     string
+	KeysPath string
+}
+
+func (d HactoolnetDecryptor) DecryptNCA(path, out string) error {
+	err := os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-t", "nca", "--exefsdir", filepath.Join(out, "exefs"), "--romfsdir", filepath.Join(out, "romfs"),
+		"--section0dir", filepath.Join(out, "section0"), "--section1dir", filepath.Join(out, "section1"),
+		"--section2dir", filepath.Join(out, "section2"), "--section3dir", filepath.Join(out, "section3"),
+		"--header", filepath.Join(out, "header.bin")}
+	if d.KeysPath != "" {
+		args = append(args, "-k", d.KeysPath)
+	}
+
+	return runTool(d.Path, append(args, path)...)
+}
+
+// ToolError is returned when hactool or hactoolnet fail, with their output
+// and an explanation of common failures in it.
+type ToolError struct {
+	Tool   string
+	Err    error
+	Output string
+	Reason string
+}
+
+func (e *ToolError) Error() string {
+	s := fmt.Sprintf("%s failed: %v", filepath.Base(e.Tool), e.Err)
+
+	if e.Reason != "" {
+		return s + ": " + e.Reason
+	}
+
+	// without a known reason the last line is usually the error
+	lines := strings.Split(strings.TrimSpace(e.Output), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		s += ": " + last
+	}
+
+	return s
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// toolFailures maps lower case error lines of hactool and hactoolnet to the
+// reason they failed, the first match wins. Only whole error messages are
+// matched, key names also show up in their normal output. Anything else is
+// explained by the last line of the output.
+var toolFailures = []struct {
+	output string
+	reason string
+}{
+	{"failed to decrypt nca header", "header_key is missing or wrong"},
+	{"unable to match rights id to titlekey", "the titlekey of the nca is missing"},
+	{"unable to open keyset", "the keys file is missing"},
+}
+
+func runTool(path string, args ...string) error {
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	e := &ToolError{Tool: path, Err: err, Output: string(out)}
+
+	lower := strings.ToLower(e.Output)
+	for _, v := range toolFailures {
+		if strings.Contains(lower, v.output) {
+			e.Reason = v.reason
+			break
+		}
+	}
+
+	return e
+}
+
+// DecryptNCA extracts an NCA with the hactool at hactoolPath.
+func DecryptNCA(path, out, hactoolPath string) error {
+	return HactoolDecryptor{Path: hactoolPath}.DecryptNCA(path, out)
+}
+
+// decryptor returns Decryptor if it's set, or else decrypts natively with
+// Keys, with the hactool at HactoolPath or with one found by FindHactool.
+func (c *HacClient) decryptor() (Decryptor, error) {
+	switch {
+	case c.Decryptor != nil:
+		return c.Decryptor, nil
+	case c.Keys != nil:
+		return NativeDecryptor{c.Keys}, nil
+	case c.HactoolPath != "":
+		return HactoolDecryptor{Path: c.HactoolPath}, nil
+	}
+
+	d, err := FindHactool("")
+	if err != nil {
+		return nil, fmt.Errorf("no keyset or hactool path is set and %w", err)
+	}
+
+	return d, nil
+}
+
+// FindHactool looks for a working hactool when no path is given, in PATH,
+// ~/.switch and the usual install locations but never the working directory,
+// a hactool there has to be given as an explicit ./ path. It checks that
+// keysPath or hactool's default ~/.switch/prod.keys exists. The error lists
+// everything that's missing.
+func FindHactool(keysPath string) (HactoolDecryptor, error) {
+	problems := []string{}
+
+	path, err := findHactoolBinary()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if keysPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			problems = append(problems, "no keys file given and no home directory for ~/.switch/prod.keys")
+		} else {
+			keysPath = filepath.Join(home, ".switch", "prod.keys")
+		}
+	}
+
+	if keysPath != "" {
+		_, err = os.Stat(keysPath)
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("no keys file at %s", keysPath))
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return HactoolDecryptor{}, fmt.Errorf("hactool isn't usable: %s", strings.Join(problems, "; "))
+	}
+
+	return HactoolDecryptor{Path: path, KeysPath: keysPath}, nil
+}
+
+func findHactoolBinary() (string, error) {
+	name := "hactool"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	candidates := []string{}
+	if path, err := exec.LookPath(name); err == nil {
+		candidates = append(candidates, path)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".switch", name), filepath.Join(home, "bin", name))
+	}
+	candidates = append(candidates, filepath.Join("/usr/local/bin", name), filepath.Join("/opt/homebrew/bin", name))
+
+	failed := []string{}
+	for _, v := range candidates {
+		if fi, err := os.Stat(v); err != nil || fi.IsDir() {
+			continue
+		}
+
+		err := checkHactool(v)
+		if err == nil {
+			return v, nil
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", v, err))
+	}
+
+	if len(failed) > 0 {
+		return "", fmt.Errorf("no working hactool found (%s)", strings.Join(failed, ", "))
+	}
+
+	return "", fmt.Errorf("%s not found in PATH, ~/.switch, ~/bin, /usr/local/bin or /opt/homebrew/bin", name)
+}
+
+// checkHactool runs hactool without arguments, it exits with an error but
+// prints its usage, which has to list the options HactoolDecryptor uses.
+func checkHactool(path string) error {
+	out, err := exec.Command(path).CombinedOutput()
+
+	var ee *exec.ExitError
+	if err != nil && !errors.As(err, &ee) {
+		return err
+	}
+
+	for _, v := range []string{"--section0dir", "--romfsdir", "--header"} {
+		if !bytes.Contains(out, []byte(v)) {
+			return fmt.Errorf("doesn't support %s, not hactool or too old", v)
+		}
+	}
+
+	return nil
+}
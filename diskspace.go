@@ -0,0 +1,72 @@
+package libhac
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// InsufficientSpaceError is returned before a download starts if
+// CheckDiskSpace is set and the destination doesn't have enough free space.
+type InsufficientSpaceError struct {
+	Path      string
+	Needed    int64
+	Available int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough free space in %s: %d MiB needed, %d MiB available",
+		e.Path, e.Needed>>20, e.Available>>20)
+}
+
+// checkSpace fails if the filesystem of dir has less than needed bytes free.
+// Filesystems whose free space can't be determined always pass.
+func checkSpace(dir string, needed int64) error {
+	if needed <= 0 {
+		return nil
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil || free < 0 {
+		return nil
+	}
+
+	if free < needed {
+		return &InsufficientSpaceError{dir, needed, free}
+	}
+
+	return nil
+}
+
+// remainingSize returns how much of a size bytes download to path is still
+// missing, accounting for finished and partial downloads when resuming.
+func (c *HacClient) remainingSize(path string, size int64) int64 {
+	if !c.Resume {
+		return size
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return 0
+	}
+
+	if fi, err := os.Stat(path + partSuffix); err == nil && fi.Size() <= size {
+		return size - fi.Size()
+	}
+
+	return size
+}
+
+// checkContentSpace checks dir has room for the ncas of entries that aren't
+// downloaded yet.
+func (c *HacClient) checkContentSpace(dir string, entries []ContentEntry) error {
+	if !c.CheckDiskSpace {
+		return nil
+	}
+
+	var needed int64
+	for _, ce := range entries {
+		needed += c.remainingSize(filepath.Join(dir, ce.IDString()+".nca"), ce.Size)
+	}
+
+	return checkSpace(dir, needed)
+}
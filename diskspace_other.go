@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package libhac
+
+// freeSpace isn't implemented here, so space checks always pass.
+func freeSpace(dir string) (int64, error) {
+	return -1, nil
+}
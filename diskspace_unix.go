@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package libhac
+
+import "syscall"
+
+func freeSpace(dir string) (int64, error) {
+	st := syscall.Statfs_t{}
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return -1, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
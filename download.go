@@ -0,0 +1,301 @@
+package libhac
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const minSegmentSize = 1 << 20
+
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+
+	return n, err
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	err := c.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF && c.ctx.Err() == nil {
+		err = &transferError{err}
+	}
+
+	return n, err
+}
+
+// contentLength returns the size of the file at url from a HEAD request, or
+// -1 if the server doesn't send one.
+func (c *HacClient) contentLength(ctx context.Context, url string) (int64, error) {
+	resp, err := c.DoRequestContext(ctx, "HEAD", url, []tls.Certificate{c.DeviceCert}, false, true)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return -1, statusError(ServiceAtum, resp)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// getSegmentableSize returns the size of the file at url, or 0 if the server
+// doesn't support range requests or the file is too small to be worth splitting.
+func (c *HacClient) getSegmentableSize(ctx context.Context, url string) (int64, error) {
+	resp, err := c.DoRequestContext(ctx, "HEAD", url, []tls.Certificate{c.DeviceCert}, false, true)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, nil
+	}
+
+	if resp.ContentLength < minSegmentSize*int64(c.Segments) {
+		return 0, nil
+	}
+
+	return resp.ContentLength, nil
+}
+
+// segmentSuffix is appended to files while they're downloaded in segments.
+// The file is preallocated to its full size, so it's only renamed to path
+// once every segment is written, a resumed download never mistakes it for a
+// complete one.
+const segmentSuffix = ".seg"
+
+// downloadSegmented downloads url to path in c.Segments parallel range
+// requests. On failure nothing is left at path, so a retry starts over.
+func (c *HacClient) downloadSegmented(ctx context.Context, url, path string, size int64) error {
+	tmp := path + segmentSuffix
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = c.writeSegments(ctx, url, out, size)
+	cerr := out.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (c *HacClient) writeSegments(ctx context.Context, url string, out *os.File, size int64) error {
+	err := out.Truncate(size)
+	if err != nil {
+		return err
+	}
+
+	logf(c.Log, "downloading %s to %s in %d segments", url, out.Name(), c.Segments)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	segmentSize := size / int64(c.Segments)
+	p := newProgressTracker(c.Progress, 0, size)
+
+	var wg sync.WaitGroup
+	errs := make([]error, c.Segments)
+	for i := 0; i < c.Segments; i++ {
+		start := int64(i) * segmentSize
+		end := start + segmentSize - 1
+		if i == c.Segments-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		go func(i int, start, end int64) {
+			defer wg.Done()
+			errs[i] = c.downloadSegment(ctx, url, out, start, end, p)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	p.finish()
+
+	return nil
+}
+
+func (c *HacClient) downloadSegment(ctx context.Context, url string, out io.WriterAt, start, end int64, p *progressTracker) error {
+	for attempt := 1; ; attempt++ {
+		n, err := c.fetchRange(ctx, url, out, start, end, p)
+		start += n
+
+		var te *transferError
+		if err == nil || !errors.As(err, &te) || attempt >= c.Retry.attempts() || ctx.Err() != nil {
+			return err
+		}
+
+		logf(c.Log, "retrying %s after %v, attempt %d of %d", url, err, attempt+1, c.Retry.attempts())
+		c.stats.retry(url)
+
+		err = c.Retry.wait(ctx, attempt-1)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *HacClient) fetchRange(ctx context.Context, url string, out io.WriterAt, start, end int64, p *progressTracker) (int64, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := c.doRequest(ctx, "GET", url, []tls.Certificate{c.DeviceCert}, false, true, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("segment request for bytes %d-%d: %w", start, end, statusError(ServiceAtum, resp))
+	}
+
+	n, err := io.Copy(&progressWriter{&offsetWriter{out, start}, p}, &contextReader{ctx, c.Bandwidth.reader(ctx, resp.Body)})
+	if err != nil {
+		return n, err
+	}
+
+	if n != end-start+1 {
+		return n, &transferError{fmt.Errorf("segment %d-%d is short, got %d bytes", start, end, n)}
+	}
+
+	return n, nil
+}
+
+func (c *HacClient) DownloadTo(url string, w io.Writer) error {
+	return c.DownloadToContext(context.Background(), url, w)
+}
+
+func (c *HacClient) DownloadToContext(ctx context.Context, url string, w io.Writer) error {
+	var written int64
+	for attempt := 1; ; attempt++ {
+		n, err := c.downloadTo(ctx, url, w, written)
+		written += n
+
+		var te *transferError
+		if err == nil || !errors.As(err, &te) || attempt >= c.Retry.attempts() || ctx.Err() != nil {
+			return err
+		}
+
+		logf(c.Log, "retrying %s after %v, attempt %d of %d", url, err, attempt+1, c.Retry.attempts())
+		c.stats.retry(url)
+
+		err = c.Retry.wait(ctx, attempt-1)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *HacClient) downloadTo(ctx context.Context, url string, w io.Writer, offset int64) (int64, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.doRequest(ctx, "GET", url, []tls.Certificate{c.DeviceCert}, false, true, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, statusError(ServiceAtum, resp)
+	}
+
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("can't resume stream at byte %d, server returned %s", offset, resp.Status)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	p := newProgressTracker(c.Progress, offset, total)
+	n, err := io.Copy(&progressWriter{w, p}, &contextReader{ctx, c.Bandwidth.reader(ctx, resp.Body)})
+	if err != nil {
+		return n, err
+	}
+	p.finish()
+
+	return n, nil
+}
+
+func hashFilePrefix(path string, n int64, h gohash.Hash) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(h, f, n)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func verifyDownload(path string, expected []byte, h gohash.Hash, streamed bool) error {
+	if !streamed {
+		h.Reset()
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		err = hashFilePrefix(path, fi.Size(), h)
+		if err != nil {
+			return err
+		}
+	}
+
+	sum := h.Sum(nil)
+	if !bytes.Equal(sum, expected) {
+		os.Remove(path)
+		return fmt.Errorf("%w for %s: expected %x, got %x", ErrHashMismatch, path, expected, sum)
+	}
+
+	return nil
+}
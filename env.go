@@ -0,0 +1,66 @@
+package libhac
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DefaultEnvironment = "lp1"
+
+const (
+	ServiceAtum     = "atum"
+	ServiceSuperfly = "superfly"
+	ServiceSun      = "sun"
+	ServiceTagaya   = "tagaya"
+	ServiceShogun   = "shogun"
+	ServiceDauth    = "dauth"
+)
+
+// DefaultHostTemplates maps every service to its host, with %s standing in
+// for the environment.
+var DefaultHostTemplates = map[string]string{
+	ServiceAtum:     "atum.hac.%s.d4c.nintendo.net",
+	ServiceSuperfly: "superfly.hac.%s.d4c.nintendo.net",
+	ServiceSun:      "sun.hac.%s.d4c.nintendo.net",
+	ServiceTagaya:   "tagaya.hac.%s.eshop.nintendo.net",
+	ServiceShogun:   "bugyo.hac.%s.eshop.nintendo.net",
+	ServiceDauth:    "dauth-%s.ndas.srv.nintendo.net",
+}
+
+func (c *HacClient) environment() string {
+	if c.Environment == "" {
+		return DefaultEnvironment
+	}
+
+	return c.Environment
+}
+
+// host returns the host of service in the client's environment.
+func (c *HacClient) host(service string) string {
+	t, ok := c.HostTemplates[service]
+	if !ok {
+		t = DefaultHostTemplates[service]
+	}
+
+	if !strings.Contains(t, "%s") {
+		return t
+	}
+
+	return fmt.Sprintf(t, c.environment())
+}
+
+// serviceURL builds the url of path on service, path is a format string for
+// args.
+func (c *HacClient) serviceURL(service, path string, args ...interface{}) string {
+	return c.baseURL(service) + fmt.Sprintf(path, args...)
+}
+
+// baseURL returns the scheme and host requests to service go to, which is
+// the override from BaseURLs if there is one.
+func (c *HacClient) baseURL(service string) string {
+	if base, ok := c.BaseURLs[service]; ok {
+		return strings.TrimSuffix(base, "/")
+	}
+
+	return "https://" + c.host(service)
+}
@@ -0,0 +1,96 @@
+package libhac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	// ErrTitleNotOnCDN is returned for titles or versions the cdn doesn't
+	// have.
+	ErrTitleNotOnCDN     = errors.New("title not on cdn")
+	ErrInvalidEdgeToken  = errors.New("edge token is invalid")
+	ErrInvalidDauthToken = errors.New("dauth token is invalid")
+	// ErrHashMismatch is returned when downloaded content doesn't match the
+	// hash in its meta.
+	ErrHashMismatch = errors.New("hash mismatch")
+	// ErrUnsupportedStorage is matched by StorageError.
+	ErrUnsupportedStorage = errors.New("unsupported nca storage")
+)
+
+// maxErrorBody limits how much of an error response is read for its code.
+const maxErrorBody = 64 << 10
+
+// CDNStatusError is returned for unexpected response codes from nintendo's
+// servers. Code and Message are taken from the response body if it has them.
+type CDNStatusError struct {
+	// Service is the service the request went to, like ServiceAtum.
+	Service    string
+	URL        string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *CDNStatusError) Error() string {
+	s := fmt.Sprintf("%s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		s += fmt.Sprintf(": %s %s", e.Code, e.Message)
+	}
+
+	return s
+}
+
+// Is makes 401 responses of the services requiring an edge token match
+// ErrInvalidEdgeToken and 401 and 403 responses of shogun match
+// ErrInvalidDauthToken, which is what they answer bad tokens with.
+func (e *CDNStatusError) Is(target error) bool {
+	switch target {
+	case ErrInvalidEdgeToken:
+		return e.StatusCode == http.StatusUnauthorized && (e.Service == ServiceAtum || e.Service == ServiceSuperfly)
+	case ErrInvalidDauthToken:
+		return (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden) && e.Service == ServiceShogun
+	}
+
+	return false
+}
+
+// statusError reads the error code from the body of resp from service, the
+// body is not usable afterwards.
+func statusError(service string, resp *http.Response) error {
+	e := &CDNStatusError{Service: service, StatusCode: resp.StatusCode}
+	if resp.Request != nil {
+		e.URL = resp.Request.URL.String()
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return e
+	}
+
+	// most services use the errors list, some a single error object
+	r := struct {
+		ErrorResponse
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	if json.Unmarshal(body, &r) != nil {
+		return e
+	}
+
+	switch {
+	case len(r.Errors) > 0:
+		e.Code, e.Message = r.Errors[0].Code, r.Errors[0].Message
+	case r.Error.Code != "":
+		e.Code, e.Message = r.Error.Code, r.Error.Message
+	}
+
+	return e
+}
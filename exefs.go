@@ -0,0 +1,64 @@
+package libhac
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// exeFS returns the decrypted ExeFS of a Program NCA, its first section.
+func (n *ncaFile) exeFS() (*io.SectionReader, error) {
+	if n.header.ContentType != 0 {
+		return nil, errors.New("not a program nca")
+	}
+
+	if !n.sectionExists(0) || n.header.FsHeaders[0].FsType != 1 {
+		return nil, errors.New("program nca has no exefs")
+	}
+
+	return n.dataReader(0)
+}
+
+// ReadExeFS decrypts the ExeFS of a Program NCA and returns its files (main,
+// main.npdm, rtld, sdk and the subsdk NSOs) together with the decrypted
+// ExeFS their offsets are relative to.
+func ReadExeFS(r io.ReaderAt, keys *Keyset) (*io.SectionReader, []PFS0Entry, error) {
+	nca, err := openNCA(r, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exefs, err := nca.exeFS()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := readPFS0(exefs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return exefs, entries, nil
+}
+
+// ExtractExeFS decrypts the ExeFS of the Program NCA at path and writes its
+// files to out, like hactool's --exefsdir.
+func ExtractExeFS(path, out string, keys *Keyset) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	exefs, err := nca.exeFS()
+	if err != nil {
+		return err
+	}
+
+	return extractPFS0(exefs, out)
+}
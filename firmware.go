@@ -0,0 +1,221 @@
+package libhac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const SystemUpdateTitleID = "0100000000000816"
+
+// DownloadFirmware downloads the SystemUpdate meta of version ver and every
+// system title it references into dest, which can then be installed with
+// Daybreak. A negative ver downloads the latest firmware.
+func (c *HacClient) DownloadFirmware(ver int, dest string) ([]TitleManifest, error) {
+	return c.DownloadFirmwareContext(context.Background(), ver, dest)
+}
+
+func (c *HacClient) DownloadFirmwareContext(ctx context.Context, ver int, dest string) ([]TitleManifest, error) {
+	ctx, span := c.startSpan(ctx, "DownloadFirmware", map[string]interface{}{"version": ver, "dest": dest})
+	m, err := c.downloadFirmware(ctx, ver, dest)
+	span.End(err)
+
+	return m, err
+}
+
+func (c *HacClient) downloadFirmware(ctx context.Context, ver int, dest string) ([]TitleManifest, error) {
+	if ver < 0 {
+		m, err := c.GetSystemUpdateMetaContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range m.Metas {
+			if v.ID == SystemUpdateTitleID {
+				ver = v.Version
+			}
+		}
+
+		if ver < 0 {
+			return nil, errors.New("no system update in the system update meta")
+		}
+	}
+
+	err := os.MkdirAll(dest, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []TitleManifest{}
+	seen := map[string]bool{}
+
+	err = c.downloadSystemTitle(ctx, SystemUpdateTitleID, ver, dest, seen, &manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifests, nil
+}
+
+// downloadSystemTitle downloads a system title's meta and contents into the
+// flat dest directory Daybreak expects, recursing into the metas it
+// references.
+func (c *HacClient) downloadSystemTitle(ctx context.Context, tid string, ver int, dest string,
+	seen map[string]bool, manifests *[]TitleManifest) error {
+	key := fmt.Sprintf("%s/%d", tid, ver)
+	if seen[key] {
+		return nil
+	}
+	seen[key] = true
+
+	cnmtID, err := c.GetSystemCNMTIDContext(ctx, tid, ver)
+	if err != nil {
+		return fmt.Errorf("%s v%d: %w", tid, ver, err)
+	}
+
+	m := TitleManifest{
+		TitleID:  tid,
+		Version:  ver,
+		CNMTID:   cnmtID,
+		CNMTPath: filepath.Join(dest, cnmtID+".cnmt.nca"),
+	}
+
+	err = c.DownloadSystemCNMTContext(ctx, cnmtID, m.CNMTPath)
+	if err != nil {
+		return err
+	}
+	m.Files = append(m.Files, m.CNMTPath)
+
+	m.CNMT, err = c.decryptAndParseCNMT(m.CNMTPath)
+	if err != nil {
+		return err
+	}
+
+	err = c.checkContentSpace(dest, m.CNMT.ContentEntries)
+	if err != nil {
+		return err
+	}
+
+	for _, ce := range m.CNMT.ContentEntries {
+		path := filepath.Join(dest, ce.IDString()+".nca")
+
+		err = c.DownloadContentEntryContext(ctx, ce, path)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, path)
+	}
+
+	*manifests = append(*manifests, m)
+
+	for _, v := range m.CNMT.ContentMetaEntries {
+		err = c.downloadSystemTitle(ctx, fmt.Sprintf("%016x", v.ID), int(v.Version), dest, seen, manifests)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SystemVersionTitleID is the system data title holding the version of the
+// firmware it's part of.
+const SystemVersionTitleID = "0100000000000809"
+
+// SystemVersion is the file in the RomFS of the SystemVersion title.
+type SystemVersion struct {
+	Major          uint8
+	Minor          uint8
+	Micro          uint8
+	RevisionMajor  uint8
+	RevisionMinor  uint8
+	Platform       string
+	VersionHash    string
+	DisplayVersion string
+	DisplayTitle   string
+}
+
+// String returns the display version, like "17.0.1".
+func (s SystemVersion) String() string {
+	if s.DisplayVersion != "" {
+		return s.DisplayVersion
+	}
+
+	return fmt.Sprintf("%d.%d.%d", s.Major, s.Minor, s.Micro)
+}
+
+// Version returns the version as a raw system version, without the
+// revision.
+func (s SystemVersion) Version() Version {
+	return Version(s.Major)<<26 | Version(s.Minor)<<20 | Version(s.Micro)<<16
+}
+
+func ParseSystemVersion(data []byte) (SystemVersion, error) {
+	if len(data) < 0x100 {
+		return SystemVersion{}, errors.New("system version file is too small")
+	}
+
+	return SystemVersion{
+		Major:          data[0],
+		Minor:          data[1],
+		Micro:          data[2],
+		RevisionMajor:  data[4],
+		RevisionMinor:  data[5],
+		Platform:       cString(data[0x8:0x28]),
+		VersionHash:    cString(data[0x28:0x68]),
+		DisplayVersion: cString(data[0x68:0x80]),
+		DisplayTitle:   cString(data[0x80:0x100]),
+	}, nil
+}
+
+// ReadFirmwareVersion finds the SystemVersion NCA among the NCAs in dir, as
+// downloaded by DownloadFirmware, and reads the version from it.
+func ReadFirmwareVersion(dir string, keys *Keyset) (SystemVersion, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.nca"))
+	if err != nil {
+		return SystemVersion{}, err
+	}
+
+	for _, v := range paths {
+		if strings.HasSuffix(v, ".cnmt.nca") {
+			continue
+		}
+
+		h, err := ParseNCAHeader(v, keys)
+		if err != nil {
+			return SystemVersion{}, fmt.Errorf("%s: %w", filepath.Base(v), err)
+		}
+
+		if fmt.Sprintf("%016x", h.ProgramID) != SystemVersionTitleID || h.ContentType != 4 {
+			continue
+		}
+
+		return readSystemVersionNCA(v, keys)
+	}
+
+	return SystemVersion{}, fmt.Errorf("no nca of %s in %s", SystemVersionTitleID, dir)
+}
+
+func readSystemVersionNCA(path string, keys *Keyset) (SystemVersion, error) {
+	nca, err := OpenNCA(path, keys)
+	if err != nil {
+		return SystemVersion{}, err
+	}
+	defer nca.Close()
+
+	r, err := nca.OpenRomFSFile("file")
+	if err != nil {
+		return SystemVersion{}, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return SystemVersion{}, err
+	}
+
+	return ParseSystemVersion(data)
+}
@@ -0,0 +1,8 @@
+module github.com/jakibaki/libhac
+
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/crypto v0.31.0
+)
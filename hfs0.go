@@ -0,0 +1,262 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type HFS0Entry struct {
+	Name       string
+	Offset     int64
+	Size       int64
+	HashedSize uint32
+	Hash       [0x20]byte
+}
+
+type hfs0FileEntry struct {
+	Offset           uint64
+	Size             uint64
+	StringTableIndex uint32
+	HashedSize       uint32
+	_                uint64
+	Hash             [0x20]byte
+}
+
+type HFS0Reader struct {
+	r       io.ReaderAt
+	Entries []HFS0Entry
+}
+
+func NewHFS0Reader(r io.ReaderAt) (*HFS0Reader, error) {
+	h := pfs0Header{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x10), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(h.Magic[:]) != "HFS0" {
+		return nil, errors.New("invalid hfs0 magic")
+	}
+
+	stringTableOffset := 0x10 + int64(h.FileCount)*0x40
+	dataOffset := stringTableOffset + int64(h.StringTableSize)
+
+	tables, err := readSection(r, 0x10, dataOffset-0x10)
+	if err != nil {
+		return nil, fmt.Errorf("hfs0 header: %w", err)
+	}
+
+	fes := make([]hfs0FileEntry, h.FileCount)
+	err = binary.Read(bytes.NewReader(tables), binary.LittleEndian, fes)
+	if err != nil {
+		return nil, err
+	}
+
+	stringTable := tables[stringTableOffset-0x10:]
+
+	entries := []HFS0Entry{}
+	for _, v := range fes {
+		if v.StringTableIndex >= h.StringTableSize {
+			return nil, errors.New("hfs0 string table index out of range")
+		}
+
+		name := stringTable[v.StringTableIndex:]
+		if i := bytes.IndexByte(name, 0); i != -1 {
+			name = name[:i]
+		}
+
+		entries = append(entries, HFS0Entry{
+			string(name),
+			dataOffset + int64(v.Offset),
+			int64(v.Size),
+			v.HashedSize,
+			v.Hash,
+		})
+	}
+
+	return &HFS0Reader{r, entries}, nil
+}
+
+func (h *HFS0Reader) Entry(name string) (HFS0Entry, error) {
+	for _, v := range h.Entries {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+
+	return HFS0Entry{}, fmt.Errorf("%s not found in hfs0", name)
+}
+
+func (h *HFS0Reader) Open(name string) (*io.SectionReader, error) {
+	e, err := h.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(h.r, e.Offset, e.Size), nil
+}
+
+// Extract writes the named entries to out, or every entry if none are given.
+func (h *HFS0Reader) Extract(out string, names ...string) error {
+	err := os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	entries := h.Entries
+	if len(names) > 0 {
+		entries = []HFS0Entry{}
+		for _, name := range names {
+			e, err := h.Entry(name)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	for _, v := range entries {
+		if strings.ContainsAny(v.Name, `/\`) || v.Name == ".." {
+			return fmt.Errorf("refusing to extract unsafe name %q", v.Name)
+		}
+
+		err = writeFile(filepath.Join(out, v.Name), io.NewSectionReader(h.r, v.Offset, v.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verify checks the hash of every entry's hashed region.
+func (h *HFS0Reader) Verify() ([]VerifyResult, error) {
+	results := []VerifyResult{}
+	for _, v := range h.Entries {
+		if int64(v.HashedSize) > v.Size {
+			results = append(results, VerifyResult{v.Name, false, "hashed region is larger than the file"})
+			continue
+		}
+
+		buf, err := readSection(h.r, v.Offset, int64(v.HashedSize))
+		if err != nil {
+			return nil, err
+		}
+
+		if sha256.Sum256(buf) != v.Hash {
+			results = append(results, VerifyResult{v.Name, false, "hash mismatch"})
+			continue
+		}
+
+		results = append(results, VerifyResult{v.Name, true, ""})
+	}
+
+	return results, nil
+}
+
+// HFS0Writer streams a HFS0 archive to w. Like NSPWriter entries are consumed
+// on Close, only the hashed region of each entry is buffered.
+type HFS0Writer struct {
+	w          io.Writer
+	entries    []NSPEntry
+	HashedSize int64
+	// Log is optional and gets every entry as it's written.
+	Log Logger
+}
+
+func NewHFS0Writer(w io.Writer) *HFS0Writer {
+	return &HFS0Writer{w: w, HashedSize: 0x200}
+}
+
+func (h *HFS0Writer) Add(name string, size int64, r io.Reader) {
+	h.entries = append(h.entries, NSPEntry{name, size, r})
+}
+
+func (h *HFS0Writer) Close() error {
+	n := []string{}
+	for _, v := range h.entries {
+		n = append(n, v.Name)
+	}
+
+	stringTable := []byte(strings.Join(n, "\x00") + "\x00")
+	headerSize := 0x10 + len(h.entries)*0x40 + len(stringTable)
+	if rem := headerSize % 0x200; rem != 0 {
+		stringTable = append(stringTable, make([]byte, 0x200-rem)...)
+	}
+
+	heads := make([][]byte, len(h.entries))
+	fes := []hfs0FileEntry{}
+
+	var offset uint64
+	var nameOffset uint32
+	for i, v := range h.entries {
+		hashed := h.HashedSize
+		if v.Size < hashed {
+			hashed = v.Size
+		}
+
+		heads[i] = make([]byte, hashed)
+		_, err := io.ReadFull(v.Reader, heads[i])
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", v.Name, err)
+		}
+
+		fes = append(fes, hfs0FileEntry{
+			Offset:           offset,
+			Size:             uint64(v.Size),
+			StringTableIndex: nameOffset,
+			HashedSize:       uint32(hashed),
+			Hash:             sha256.Sum256(heads[i]),
+		})
+
+		offset += uint64(v.Size)
+		nameOffset += uint32(len(v.Name) + 1)
+	}
+
+	err := binary.Write(h.w, binary.LittleEndian, pfs0Header{
+		Magic:           [4]byte{'H', 'F', 'S', '0'},
+		FileCount:       uint32(len(h.entries)),
+		StringTableSize: uint32(len(stringTable)),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(h.w, binary.LittleEndian, fes)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.w.Write(stringTable)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range h.entries {
+		logf(h.Log, "packing %s, %d bytes", v.Name, v.Size)
+		_, err = h.w.Write(heads[i])
+		if err != nil {
+			return err
+		}
+
+		rest := v.Size - int64(len(heads[i]))
+		written, err := io.Copy(h.w, io.LimitReader(v.Reader, rest))
+		if err != nil {
+			return err
+		}
+
+		if written != rest {
+			return fmt.Errorf("%s is %d bytes, expected %d", v.Name, written+int64(len(heads[i])), v.Size)
+		}
+	}
+
+	return nil
+}
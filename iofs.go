@@ -0,0 +1,193 @@
+package libhac
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// containerFS is a read-only fs.FS over the files of a PFS0, HFS0 or RomFS
+// image, directories are derived from the file paths.
+type containerFS struct {
+	r     io.ReaderAt
+	files map[string]containerEntry
+	dirs  map[string][]fs.DirEntry
+}
+
+type containerEntry struct {
+	name   string
+	offset int64
+	size   int64
+	dir    bool
+}
+
+// newContainerFS builds a file system from paths relative to the root with
+// their offsets in r.
+func newContainerFS(r io.ReaderAt, files []RomFSFile) *containerFS {
+	c := &containerFS{r, map[string]containerEntry{}, map[string][]fs.DirEntry{".": nil}}
+
+	for _, v := range files {
+		p := strings.TrimPrefix(path.Clean("/"+v.Path), "/")
+		if p == "" {
+			continue
+		}
+
+		c.add(p, containerEntry{path.Base(p), v.Offset, v.Size, false})
+	}
+
+	for _, v := range c.dirs {
+		sort.Slice(v, func(i, j int) bool { return v[i].Name() < v[j].Name() })
+	}
+
+	return c
+}
+
+func (c *containerFS) add(p string, e containerEntry) {
+	if _, ok := c.files[p]; ok {
+		return
+	}
+	c.files[p] = e
+
+	if e.dir {
+		c.dirs[p] = nil
+	}
+
+	parent := path.Dir(p)
+	if _, ok := c.dirs[parent]; !ok {
+		c.add(parent, containerEntry{name: path.Base(parent), dir: true})
+	}
+	c.dirs[parent] = append(c.dirs[parent], fs.FileInfoToDirEntry(e.info()))
+}
+
+func (c *containerFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if entries, ok := c.dirs[name]; ok {
+		e := containerEntry{name: path.Base(name), dir: true}
+		return &containerDir{e, entries, 0}, nil
+	}
+
+	e, ok := c.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &containerFile{io.NewSectionReader(c.r, e.offset, e.size), e}, nil
+}
+
+func (e containerEntry) info() fs.FileInfo {
+	return containerInfo(e)
+}
+
+type containerInfo containerEntry
+
+func (i containerInfo) Name() string       { return i.name }
+func (i containerInfo) Size() int64        { return i.size }
+func (i containerInfo) ModTime() time.Time { return time.Time{} }
+func (i containerInfo) IsDir() bool        { return i.dir }
+func (i containerInfo) Sys() interface{}   { return nil }
+
+func (i containerInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+type containerFile struct {
+	*io.SectionReader
+	e containerEntry
+}
+
+func (f *containerFile) Stat() (fs.FileInfo, error) { return f.e.info(), nil }
+func (f *containerFile) Close() error               { return nil }
+
+type containerDir struct {
+	e       containerEntry
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *containerDir) Stat() (fs.FileInfo, error) { return d.e.info(), nil }
+func (d *containerDir) Close() error               { return nil }
+
+func (d *containerDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errors.New("is a directory")}
+}
+
+func (d *containerDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return append([]fs.DirEntry(nil), rest...), nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+
+	return append([]fs.DirEntry(nil), rest[:n]...), nil
+}
+
+// pfs0Files converts PFS0 entries for newContainerFS.
+func pfs0Files(entries []PFS0Entry) []RomFSFile {
+	files := make([]RomFSFile, 0, len(entries))
+	for _, v := range entries {
+		files = append(files, RomFSFile{v.Name, v.Offset, v.Size})
+	}
+
+	return files
+}
+
+// NewRomFSFS returns a read-only fs.FS over a decrypted RomFS image.
+func NewRomFSFS(r io.ReaderAt) (fs.FS, error) {
+	files, err := ReadRomFS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newContainerFS(r, files), nil
+}
+
+// NewPFS0FS returns a read-only fs.FS over a PFS0 image, like an NSP or a
+// decrypted ExeFS.
+func NewPFS0FS(r io.ReaderAt) (fs.FS, error) {
+	entries, err := readPFS0(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newContainerFS(r, pfs0Files(entries)), nil
+}
+
+// FS returns the files of the NSP as an fs.FS.
+func (n *NSPReader) FS() fs.FS {
+	return newContainerFS(n.r, pfs0Files(n.Entries))
+}
+
+// FS returns the decrypted PFS0 or RomFS of section i as an fs.FS, e.g. the
+// ExeFS of a Program NCA is section 0.
+func (n *NCAReader) FS(i int) (fs.FS, error) {
+	data, err := n.Data(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.nca.header.FsHeaders[i].FsType == 0 {
+		return NewRomFSFS(data)
+	}
+
+	return NewPFS0FS(data)
+}
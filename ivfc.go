@@ -0,0 +1,183 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxReportedBlocks limits how many bad blocks an IVFCError lists, a wrong
+// key makes every block of a section fail.
+const maxReportedBlocks = 100
+
+// IVFCBlock is a block of an IVFC level. Level 0 is covered by the master
+// hash in the NCA header and the last level is the RomFS itself.
+type IVFCBlock struct {
+	Level int
+	Index int64
+}
+
+// IVFCError is returned for IVFC hash trees with blocks not matching their
+// hashes. Blocks holds the first bad blocks, Failed counts all of them.
+type IVFCError struct {
+	Section int
+	Blocks  []IVFCBlock
+	Failed  int64
+}
+
+func (e *IVFCError) Error() string {
+	s := fmt.Sprintf("%d ivfc blocks of section %d failed verification", e.Failed, e.Section)
+	if len(e.Blocks) > 0 {
+		s += fmt.Sprintf(", first at level %d block %d", e.Blocks[0].Level, e.Blocks[0].Index)
+	}
+
+	return s
+}
+
+// Is makes IVFCErrors match ErrHashMismatch.
+func (e *IVFCError) Is(target error) bool {
+	return target == ErrHashMismatch
+}
+
+func (e *IVFCError) add(level int, index int64) {
+	if len(e.Blocks) < maxReportedBlocks {
+		e.Blocks = append(e.Blocks, IVFCBlock{level, index})
+	}
+	e.Failed++
+}
+
+// verifyIVFC checks every level of the IVFC hash tree of section i against
+// the level before it, starting at the master hash.
+// dataLevel returns the last level of the hash tree, which holds the actual
+// RomFS rather than hashes.
+func (ivfc ivfcSuperblock) dataLevel() (ivfcLevel, error) {
+	levels := int(ivfc.LevelCount) - 1
+	if levels < 1 || levels > len(ivfc.Levels) {
+		return ivfcLevel{}, fmt.Errorf("invalid ivfc level count %d", ivfc.LevelCount)
+	}
+
+	return ivfc.Levels[levels-1], nil
+}
+
+func (n *ncaFile) verifyIVFC(i int) error {
+	fs := n.header.FsHeaders[i]
+	if fs.FsType != 0 {
+		return fmt.Errorf("nca section %d is not a romfs", i)
+	}
+
+	ivfc := ivfcSuperblock{}
+	err := binary.Read(bytes.NewReader(fs.HashInfo[:]), binary.LittleEndian, &ivfc)
+	if err != nil {
+		return err
+	}
+
+	if string(ivfc.Magic[:]) != "IVFC" {
+		return errors.New("invalid ivfc magic")
+	}
+
+	levels := int(ivfc.LevelCount) - 1
+	if levels < 1 || levels > len(ivfc.Levels) {
+		return fmt.Errorf("invalid ivfc level count %d", ivfc.LevelCount)
+	}
+
+	if ivfc.MasterHashSize == 0 || ivfc.MasterHashSize > uint32(len(ivfc.MasterHash)) {
+		return fmt.Errorf("invalid ivfc master hash size %d", ivfc.MasterHashSize)
+	}
+
+	sr, err := n.sectionReader(i)
+	if err != nil {
+		return err
+	}
+
+	e := &IVFCError{Section: i}
+	hashes := ivfc.MasterHash[:ivfc.MasterHashSize]
+	for level := 0; level < levels; level++ {
+		l := ivfc.Levels[level]
+		data := io.NewSectionReader(sr, int64(l.Offset), int64(l.Size))
+
+		err = verifyIVFCLevel(data, int64(1)<<l.BlockSizeLog2, hashes, level, e)
+		if err != nil {
+			return err
+		}
+
+		if level == levels-1 {
+			break
+		}
+
+		// this level holds the hashes of the next
+		hashes, err = readSection(data, 0, data.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.Failed > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// verifyIVFCLevel hashes every block of data, a short last block is hashed
+// padded with zeros to the block size.
+func verifyIVFCLevel(data *io.SectionReader, blockSize int64, hashes []byte, level int, e *IVFCError) error {
+	if blockSize < sha256.Size || blockSize > 1<<24 {
+		return fmt.Errorf("invalid ivfc block size %d at level %d", blockSize, level)
+	}
+
+	blocks := (data.Size() + blockSize - 1) / blockSize
+	if blocks*sha256.Size > int64(len(hashes)) {
+		return fmt.Errorf("ivfc level %d has more blocks than hashes", level)
+	}
+
+	buf := make([]byte, blockSize)
+	for b := int64(0); b < blocks; b++ {
+		n, err := data.ReadAt(buf, b*blockSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		for j := n; j < len(buf); j++ {
+			buf[j] = 0
+		}
+
+		sum := sha256.Sum256(buf)
+		if !bytes.Equal(sum[:], hashes[b*sha256.Size:(b+1)*sha256.Size]) {
+			e.add(level, b)
+		}
+	}
+
+	return nil
+}
+
+// VerifyIVFC checks the IVFC hash trees of every RomFS section of the NCA at
+// path. Corrupted sections return an *IVFCError naming the bad blocks.
+func VerifyIVFC(path string, keys *Keyset) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) || nca.header.FsHeaders[i].FsType != 0 {
+			continue
+		}
+
+		err = nca.verifyIVFC(i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
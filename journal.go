@@ -0,0 +1,183 @@
+package libhac
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const (
+	journalAdd    = "add"
+	journalDone   = "done"
+	journalFailed = "failed"
+)
+
+// journalRecord is a line of the json-lines download journal.
+type journalRecord struct {
+	Op    string       `json:"op"`
+	ID    int          `json:"id"`
+	Job   *DownloadJob `json:"job,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+type downloadJournal struct {
+	mu     sync.Mutex
+	f      *os.File
+	nextID int
+	err    error
+}
+
+// OpenDownloadQueue is NewDownloadQueue with a journal at path recording
+// added, finished and failed jobs. Jobs that didn't finish, e.g. because the
+// process crashed or Run was canceled, are queued again when the journal is
+// opened, and resume from their partial files.
+func OpenDownloadQueue(c *HacClient, workers int, path string) (*DownloadQueue, error) {
+	q := NewDownloadQueue(c, workers)
+
+	pending, nextID, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// rewrite the journal with only the pending jobs so it doesn't grow
+	// forever
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, v := range pending {
+		err = enc.Encode(v)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	q.journal = &downloadJournal{f: f, nextID: nextID}
+	for _, v := range pending {
+		q.jobs = append(q.jobs, *v.Job)
+		q.ids = append(q.ids, v.ID)
+	}
+
+	return q, nil
+}
+
+// readJournal returns the add records of unfinished jobs and the next free
+// job id. Other records, like the progress ones older versions wrote, are
+// skipped.
+func readJournal(path string) ([]journalRecord, int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 1, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	jobs := map[int]*journalRecord{}
+	order := []int{}
+	nextID := 1
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		r := journalRecord{}
+		if json.Unmarshal(s.Bytes(), &r) != nil {
+			// the last line is cut off if the process died writing it
+			continue
+		}
+
+		if r.ID >= nextID {
+			nextID = r.ID + 1
+		}
+
+		switch r.Op {
+		case journalAdd:
+			if r.Job != nil {
+				jobs[r.ID] = &r
+				order = append(order, r.ID)
+			}
+		case journalDone, journalFailed:
+			delete(jobs, r.ID)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	pending := []journalRecord{}
+	for _, id := range order {
+		if j, ok := jobs[id]; ok {
+			pending = append(pending, *j)
+		}
+	}
+
+	return pending, nextID, nil
+}
+
+// write appends r, the first error is kept and returned by Close.
+func (j *downloadJournal) write(r journalRecord) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := json.Marshal(r)
+	if err == nil {
+		_, err = j.f.Write(append(data, '\n'))
+	}
+
+	if err != nil && j.err == nil {
+		j.err = err
+	}
+}
+
+func (j *downloadJournal) add(job DownloadJob) int {
+	j.mu.Lock()
+	id := j.nextID
+	j.nextID++
+	j.mu.Unlock()
+
+	j.write(journalRecord{Op: journalAdd, ID: id, Job: &job})
+
+	return id
+}
+
+func (j *downloadJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.f.Close()
+	if j.err != nil {
+		return j.err
+	}
+
+	return err
+}
@@ -0,0 +1,366 @@
+package libhac
+
+import (
+	"bufio"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Keyset struct {
+	HeaderKey             []byte
+	KeyAreaKeyApplication map[int][]byte
+	KeyAreaKeyOcean       map[int][]byte
+	KeyAreaKeySystem      map[int][]byte
+	TitleKeks             map[int][]byte
+	TitleKeys             map[string][]byte
+	Keys                  map[string][]byte
+}
+
+func NewKeyset() *Keyset {
+	return &Keyset{
+		KeyAreaKeyApplication: map[int][]byte{},
+		KeyAreaKeyOcean:       map[int][]byte{},
+		KeyAreaKeySystem:      map[int][]byte{},
+		TitleKeks:             map[int][]byte{},
+		TitleKeys:             map[string][]byte{},
+		Keys:                  map[string][]byte{},
+	}
+}
+
+func LoadKeyset(prodKeys, titleKeys string) (*Keyset, error) {
+	k := NewKeyset()
+
+	err := k.LoadKeys(prodKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if titleKeys != "" {
+		err = k.LoadTitleKeys(titleKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+func (k *Keyset) LoadKeys(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return k.ReadKeys(f)
+}
+
+func (k *Keyset) LoadTitleKeys(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return k.ReadTitleKeys(f)
+}
+
+func (k *Keyset) ReadKeys(r io.Reader) error {
+	return readKeyFile(r, func(name string, key []byte) error {
+		k.setKey(name, key)
+
+		return nil
+	})
+}
+
+func (k *Keyset) ReadTitleKeys(r io.Reader) error {
+	return readKeyFile(r, func(name string, key []byte) error {
+		if len(name) != 0x20 || len(key) != 0x10 {
+			return fmt.Errorf("invalid title key entry for %s", name)
+		}
+
+		k.TitleKeys[name] = key
+
+		return nil
+	})
+}
+
+func (k *Keyset) setKey(name string, key []byte) {
+	k.Keys[name] = key
+
+	if name == "header_key" {
+		k.HeaderKey = key
+		return
+	}
+
+	prefixes := map[string]map[int][]byte{
+		"key_area_key_application_": k.KeyAreaKeyApplication,
+		"key_area_key_ocean_":       k.KeyAreaKeyOcean,
+		"key_area_key_system_":      k.KeyAreaKeySystem,
+		"titlekek_":                 k.TitleKeks,
+	}
+
+	for prefix, keys := range prefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		gen, err := strconv.ParseInt(strings.TrimPrefix(name, prefix), 16, 64)
+		if err != nil {
+			return
+		}
+
+		keys[int(gen)] = key
+	}
+}
+
+func readKeyFile(r io.Reader, set func(name string, key []byte) error) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		i := strings.IndexAny(line, "=,")
+		if i == -1 {
+			return fmt.Errorf("malformed key file line %d", n)
+		}
+
+		name := strings.ToLower(strings.TrimSpace(line[:i]))
+		key, err := getHexBytes(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return fmt.Errorf("malformed key %s on line %d: %v", name, n, err)
+		}
+
+		err = set(name, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+func (k *Keyset) Key(name string) ([]byte, error) {
+	key, ok := k.Keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+
+	return key, nil
+}
+
+// TitleKek returns the titlekek of a key generation, deriving it from the
+// master key if prod.keys doesn't have it.
+func (k *Keyset) TitleKek(generation int) ([]byte, error) {
+	kek, ok := k.TitleKeks[generation]
+	if ok && len(kek) == 0x10 {
+		return kek, nil
+	}
+
+	kek, err := k.deriveTitleKek(generation)
+	if err != nil {
+		return nil, fmt.Errorf("titlekek_%02x is missing and can't be derived: %v", generation, err)
+	}
+
+	return kek, nil
+}
+
+func (k *Keyset) deriveTitleKek(generation int) ([]byte, error) {
+	masterKey, err := k.Key(fmt.Sprintf("master_key_%02x", generation))
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := k.Key("titlekek_source")
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptECB(masterKey, source)
+}
+
+// package2Key returns the package2 key of a key generation, deriving it from
+// the master key if prod.keys doesn't have it.
+func (k *Keyset) package2Key(generation int) ([]byte, error) {
+	key, err := k.Key(fmt.Sprintf("package2_key_%02x", generation))
+	if err == nil && len(key) == 0x10 {
+		return key, nil
+	}
+
+	masterKey, err := k.Key(fmt.Sprintf("master_key_%02x", generation))
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := k.Key("package2_key_source")
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptECB(masterKey, source)
+}
+
+func (k *Keyset) KeyAreaKey(index, generation int) ([]byte, error) {
+	var keys map[int][]byte
+	var name string
+	switch index {
+	case 0:
+		keys, name = k.KeyAreaKeyApplication, "application"
+	case 1:
+		keys, name = k.KeyAreaKeyOcean, "ocean"
+	case 2:
+		keys, name = k.KeyAreaKeySystem, "system"
+	default:
+		return nil, fmt.Errorf("invalid key area key index %d", index)
+	}
+
+	key, ok := keys[generation]
+	if ok && len(key) == 0x10 {
+		return key, nil
+	}
+
+	key, err := k.deriveKeyAreaKey(name, generation)
+	if err != nil {
+		return nil, fmt.Errorf("key_area_key_%s_%02x is missing and can't be derived: %v", name, generation, err)
+	}
+
+	return key, nil
+}
+
+// deriveKeyAreaKey generates a key area key from the master key of its
+// generation the same way the console does.
+func (k *Keyset) deriveKeyAreaKey(name string, generation int) ([]byte, error) {
+	masterKey, err := k.Key(fmt.Sprintf("master_key_%02x", generation))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []string{"aes_kek_generation_source", "key_area_key_" + name + "_source", "aes_key_generation_source"}
+
+	key := masterKey
+	for _, v := range sources {
+		source, err := k.Key(v)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err = decryptECB(key, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// DecryptKeyArea decrypts the 0x40 byte key area of an NCA header with the
+// key area key of index (application, ocean or system) and generation. The
+// AES-CTR key of the sections is at 0x20.
+func (k *Keyset) DecryptKeyArea(keyArea []byte, index, generation int) ([]byte, error) {
+	if len(keyArea) != 0x40 {
+		return nil, errors.New("key area must be 0x40 bytes")
+	}
+
+	kak, err := k.KeyAreaKey(index, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptECB(kak, keyArea)
+}
+
+// DecryptTitleKey decrypts a titlekey from a ticket or title.keys with the
+// titlekek of generation.
+func (k *Keyset) DecryptTitleKey(titleKey []byte, generation int) ([]byte, error) {
+	if len(titleKey) != 0x10 {
+		return nil, errors.New("titlekey must be 0x10 bytes")
+	}
+
+	kek, err := k.TitleKek(generation)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptECB(kek, titleKey)
+}
+
+// SectionKey returns the AES-CTR key of the sections of an NCA, from the
+// titlekey of its rights id or its key area for standard crypto.
+func (k *Keyset) SectionKey(h NCAHeader) ([]byte, error) {
+	if h.HasRightsID() {
+		rid, err := hex.DecodeString(h.RightsID)
+		if err != nil {
+			return nil, err
+		}
+
+		return k.titleKey(rid, h.MasterKeyRevision())
+	}
+
+	keyArea, err := k.DecryptKeyArea(h.KeyArea, int(h.KeyAreaKeyIndex), h.MasterKeyRevision())
+	if err != nil {
+		return nil, err
+	}
+
+	return keyArea[0x20:0x30], nil
+}
+
+func (k *Keyset) titleKey(rightsID []byte, generation int) ([]byte, error) {
+	enc, ok := k.TitleKeys[hex.EncodeToString(rightsID)]
+	if !ok || len(enc) != 0x10 {
+		return nil, fmt.Errorf("title key for rights id %x is missing", rightsID)
+	}
+
+	return k.DecryptTitleKey(enc, generation)
+}
+
+func (k *Keyset) headerKey() ([]byte, error) {
+	if len(k.HeaderKey) != 0x20 {
+		return nil, errors.New("header_key is missing")
+	}
+
+	return k.HeaderKey, nil
+}
+
+func decryptECB(key, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(in)%aes.BlockSize != 0 {
+		return nil, errors.New("input is not a multiple of the block size")
+	}
+
+	out := make([]byte, len(in))
+	for i := 0; i < len(in); i += aes.BlockSize {
+		block.Decrypt(out[i:i+aes.BlockSize], in[i:i+aes.BlockSize])
+	}
+
+	return out, nil
+}
+
+func encryptECB(key, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(in)%aes.BlockSize != 0 {
+		return nil, errors.New("input is not a multiple of the block size")
+	}
+
+	out := make([]byte, len(in))
+	for i := 0; i < len(in); i += aes.BlockSize {
+		block.Encrypt(out[i:i+aes.BlockSize], in[i:i+aes.BlockSize])
+	}
+
+	return out, nil
+}
@@ -0,0 +1,242 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// KIP is a kernel initial process from an INI1, like the sysmodules of
+// package2, with its segments decompressed.
+type KIP struct {
+	Name               string
+	ProgramID          uint64
+	Version            uint32
+	MainThreadPriority uint8
+	DefaultCore        uint8
+	Flags              uint8
+	Text               NSOSegment
+	RO                 NSOSegment
+	Data               NSOSegment
+	BSSSize            uint32
+	KernelCapabilities KernelCapabilities
+}
+
+type kipSegmentHeader struct {
+	MemoryOffset     uint32
+	DecompressedSize uint32
+	CompressedSize   uint32
+	Attribute        uint32
+}
+
+type kipHeader struct {
+	Magic              [4]byte
+	Name               [0xC]byte
+	ProgramID          uint64
+	Version            uint32
+	MainThreadPriority uint8
+	DefaultCore        uint8
+	_                  uint8
+	Flags              uint8
+	Segments           [6]kipSegmentHeader
+	Capabilities       [0x80]byte
+}
+
+const kipHeaderSize = 0x100
+
+type ini1Header struct {
+	Magic        [4]byte
+	Size         uint32
+	ProcessCount uint32
+	_            uint32
+}
+
+func ReadKIP(path string) (KIP, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return KIP{}, err
+	}
+
+	return ParseKIP(data)
+}
+
+// maxKIPSegmentSize bounds the sizes a KIP header can ask for, real
+// segments are a few MiB at most.
+const maxKIPSegmentSize = 64 << 20
+
+func ParseKIP(data []byte) (KIP, error) {
+	k, _, err := parseKIP(data)
+
+	return k, err
+}
+
+// parseKIP also returns the size of the KIP in data, they're stored back to
+// back in INI1s.
+func parseKIP(data []byte) (KIP, int, error) {
+	h := kipHeader{}
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h)
+	if err != nil {
+		return KIP{}, 0, errors.New("kip is too small")
+	}
+
+	if string(h.Magic[:]) != "KIP1" {
+		return KIP{}, 0, errors.New("invalid kip magic")
+	}
+
+	k := KIP{
+		Name:               strings.TrimRight(string(h.Name[:]), "\x00"),
+		ProgramID:          h.ProgramID,
+		Version:            h.Version,
+		MainThreadPriority: h.MainThreadPriority,
+		DefaultCore:        h.DefaultCore,
+		Flags:              h.Flags,
+		BSSSize:            h.Segments[3].DecompressedSize,
+	}
+
+	k.KernelCapabilities, err = parseKernelCapabilities(h.Capabilities[:])
+	if err != nil {
+		return KIP{}, 0, err
+	}
+
+	names := []string{"text", "ro", "data"}
+	segments := []*NSOSegment{&k.Text, &k.RO, &k.Data}
+	off := kipHeaderSize
+	for i, sh := range h.Segments[:3] {
+		end := off + int(sh.CompressedSize)
+		if end > len(data) || end < off {
+			return KIP{}, 0, fmt.Errorf("kip %s segment is out of range", names[i])
+		}
+
+		seg := append([]byte{}, data[off:end]...)
+		if h.Flags&(1<<uint(i)) != 0 {
+			seg, err = blzDecompress(seg)
+			if err != nil {
+				return KIP{}, 0, fmt.Errorf("kip %s segment: %v", names[i], err)
+			}
+		}
+
+		if sh.DecompressedSize > maxKIPSegmentSize {
+			return KIP{}, 0, fmt.Errorf("kip %s segment has an implausible size %d", names[i], sh.DecompressedSize)
+		}
+
+		if len(seg) > int(sh.DecompressedSize) {
+			return KIP{}, 0, fmt.Errorf("kip %s segment is larger than expected", names[i])
+		}
+
+		// segments may decompress to less than their size, the rest is zero
+		seg = append(seg, make([]byte, int(sh.DecompressedSize)-len(seg))...)
+		*segments[i] = NSOSegment{sh.MemoryOffset, seg}
+		off = end
+	}
+
+	return k, off, nil
+}
+
+// ParseINI1 unpacks the KIPs of an INI1 like the one in package2.
+func ParseINI1(data []byte) ([]KIP, error) {
+	h := ini1Header{}
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, errors.New("ini1 is too small")
+	}
+
+	if string(h.Magic[:]) != "INI1" {
+		return nil, errors.New("invalid ini1 magic")
+	}
+
+	if int(h.Size) > len(data) {
+		return nil, errors.New("ini1 is truncated")
+	}
+
+	if int(h.Size) < binary.Size(h) {
+		return nil, fmt.Errorf("invalid ini1 size %d", h.Size)
+	}
+
+	kips := []KIP{}
+	off := binary.Size(h)
+	for i := 0; i < int(h.ProcessCount); i++ {
+		k, n, err := parseKIP(data[off:h.Size])
+		if err != nil {
+			return nil, fmt.Errorf("kip %d of ini1: %v", i, err)
+		}
+
+		kips = append(kips, k)
+		off += n
+	}
+
+	return kips, nil
+}
+
+// blzDecompress undoes the backwards LZ compression of KIP segments. The
+// footer gives the size of the compressed part at the end of data, anything
+// before it is stored as is.
+func blzDecompress(data []byte) ([]byte, error) {
+	if len(data) < 0xC {
+		return nil, errors.New("blz data is too small")
+	}
+
+	footer := data[len(data)-0xC:]
+	compressedSize := int(binary.LittleEndian.Uint32(footer))
+	headerSize := int(binary.LittleEndian.Uint32(footer[4:]))
+	extra := int(binary.LittleEndian.Uint32(footer[8:]))
+
+	if compressedSize > len(data) || headerSize > compressedSize {
+		return nil, errors.New("invalid blz footer")
+	}
+
+	// a two byte match expands to at most 18 bytes
+	if extra > maxKIPSegmentSize || extra > compressedSize*9 {
+		return nil, fmt.Errorf("invalid blz decompressed size %d", extra)
+	}
+
+	out := append(append([]byte{}, data...), make([]byte, extra)...)
+	cmp := out[len(data)-compressedSize:]
+
+	in, outOff := compressedSize-headerSize, compressedSize+extra
+	for outOff > 0 {
+		if in < 1 {
+			return nil, errors.New("blz data is truncated")
+		}
+		in--
+		control := cmp[in]
+
+		for bit := 0; bit < 8 && outOff > 0; bit++ {
+			if control&0x80 == 0 {
+				if in < 1 {
+					return nil, errors.New("blz data is truncated")
+				}
+				in--
+				outOff--
+				cmp[outOff] = cmp[in]
+			} else {
+				if in < 2 {
+					return nil, errors.New("blz data is truncated")
+				}
+				in -= 2
+
+				v := int(cmp[in]) | int(cmp[in+1])<<8
+				size, off := v>>12&0xF+3, v&0xFFF+3
+				if size > outOff {
+					size = outOff
+				}
+				outOff -= size
+
+				if outOff+off+size > len(cmp) {
+					return nil, errors.New("blz match is out of range")
+				}
+
+				// copy backwards, the match may overlap what's being written
+				for j := size - 1; j >= 0; j-- {
+					cmp[outOff+j] = cmp[outOff+off+j]
+				}
+			}
+
+			control <<= 1
+		}
+	}
+
+	return out, nil
+}
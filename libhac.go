@@ -1,8 +1,13 @@
 package libhac
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 )
 
@@ -11,15 +16,90 @@ type HacClient struct {
 	ShopCert   tls.Certificate
 	DauthToken string
 	EdgeToken  string
+	DeviceID   string
+	Resume     bool
+	Segments   int
+	Progress   ProgressFunc
+	Retry      RetryPolicy
+	Dauth      *DauthConfig
+	Proxy      ProxyFunc
+	Bandwidth  *Throttle
+
+	VerifyHashes bool
+	Keys         *Keyset
+	HactoolPath  string
+	// Decryptor is optional and replaces decrypting with Keys or the
+	// hactool at HactoolPath.
+	Decryptor Decryptor
+	// delta fragments are only useful to patch an installed title in place,
+	// NSPs containing them don't install
+	IncludeDeltaFragments bool
+	// TitleDB is optional and used to name downloaded titles
+	TitleDB *TitleDB
+	// Environment defaults to DefaultEnvironment, HostTemplates overrides
+	// DefaultHostTemplates per service.
+	Environment   string
+	HostTemplates map[string]string
+	// BaseURLs points services at a mirror or caching proxy instead, e.g.
+	// {ServiceAtum: "http://cache.lan:8080"}. Paths are appended as is.
+	BaseURLs map[string]string
+	// Server certificates are verified against RootCAs, or the system roots
+	// if it's nil. PinnedCertificates holds CertificatePin values and
+	// replaces chain verification when set, the leaf must be pinned or chain up
+	// to a pinned certificate the server sent. InsecureSkipVerify turns off
+	// verification entirely.
+	RootCAs            *x509.CertPool
+	PinnedCertificates []string
+	InsecureSkipVerify bool
+	// CheckDiskSpace makes downloads fail with an InsufficientSpaceError
+	// up front instead of midway when the destination is too small.
+	CheckDiskSpace bool
+	// CDN is optional and receives the cdn requests instead of the client.
+	CDN CDNClient
+	// Log is optional and gets debug output of requests, retries and file
+	// operations.
+	Log Logger
+	// Tracer is optional and gets spans for requests and downloads.
+	Tracer Tracer
+	// Limiter is optional and can be shared between clients.
+	Limiter *RateLimiter
+	// Cache is optional and saves repeating content meta id and version
+	// list lookups. The version list is cached too, which delays updates
+	// found by an UpdateWatcher by up to its TTL.
+	Cache *MetadataCache
+
+	tokens     *tokenCache
+	stats      *statsCollector
+	transports *transportCache
 }
 
 func NewHacClient(deviceCert, deviceKey, dauthToken, edgeToken string) (HacClient, error) {
+	var certPEM, keyPEM []byte
+	if deviceCert != "" && deviceKey != "" {
+		var err error
+		certPEM, err = ioutil.ReadFile(deviceCert)
+		if err != nil {
+			return HacClient{}, err
+		}
+
+		keyPEM, err = ioutil.ReadFile(deviceKey)
+		if err != nil {
+			return HacClient{}, err
+		}
+	}
+
+	return NewHacClientFromPEM(certPEM, keyPEM, dauthToken, edgeToken)
+}
+
+// NewHacClientFromPEM is like NewHacClient with the device certificate and
+// key passed in memory instead of as files.
+func NewHacClientFromPEM(certPEM, keyPEM []byte, dauthToken, edgeToken string) (HacClient, error) {
 	// lolwut
 	err := errors.New("")
 
 	device := tls.Certificate{}
-	if deviceCert != "" && deviceKey != "" {
-		device, err = tls.LoadX509KeyPair(deviceCert, deviceKey)
+	if len(certPEM) > 0 && len(keyPEM) > 0 {
+		device, err = tls.X509KeyPair(certPEM, keyPEM)
 		if err != nil {
 			return HacClient{}, err
 		}
@@ -83,40 +163,126 @@ Wnep8QKBgQCLz/TeN8DapOZJ0ylBzo2F2Oa2RkgGYEtMevBqeIWSVwGG4d89cIig
 	}
 
 	return HacClient{
-		device,
-		shop,
-		dauthToken,
-		edgeToken,
+		DeviceCert: device,
+		ShopCert:   shop,
+		DauthToken: dauthToken,
+		EdgeToken:  edgeToken,
+		tokens:     &tokenCache{},
+		stats:      newStatsCollector(),
+		transports: &transportCache{},
 	}, nil
 }
 
 func (c *HacClient) DoRequest(method, url string, certs []tls.Certificate, sendDauthToken, sendEdgeToken bool) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, nil)
+	return c.DoRequestContext(context.Background(), method, url, certs, sendDauthToken, sendEdgeToken)
+}
+
+func (c *HacClient) DoRequestContext(ctx context.Context, method, url string, certs []tls.Certificate,
+	sendDauthToken, sendEdgeToken bool) (*http.Response, error) {
+	if c.CDN != nil {
+		return c.CDN.DoRequestContext(ctx, method, url, certs, sendDauthToken, sendEdgeToken)
+	}
+
+	return c.doRequest(ctx, method, url, certs, sendDauthToken, sendEdgeToken, nil, nil)
+}
+
+// doRequest sends a request directly, never through CDN. body is sent again
+// on retries.
+func (c *HacClient) doRequest(ctx context.Context, method, url string, certs []tls.Certificate,
+	sendDauthToken, sendEdgeToken bool, header http.Header, body []byte) (*http.Response, error) {
+	ctx, span := c.startSpan(ctx, "DoRequest", map[string]interface{}{"http.method": method, "http.url": url})
+	resp, err := c.sendRequest(ctx, method, url, certs, sendDauthToken, sendEdgeToken, header, body)
+	if err == nil {
+		span.SetAttribute("http.status_code", resp.StatusCode)
+	}
+	span.End(err)
+
+	return resp, err
+}
+
+func (c *HacClient) sendRequest(ctx context.Context, method, url string, certs []tls.Certificate,
+	sendDauthToken, sendEdgeToken bool, header http.Header, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
 	if err != nil {
 		return &http.Response{}, err
 	}
 
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	dauthToken, edgeToken := c.DauthToken, c.EdgeToken
+	if c.Dauth != nil && (sendDauthToken || sendEdgeToken) {
+		dauthToken, edgeToken, err = c.refreshTokens(ctx, sendDauthToken, sendEdgeToken)
+		if err != nil {
+			return &http.Response{}, err
+		}
+	}
+
 	if sendDauthToken {
-		req.Header.Set("X-DeviceAuthorization", c.DauthToken)
+		req.Header.Set("X-DeviceAuthorization", dauthToken)
 	}
 
 	if sendEdgeToken {
-		req.Header.Set("X-Nintendo-DenebEdgeToken", c.EdgeToken)
+		req.Header.Set("X-Nintendo-DenebEdgeToken", edgeToken)
 	}
 
-	client := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				Certificates:       certs,
-				InsecureSkipVerify: true,
-			},
-		},
-	}
+	client := c.httpClient(certs)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return &http.Response{}, err
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		release, err := c.Limiter.acquire(ctx, req.URL.Host)
+		if err != nil {
+			return &http.Response{}, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			release()
+		} else {
+			resp.Body = &releasingBody{resp.Body, release}
+		}
+
+		c.stats.request(req, resp, err)
+		if err == nil {
+			logf(c.Log, "%s %s: %s", method, url, resp.Status)
+		} else {
+			logf(c.Log, "%s %s: %v", method, url, err)
+		}
+
+		if err == nil && !c.Retry.retryStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		if attempt >= c.Retry.attempts() || ctx.Err() != nil || (err != nil && !retryableError(err)) {
+			if err != nil {
+				return &http.Response{}, err
+			}
+
+			return resp, nil
+		}
+
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		logf(c.Log, "retrying %s %s, attempt %d of %d", method, url, attempt+1, c.Retry.attempts())
+		c.stats.retry(url)
+		err = c.Retry.wait(ctx, attempt-1)
+		if err != nil {
+			return &http.Response{}, err
+		}
 	}
+}
 
-	return resp, nil
+func (c *HacClient) httpClient(certs []tls.Certificate) http.Client {
+	return http.Client{Transport: c.transport(certs)}
 }
@@ -0,0 +1,13 @@
+package libhac
+
+// Logger receives debug output about requests, retries and file operations,
+// a *log.Logger can be used as is.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func logf(l Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf(format, v...)
+	}
+}
@@ -0,0 +1,65 @@
+// Package metrics exports the Stats of a HacClient to prometheus.
+//
+//	reg := prometheus.NewRegistry()
+//	reg.MustRegister(metrics.NewCollector(&client))
+//	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/jakibaki/libhac"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bytesDesc = prometheus.NewDesc("libhac_downloaded_bytes_total",
+		"Bytes read from response bodies.", []string{"host"}, nil)
+	requestsDesc = prometheus.NewDesc("libhac_requests_total",
+		"Requests made, including retries.", []string{"host"}, nil)
+	responsesDesc = prometheus.NewDesc("libhac_responses_total",
+		"Responses by status code.", []string{"host", "code"}, nil)
+	errorsDesc = prometheus.NewDesc("libhac_errors_total",
+		"Failed requests, error responses and broken transfers.", []string{"host"}, nil)
+	retriesDesc = prometheus.NewDesc("libhac_retries_total",
+		"Retried requests and resumed transfers.", []string{"host"}, nil)
+	activeDesc = prometheus.NewDesc("libhac_active_transfers",
+		"Response bodies currently being read.", nil, nil)
+)
+
+// Collector reads the client's Stats on every scrape. Copies of the client
+// share their stats, so it also covers e.g. a daemon's copy.
+type Collector struct {
+	client *libhac.HacClient
+}
+
+func NewCollector(c *libhac.HacClient) *Collector {
+	return &Collector{client: c}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bytesDesc
+	ch <- requestsDesc
+	ch <- responsesDesc
+	ch <- errorsDesc
+	ch <- retriesDesc
+	ch <- activeDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.client.Stats()
+
+	for host, v := range s.Hosts {
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(v.Bytes), host)
+		ch <- prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, float64(v.Requests), host)
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(v.Errors), host)
+		ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(v.Retries), host)
+
+		for code, n := range v.StatusCodes {
+			ch <- prometheus.MustNewConstMetric(responsesDesc, prometheus.CounterValue, float64(n),
+				host, strconv.Itoa(code))
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(activeDesc, prometheus.GaugeValue, float64(s.ActiveTransfers))
+}
@@ -0,0 +1,112 @@
+package libhac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// nacpLanguages are the languages of the title entries of a NACP in order,
+// named like the icons of a Control NCA.
+var nacpLanguages = []string{
+	"AmericanEnglish", "BritishEnglish", "Japanese", "French", "German",
+	"LatinAmericanSpanish", "Spanish", "Italian", "Dutch", "CanadianFrench",
+	"Portuguese", "Russian", "Korean", "TraditionalChinese",
+	"SimplifiedChinese", "BrazilianPortuguese",
+}
+
+func isNACPLanguage(lang string) bool {
+	for _, v := range nacpLanguages {
+		if v == lang {
+			return true
+		}
+	}
+
+	return false
+}
+
+const nacpSize = 0x4000
+
+// NACP is the application control property of a Control NCA or an NRO,
+// only the fields needed to name a title are parsed.
+type NACP struct {
+	// Titles are by language, languages without a name are left out.
+	Titles         map[string]NACPTitle
+	DisplayVersion string
+}
+
+type NACPTitle struct {
+	Name      string
+	Publisher string
+}
+
+func ParseNACP(data []byte) (NACP, error) {
+	if len(data) < nacpSize {
+		return NACP{}, errors.New("nacp is too small")
+	}
+
+	n := NACP{
+		Titles:         map[string]NACPTitle{},
+		DisplayVersion: cString(data[0x3060:0x3070]),
+	}
+
+	for i, lang := range nacpLanguages {
+		entry := data[i*0x300 : (i+1)*0x300]
+
+		t := NACPTitle{cString(entry[:0x200]), cString(entry[0x200:])}
+		if t.Name != "" {
+			n.Titles[lang] = t
+		}
+	}
+
+	return n, nil
+}
+
+// Title returns the american english title, or the first one found if there
+// is none.
+func (n NACP) Title() NACPTitle {
+	if t, ok := n.Titles["AmericanEnglish"]; ok {
+		return t
+	}
+
+	for _, lang := range nacpLanguages {
+		if t, ok := n.Titles[lang]; ok {
+			return t
+		}
+	}
+
+	return NACPTitle{}
+}
+
+// ControlNACP reads the control.nacp from a decrypted Control NCA RomFS.
+func ControlNACP(romfs io.ReaderAt) (NACP, error) {
+	files, err := ReadRomFS(romfs)
+	if err != nil {
+		return NACP{}, err
+	}
+
+	for _, v := range files {
+		if v.Path != "/control.nacp" {
+			continue
+		}
+
+		b := make([]byte, v.Size)
+		_, err = romfs.ReadAt(b, v.Offset)
+		if err != nil {
+			return NACP{}, err
+		}
+
+		return ParseNACP(b)
+	}
+
+	return NACP{}, errors.New("romfs has no control.nacp")
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,93 @@
+package libhac
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultNameTemplate gives names like
+// "Title Name [0100ABCD00014000][v131072].nsp".
+const DefaultNameTemplate = "{{.Name}} [{{.ID}}][v{{.Version}}].{{.Ext}}"
+
+// NameFields are available to Namer templates.
+type NameFields struct {
+	// Name and Publisher are empty if neither the NACP nor the titledb
+	// know the title.
+	Name           string
+	Publisher      string
+	ID             string
+	Version        uint32
+	DisplayVersion string
+	Type           string
+	Ext            string
+}
+
+// Namer names output files of a title the way other tools do.
+type Namer struct {
+	// Template is a text/template executed with NameFields, or
+	// DefaultNameTemplate if empty.
+	Template string
+	// TitleDB is optional and used for titles without a NACP, like updates
+	// and add-ons.
+	TitleDB *TitleDB
+}
+
+// Name returns the file name of the title of cnmt with extension ext. nacp
+// is optional. Characters not allowed in file names are dropped.
+func (n Namer) Name(cnmt CNMT, nacp *NACP, ext string) (string, error) {
+	f := NameFields{
+		ID:      strings.ToUpper(cnmt.IDString()),
+		Version: cnmt.Version,
+		Type:    cnmt.Type,
+		Ext:     strings.TrimPrefix(ext, "."),
+	}
+
+	if nacp != nil {
+		t := nacp.Title()
+		f.Name, f.Publisher, f.DisplayVersion = t.Name, t.Publisher, nacp.DisplayVersion
+	}
+
+	if f.Name == "" && n.TitleDB != nil {
+		if t, ok := n.TitleDB.Lookup(cnmt.IDString()); ok {
+			f.Name, f.Publisher = t.Name, t.Publisher
+		}
+	}
+
+	text := n.Template
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+
+	t, err := template.New("name").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+
+	b := &strings.Builder{}
+	err = t.Execute(b, f)
+	if err != nil {
+		return "", err
+	}
+
+	name := cleanFileName(b.String())
+	if name == "" || name == "."+f.Ext {
+		return "", fmt.Errorf("name template gives an empty name for %s", f.ID)
+	}
+
+	return name, nil
+}
+
+// cleanFileName drops characters windows doesn't allow in file names and
+// the spaces left over by empty fields.
+func cleanFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return -1
+		}
+
+		return r
+	}, name)
+
+	return strings.TrimSpace(strings.Join(strings.Fields(name), " "))
+}
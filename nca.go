@@ -0,0 +1,335 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ncaFile struct {
+	r      io.ReaderAt
+	keys   *Keyset
+	raw    []byte
+	header ncaHeader
+}
+
+func openNCA(r io.ReaderAt, keys *Keyset) (*ncaFile, error) {
+	raw, h, err := readNCAHeader(r, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ncaFile{r, keys, raw, h}, nil
+}
+
+// readNCAHeader reads the header of an NCA, decrypting it unless it already
+// is, like the header.bin written by DecryptNCA.
+func readNCAHeader(r io.ReaderAt, keys *Keyset) ([]byte, ncaHeader, error) {
+	h := ncaHeader{}
+
+	raw := make([]byte, 0xC00)
+	_, err := r.ReadAt(raw, 0)
+	if err != nil {
+		return nil, h, err
+	}
+
+	if string(raw[0x200:0x204]) != "NCA3" {
+		if keys == nil {
+			return nil, h, errors.New("nca header is encrypted and no keys were given")
+		}
+
+		hk, err := keys.headerKey()
+		if err != nil {
+			return nil, h, err
+		}
+
+		err = decryptXTS(hk, raw, 0x200, 0)
+		if err != nil {
+			return nil, h, err
+		}
+	}
+
+	err = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, h, err
+	}
+
+	if string(h.Magic[:]) != "NCA3" {
+		return nil, h, errors.New("invalid nca header magic, wrong header key?")
+	}
+
+	return raw, h, nil
+}
+
+func (n *ncaFile) keyGeneration() int {
+	return keyGeneration(n.header.KeyGenerationOld, n.header.KeyGeneration)
+}
+
+// keyGeneration returns the master key generation from both key generation
+// fields of a header.
+func keyGeneration(old, current uint8) int {
+	gen := int(old)
+	if int(current) > gen {
+		gen = int(current)
+	}
+
+	if gen > 0 {
+		gen--
+	}
+
+	return gen
+}
+
+func (n *ncaFile) hasRightsID() bool {
+	return n.header.RightsID != [0x10]byte{}
+}
+
+func (n *ncaFile) ctrKey() ([]byte, error) {
+	if n.hasRightsID() {
+		return n.keys.titleKey(n.header.RightsID[:], n.keyGeneration())
+	}
+
+	keyArea := []byte{}
+	for _, v := range n.header.KeyArea {
+		keyArea = append(keyArea, v[:]...)
+	}
+
+	keyArea, err := n.keys.DecryptKeyArea(keyArea, int(n.header.KeyAreaKeyIndex), n.keyGeneration())
+	if err != nil {
+		return nil, err
+	}
+
+	return keyArea[0x20:0x30], nil
+}
+
+func (n *ncaFile) sectionExists(i int) bool {
+	return n.header.Sections[i].EndOffset > n.header.Sections[i].StartOffset
+}
+
+// sectionReader returns the decrypted contents of section i, relative to the
+// start of the section.
+func (n *ncaFile) sectionReader(i int) (*io.SectionReader, error) {
+	if i < 0 || i > 3 || !n.sectionExists(i) {
+		return nil, fmt.Errorf("nca section %d does not exist", i)
+	}
+
+	s := n.header.Sections[i]
+	fs := n.header.FsHeaders[i]
+	start := int64(s.StartOffset) * 0x200
+	size := int64(s.EndOffset-s.StartOffset) * 0x200
+
+	if fs.sparse() || fs.compressed() {
+		return nil, &StorageError{i, fs.sparse(), fs.compressed()}
+	}
+
+	switch fs.EncryptionType {
+	case 1:
+		return io.NewSectionReader(n.r, start, size), nil
+	case 3:
+		key, err := n.ctrKey()
+		if err != nil {
+			return nil, err
+		}
+
+		ctr, err := newCTRReaderAt(n.r, key, fs.UpperCounter)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NewSectionReader(ctr, start, size), nil
+	}
+
+	return nil, fmt.Errorf("unsupported encryption type %d in nca section %d", fs.EncryptionType, i)
+}
+
+// dataReader returns the section's payload (the PFS0 or RomFS image) with the
+// hash tables stripped.
+func (n *ncaFile) dataReader(i int) (*io.SectionReader, error) {
+	sr, err := n.sectionReader(i)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := n.header.FsHeaders[i]
+	switch fs.FsType {
+	case 0:
+		ivfc := ivfcSuperblock{}
+		err = binary.Read(bytes.NewReader(fs.HashInfo[:]), binary.LittleEndian, &ivfc)
+		if err != nil {
+			return nil, err
+		}
+
+		if string(ivfc.Magic[:]) != "IVFC" {
+			return nil, errors.New("invalid ivfc magic")
+		}
+
+		l := ivfc.Levels[5]
+
+		return io.NewSectionReader(sr, int64(l.Offset), int64(l.Size)), nil
+	case 1:
+		sb := pfs0Superblock{}
+		err = binary.Read(bytes.NewReader(fs.HashInfo[:]), binary.LittleEndian, &sb)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NewSectionReader(sr, int64(sb.PFS0Offset), int64(sb.PFS0Size)), nil
+	}
+
+	return nil, fmt.Errorf("unknown fs type %d in nca section %d", fs.FsType, i)
+}
+
+func extractPFS0(r io.ReaderAt, out string) error {
+	entries, err := readPFS0(r)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range entries {
+		if v.Name == "" || v.Name == ".." || strings.ContainsAny(v.Name, `/\`) {
+			return fmt.Errorf("refusing to extract unsafe name %q", v.Name)
+		}
+
+		err = writeFile(filepath.Join(out, v.Name), io.NewSectionReader(r, v.Offset, v.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DecryptNCANative(path, out string, keys *Keyset) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(out+"/header.bin", bytes.NewReader(nca.raw))
+	if err != nil {
+		return err
+	}
+
+	romfs := false
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) {
+			continue
+		}
+
+		data, err := nca.dataReader(i)
+		if err != nil {
+			return err
+		}
+
+		// like hactool's --romfsdir, only the first romfs is extracted
+		if nca.header.FsHeaders[i].FsType == 0 && !romfs {
+			err = ExtractRomFS(data, out+"/romfs")
+			if err != nil {
+				return err
+			}
+			romfs = true
+		}
+
+		if nca.header.FsHeaders[i].FsType == 1 {
+			err = extractPFS0(data, fmt.Sprintf("%s/section%d", out, i))
+			if err != nil {
+				return err
+			}
+
+			if nca.header.ContentType == 0 && i == 0 {
+				err = extractPFS0(data, out+"/exefs")
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		err = writeFile(fmt.Sprintf("%s/section%d.bin", out, i), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadCNMTFromNCA decrypts a .cnmt.nca natively and parses the meta inside.
+func ReadCNMTFromNCA(path string, keys *Keyset) (CNMT, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return CNMT{}, err
+	}
+	defer f.Close()
+
+	return readCNMTFromNCA(f, keys, path)
+}
+
+// readCNMTFromNCA parses the packaged content meta inside a decrypted meta NCA.
+func readCNMTFromNCA(r io.ReaderAt, keys *Keyset, path string) (CNMT, error) {
+	nca, err := openNCA(r, keys)
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	data, err := nca.dataReader(0)
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	entries, err := readPFS0(data)
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	for _, v := range entries {
+		if strings.HasSuffix(v.Name, ".cnmt") {
+			b := make([]byte, v.Size)
+			_, err = data.ReadAt(b, v.Offset)
+			if err != nil {
+				return CNMT{}, err
+			}
+
+			return parseCNMT(b, path, nca.header.KeyGeneration)
+		}
+	}
+
+	return CNMT{}, errors.New("no cnmt found in meta nca")
+}
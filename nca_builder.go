@@ -0,0 +1,411 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ncaMediaUnit      = 0x200
+	ncaHeaderSize     = 0xC00
+	ivfcBlockSizeLog2 = 14
+)
+
+// NCABuilder builds an NCA from the PFS0 and RomFS images of its sections,
+// computing their hash tables and encrypting everything with standard crypto.
+type NCABuilder struct {
+	// ContentType is 0 for Program, 1 Meta, 2 Control, 3 Manual, 4 Data and
+	// 5 PublicData NCAs.
+	ContentType       uint8
+	DistributionType  uint8
+	MasterKeyRevision int
+	KeyAreaKeyIndex   uint8
+	ProgramID         uint64
+	ContentIndex      uint32
+	SDKAddonVersion   uint32
+	Keys              *Keyset
+	// Key is the AES-CTR key of the sections, a random one is used if nil.
+	Key []byte
+	// SignatureKey signs the header in place of Nintendo's fixed key, the
+	// signature is left empty if nil.
+	SignatureKey *rsa.PrivateKey
+
+	sections []*ncaBuilderSection
+}
+
+type ncaBuilderSection struct {
+	fsType uint8
+	data   io.ReaderAt
+	size   int64
+
+	// hashes is written before the data, which starts at dataOffset
+	hashes     []byte
+	dataOffset int64
+	fsHeader   ncaFsHeader
+}
+
+func NewNCABuilder(contentType uint8, masterKeyRevision int, keys *Keyset) *NCABuilder {
+	return &NCABuilder{
+		ContentType:       contentType,
+		MasterKeyRevision: masterKeyRevision,
+		SDKAddonVersion:   0x000C1100,
+		Keys:              keys,
+	}
+}
+
+// AddPFS0 adds a section holding a PFS0 image, like an ExeFS or the
+// packaged meta of a Meta NCA.
+func (b *NCABuilder) AddPFS0(r io.ReaderAt, size int64) {
+	b.sections = append(b.sections, &ncaBuilderSection{fsType: 1, data: r, size: size})
+}
+
+// AddRomFS adds a section holding a RomFS image.
+func (b *NCABuilder) AddRomFS(r io.ReaderAt, size int64) {
+	b.sections = append(b.sections, &ncaBuilderSection{fsType: 0, data: r, size: size})
+}
+
+// WriteTo writes the NCA to w. The sections are read twice, once for their
+// hashes and once to encrypt them.
+func (b *NCABuilder) WriteTo(w io.Writer) (int64, error) {
+	if len(b.sections) == 0 || len(b.sections) > 4 {
+		return 0, errors.New("an nca needs one to four sections")
+	}
+
+	key := b.Key
+	if key == nil {
+		key = make([]byte, 0x10)
+		_, err := rand.Read(key)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(key) != 0x10 {
+		return 0, errors.New("section key must be 0x10 bytes")
+	}
+
+	h := ncaHeader{
+		DistributionType: b.DistributionType,
+		ContentType:      b.ContentType,
+		KeyAreaKeyIndex:  b.KeyAreaKeyIndex,
+		ProgramID:        b.ProgramID,
+		ContentIndex:     b.ContentIndex,
+		SDKAddonVersion:  b.SDKAddonVersion,
+	}
+	copy(h.Magic[:], "NCA3")
+	h.KeyGenerationOld, h.KeyGeneration = keyGenerationFields(b.MasterKeyRevision)
+
+	keyArea := make([]byte, 0x40)
+	copy(keyArea[0x20:], key)
+
+	kak, err := b.Keys.KeyAreaKey(int(b.KeyAreaKeyIndex), b.MasterKeyRevision)
+	if err != nil {
+		return 0, err
+	}
+
+	keyArea, err = encryptECB(kak, keyArea)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range h.KeyArea {
+		copy(h.KeyArea[i][:], keyArea[i*0x10:])
+	}
+
+	off := int64(ncaHeaderSize)
+	for i, s := range b.sections {
+		err = s.prepare(b.ContentType)
+		if err != nil {
+			return 0, err
+		}
+
+		s.fsHeader.EncryptionType = 3
+		s.fsHeader.UpperCounter = uint64(i) << 32
+
+		size := alignUp(s.dataOffset+s.size, ncaMediaUnit)
+		h.Sections[i] = ncaSectionEntry{StartOffset: uint32(off / ncaMediaUnit), EndOffset: uint32((off + size) / ncaMediaUnit)}
+		h.FsHeaders[i] = s.fsHeader
+		off += size
+	}
+	h.ContentSize = uint64(off)
+
+	header, err := b.header(h)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return written, err
+	}
+
+	for i, s := range b.sections {
+		cw := &ctrWriter{w, block, s.fsHeader.UpperCounter, int64(h.Sections[i].StartOffset) * ncaMediaUnit}
+		n, err := s.writeTo(cw)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// header hashes the fs headers, signs and encrypts the header.
+func (b *NCABuilder) header(h ncaHeader) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+	raw := buf.Bytes()
+
+	for i := range b.sections {
+		sum := sha256.Sum256(raw[0x400+i*0x200 : 0x600+i*0x200])
+		copy(raw[0x280+i*0x20:], sum[:])
+	}
+
+	if b.SignatureKey != nil {
+		sum := sha256.Sum256(raw[0x200:0x400])
+		sig, err := rsa.SignPSS(rand.Reader, b.SignatureKey, crypto.SHA256, sum[:],
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		if err != nil {
+			return nil, err
+		}
+
+		copy(raw[:0x100], sig)
+	}
+
+	hk, err := b.Keys.headerKey()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewXTSCipher(hk, 0x200)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw, c.Encrypt(raw, 0)
+}
+
+// WriteNCA writes the NCA into dir named by its content id, the first half of
+// its hash, and returns its path and hash.
+func (b *NCABuilder) WriteNCA(dir string) (string, []byte, error) {
+	f, err := ioutil.TempFile(dir, "nca"+partSuffix)
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	_, err = b.WriteTo(io.MultiWriter(f, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := h.Sum(nil)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:0x10])+".nca")
+
+	return path, sum, os.Rename(f.Name(), path)
+}
+
+// prepare computes the hash data of the section and its fs header.
+func (s *ncaBuilderSection) prepare(contentType uint8) error {
+	s.fsHeader = ncaFsHeader{Version: 2, FsType: s.fsType}
+
+	if s.fsType == 1 {
+		blockSize := int64(0x10000)
+		if contentType == 1 {
+			blockSize = 0x1000
+		}
+
+		table, err := hashBlocks(io.NewSectionReader(s.data, 0, s.size), blockSize, false)
+		if err != nil {
+			return err
+		}
+
+		s.dataOffset = alignUp(int64(len(table)), ncaMediaUnit)
+		s.hashes = make([]byte, s.dataOffset)
+		copy(s.hashes, table)
+
+		sb := pfs0Superblock{
+			MasterHash:    sha256.Sum256(table),
+			BlockSize:     uint32(blockSize),
+			LayerCount:    2,
+			HashTableSize: uint64(len(table)),
+			PFS0Offset:    uint64(s.dataOffset),
+			PFS0Size:      uint64(s.size),
+		}
+
+		s.fsHeader.HashType = 2
+
+		return putHashInfo(&s.fsHeader, &sb)
+	}
+
+	// each level hashes the one after it, the last is the romfs itself
+	const blockSize = 1 << ivfcBlockSizeLog2
+	levels := make([][]byte, 5)
+
+	var err error
+	var next io.ReaderAt = s.data
+	nextSize := s.size
+	for i := len(levels) - 1; i >= 0; i-- {
+		levels[i], err = hashBlocks(io.NewSectionReader(next, 0, nextSize), blockSize, true)
+		if err != nil {
+			return err
+		}
+
+		next, nextSize = bytes.NewReader(levels[i]), int64(len(levels[i]))
+	}
+
+	ivfc := ivfcSuperblock{Version: 0x20000, MasterHashSize: sha256.Size, LevelCount: 7}
+	copy(ivfc.Magic[:], "IVFC")
+
+	master, err := hashBlocks(bytes.NewReader(levels[0]), blockSize, true)
+	if err != nil {
+		return err
+	}
+	copy(ivfc.MasterHash[:], master)
+
+	hashes := &bytes.Buffer{}
+	for i, v := range levels {
+		ivfc.Levels[i] = ivfcLevel{Offset: uint64(hashes.Len()), Size: uint64(len(v)), BlockSizeLog2: ivfcBlockSizeLog2}
+
+		hashes.Write(v)
+		hashes.Write(make([]byte, alignUp(int64(len(v)), blockSize)-int64(len(v))))
+	}
+
+	s.hashes = hashes.Bytes()
+	s.dataOffset = int64(len(s.hashes))
+	ivfc.Levels[5] = ivfcLevel{Offset: uint64(s.dataOffset), Size: uint64(s.size), BlockSizeLog2: ivfcBlockSizeLog2}
+
+	s.fsHeader.HashType = 3
+
+	return putHashInfo(&s.fsHeader, &ivfc)
+}
+
+// writeTo writes the hash data, the data and padding to the next media unit.
+func (s *ncaBuilderSection) writeTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.hashes)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	c, err := io.Copy(w, io.NewSectionReader(s.data, 0, s.size))
+	written += c
+	if err != nil {
+		return written, err
+	}
+
+	pad := alignUp(written, ncaMediaUnit) - written
+	n, err = w.Write(make([]byte, pad))
+
+	return written + int64(n), err
+}
+
+func putHashInfo(fs *ncaFsHeader, v interface{}) error {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, v)
+	if err != nil {
+		return err
+	}
+
+	copy(fs.HashInfo[:], buf.Bytes())
+
+	return nil
+}
+
+// hashBlocks returns the sha256 hashes of every block of r, padding the last
+// block with zeros if pad is set like IVFC does.
+func hashBlocks(r io.Reader, blockSize int64, pad bool) ([]byte, error) {
+	out := []byte{}
+	buf := make([]byte, blockSize)
+
+	h := sha256.New()
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n == 0 {
+			if err == io.EOF {
+				return out, nil
+			}
+			return nil, err
+		}
+
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		block := buf[:n]
+		if pad {
+			for i := n; i < len(buf); i++ {
+				buf[i] = 0
+			}
+			block = buf
+		}
+
+		h.Reset()
+		h.Write(block)
+		out = h.Sum(out)
+
+		if err == io.ErrUnexpectedEOF {
+			return out, nil
+		}
+	}
+}
+
+// keyGenerationFields returns both key generation fields of a header for a
+// master key revision.
+func keyGenerationFields(rev int) (uint8, uint8) {
+	gen := uint8(rev + 1)
+	if gen <= 2 {
+		return gen, 0
+	}
+
+	return 2, gen
+}
+
+func alignUp(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
+
+// ctrWriter encrypts with AES-CTR where the lower half of the counter is the
+// absolute offset in the file, the inverse of ctrReaderAt.
+type ctrWriter struct {
+	w     io.Writer
+	block cipher.Block
+	upper uint64
+	off   int64
+}
+
+func (c *ctrWriter) Write(p []byte) (int, error) {
+	buf := append([]byte(nil), p...)
+	xorCTR(c.block, c.upper, c.off, buf)
+
+	n, err := c.w.Write(buf)
+	c.off += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,113 @@
+package libhac_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/jakibaki/libhac"
+)
+
+// writeTestNCA builds an NCA with a PFS0 and a RomFS section into dir and
+// returns its path and the RomFS.
+func writeTestNCA(t *testing.T, keys *libhac.Keyset, dir string) (string, []byte) {
+	pfs := &bytes.Buffer{}
+	w := libhac.NewNSPWriter(pfs)
+	w.Add("main.npdm", 5, bytes.NewReader([]byte("hello")))
+	w.Close()
+
+	// a few ivfc blocks, the last one short
+	romfs := make([]byte, 3*0x4000+0x123)
+	rand.New(rand.NewSource(3)).Read(romfs)
+
+	b := libhac.NewNCABuilder(0, 0, keys)
+	b.ProgramID = 0x0100000000010000
+	b.AddPFS0(bytes.NewReader(pfs.Bytes()), int64(pfs.Len()))
+	b.AddRomFS(bytes.NewReader(romfs), int64(len(romfs)))
+
+	path, _, err := b.WriteNCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, romfs
+}
+
+func TestNCABuilderVerify(t *testing.T) {
+	keys := newBuildTestKeys()
+	path, romfs := writeTestNCA(t, keys, t.TempDir())
+
+	err := libhac.VerifyNCA(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nca, err := libhac.OpenNCA(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nca.Close()
+
+	data, err := nca.Data(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfs, err := libhac.NewNSPReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pfs.Entries) != 1 || pfs.Entries[0].Name != "main.npdm" {
+		t.Errorf("got pfs0 entries %v", pfs.Entries)
+	}
+
+	r, err := nca.RomFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, r.Size())
+	_, err = r.ReadAt(got, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, romfs) {
+		t.Error("romfs read back differs")
+	}
+}
+
+func TestNCABuilderVerifyCorrupt(t *testing.T) {
+	keys := newBuildTestKeys()
+	path, _ := writeTestNCA(t, keys, t.TempDir())
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a byte in the middle of the romfs data, at the end of the file
+	data[len(data)-0x2000] ^= 1
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = libhac.VerifyNCA(path, keys)
+	if !errors.Is(err, libhac.ErrHashMismatch) {
+		t.Fatalf("got %v, want a hash mismatch", err)
+	}
+}
+
+func TestNCABuilderWrongKey(t *testing.T) {
+	keys := newBuildTestKeys()
+	path, _ := writeTestNCA(t, keys, t.TempDir())
+
+	other := newBuildTestKeys()
+	other.KeyAreaKeyApplication[0] = bytes.Repeat([]byte{3}, 0x10)
+
+	if err := libhac.VerifyNCA(path, other); err == nil {
+		t.Fatal("verifying with the wrong key area key didn't fail")
+	}
+}
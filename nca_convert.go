@@ -0,0 +1,77 @@
+package libhac
+
+import (
+	"errors"
+	"os"
+)
+
+// ConvertToStandardCrypto rewrites the header of the rights id NCA at path
+// in place so it doesn't need a ticket: titleKey, encrypted as in its
+// ticket, is decrypted and stored in the key area instead, and the rights id
+// is cleared. The section data stays the same since it's encrypted with the
+// titlekey either way.
+//
+// The converted NCA no longer matches its fixed key signature or the hash in
+// its meta.
+func ConvertToStandardCrypto(path string, titleKey []byte, keys *Keyset) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	if !nca.hasRightsID() {
+		return errors.New("nca already uses standard crypto")
+	}
+
+	gen := nca.keyGeneration()
+	key, err := keys.DecryptTitleKey(titleKey, gen)
+	if err != nil {
+		return err
+	}
+
+	keyArea := make([]byte, 0x40)
+	copy(keyArea[0x20:], key)
+
+	kak, err := keys.KeyAreaKey(int(nca.header.KeyAreaKeyIndex), gen)
+	if err != nil {
+		return err
+	}
+
+	keyArea, err = encryptECB(kak, keyArea)
+	if err != nil {
+		return err
+	}
+
+	// patch the raw header, reserved fields might not be zero
+	raw := append([]byte(nil), nca.raw...)
+	copy(raw[0x300:0x340], keyArea)
+	copy(raw[0x230:0x240], make([]byte, 0x10))
+
+	hk, err := keys.headerKey()
+	if err != nil {
+		return err
+	}
+
+	c, err := NewXTSCipher(hk, 0x200)
+	if err != nil {
+		return err
+	}
+
+	err = c.Encrypt(raw, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt(raw, 0)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,125 @@
+package libhac
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// NCAHeader is the parsed header of an NCA.
+type NCAHeader struct {
+	Magic            string
+	DistributionType uint8
+	// ContentType is 0 for Program, 1 Meta, 2 Control, 3 Manual, 4 Data and
+	// 5 PublicData NCAs.
+	ContentType      uint8
+	KeyGenerationOld uint8
+	KeyAreaKeyIndex  uint8
+	ContentSize      uint64
+	ProgramID        uint64
+	ContentIndex     uint32
+	SDKAddonVersion  uint32
+	KeyGeneration    uint8
+	// KeyArea is still encrypted, see Keyset.DecryptKeyArea.
+	KeyArea []byte
+	// RightsID is empty for NCAs using the key area instead of a titlekey.
+	RightsID string
+	Sections []NCASection
+}
+
+// NCASection is an entry of the section table together with its fs header.
+// Offset and Size are in bytes.
+type NCASection struct {
+	Index          int
+	Offset         int64
+	Size           int64
+	Version        uint16
+	FsType         uint8
+	HashType       uint8
+	EncryptionType uint8
+	UpperCounter   uint64
+	FsHeaderHash   []byte
+	// Sparse and Compressed sections have extra storage layers that can't
+	// be read yet, reading them fails with a StorageError.
+	Sparse     bool
+	Compressed bool
+}
+
+// MasterKeyRevision returns the master key generation used for the key area
+// or titlekey, the larger of both key generation fields minus one.
+func (h NCAHeader) MasterKeyRevision() int {
+	return keyGeneration(h.KeyGenerationOld, h.KeyGeneration)
+}
+
+func (h NCAHeader) HasRightsID() bool {
+	return h.RightsID != ""
+}
+
+// ParseNCAHeader reads the header of the NCA at path. Encrypted headers need
+// keys with a header_key, decrypted ones like a header.bin from DecryptNCA
+// don't and keys may be nil.
+func ParseNCAHeader(path string, keys *Keyset) (NCAHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return NCAHeader{}, err
+	}
+	defer f.Close()
+
+	return parseNCAHeader(f, keys)
+}
+
+func parseNCAHeader(r io.ReaderAt, keys *Keyset) (NCAHeader, error) {
+	_, h, err := readNCAHeader(r, keys)
+	if err != nil {
+		return NCAHeader{}, err
+	}
+
+	return newNCAHeader(h), nil
+}
+
+func newNCAHeader(h ncaHeader) NCAHeader {
+	nh := NCAHeader{
+		Magic:            string(h.Magic[:]),
+		DistributionType: h.DistributionType,
+		ContentType:      h.ContentType,
+		KeyGenerationOld: h.KeyGenerationOld,
+		KeyAreaKeyIndex:  h.KeyAreaKeyIndex,
+		ContentSize:      h.ContentSize,
+		ProgramID:        h.ProgramID,
+		ContentIndex:     h.ContentIndex,
+		SDKAddonVersion:  h.SDKAddonVersion,
+		KeyGeneration:    h.KeyGeneration,
+		KeyArea:          make([]byte, 0, 0x40),
+	}
+
+	for _, v := range h.KeyArea {
+		nh.KeyArea = append(nh.KeyArea, v[:]...)
+	}
+
+	if h.RightsID != [0x10]byte{} {
+		nh.RightsID = fmt.Sprintf("%x", h.RightsID)
+	}
+
+	for i, s := range h.Sections {
+		if s.EndOffset <= s.StartOffset {
+			continue
+		}
+
+		fs := h.FsHeaders[i]
+		nh.Sections = append(nh.Sections, NCASection{
+			Index:          i,
+			Offset:         int64(s.StartOffset) * 0x200,
+			Size:           int64(s.EndOffset-s.StartOffset) * 0x200,
+			Version:        fs.Version,
+			FsType:         fs.FsType,
+			HashType:       fs.HashType,
+			EncryptionType: fs.EncryptionType,
+			UpperCounter:   fs.UpperCounter,
+			FsHeaderHash:   append([]byte(nil), h.FsHeaderHashes[i][:]...),
+			Sparse:         fs.sparse(),
+			Compressed:     fs.compressed(),
+		})
+	}
+
+	return nh
+}
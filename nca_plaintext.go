@@ -0,0 +1,101 @@
+package libhac
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// WritePlaintextNCA writes a fully decrypted copy of the NCA at path to out,
+// like hactool's --plaintext. The header is written decrypted and every
+// section is marked as unencrypted, so the copy can be read with or without
+// keys and diffed against other versions directly.
+func WritePlaintextNCA(path, out string, keys *Keyset) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	raw := append([]byte(nil), nca.raw...)
+	sections := []int{}
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) {
+			continue
+		}
+
+		if nca.header.FsHeaders[i].EncryptionType == 4 {
+			return fmt.Errorf("nca section %d is a bktr patch, it can't be decrypted on its own", i)
+		}
+
+		// the encryption type is at 4 in the fs header
+		fs := raw[0x400+i*0x200 : 0x600+i*0x200]
+		fs[4] = 1
+
+		sum := sha256.Sum256(fs)
+		copy(raw[0x280+i*0x20:], sum[:])
+
+		sections = append(sections, i)
+	}
+
+	sort.Slice(sections, func(a, b int) bool {
+		return nca.header.Sections[sections[a]].StartOffset < nca.header.Sections[sections[b]].StartOffset
+	})
+
+	o, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+
+	_, err = o.Write(raw)
+	if err != nil {
+		return err
+	}
+
+	// anything outside of the sections is copied as is
+	off := int64(len(raw))
+	for _, i := range sections {
+		start := int64(nca.header.Sections[i].StartOffset) * 0x200
+		if start < off {
+			return errors.New("nca sections overlap")
+		}
+
+		_, err = io.Copy(o, io.NewSectionReader(f, off, start-off))
+		if err != nil {
+			return err
+		}
+
+		sr, err := nca.sectionReader(i)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(o, sr)
+		if err != nil {
+			return err
+		}
+
+		off = start + sr.Size()
+	}
+
+	_, err = io.Copy(o, io.NewSectionReader(f, off, fi.Size()-off))
+	if err != nil {
+		return err
+	}
+
+	return o.Close()
+}
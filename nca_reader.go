@@ -0,0 +1,95 @@
+package libhac
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NCAReader reads the sections of an NCA, decrypting them on the fly so
+// nothing has to be extracted to disk first.
+type NCAReader struct {
+	Header NCAHeader
+
+	nca *ncaFile
+	c   io.Closer
+}
+
+func NewNCAReader(r io.ReaderAt, keys *Keyset) (*NCAReader, error) {
+	nca, err := openNCA(r, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NCAReader{Header: newNCAHeader(nca.header), nca: nca}, nil
+}
+
+func OpenNCA(path string, keys *Keyset) (*NCAReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := NewNCAReader(f, keys)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	n.c = f
+
+	return n, nil
+}
+
+func (n *NCAReader) Close() error {
+	if n.c == nil {
+		return nil
+	}
+
+	return n.c.Close()
+}
+
+// Section returns the decrypted section i, hash tables included.
+func (n *NCAReader) Section(i int) (*io.SectionReader, error) {
+	return n.nca.sectionReader(i)
+}
+
+// Data returns the PFS0 or RomFS image of section i.
+func (n *NCAReader) Data(i int) (*io.SectionReader, error) {
+	return n.nca.dataReader(i)
+}
+
+// RomFS returns the image of the first RomFS section.
+func (n *NCAReader) RomFS() (*io.SectionReader, error) {
+	for i := 0; i < 4; i++ {
+		if n.nca.sectionExists(i) && n.nca.header.FsHeaders[i].FsType == 0 {
+			return n.nca.dataReader(i)
+		}
+	}
+
+	return nil, errors.New("nca has no romfs section")
+}
+
+// OpenRomFSFile returns a single file of the RomFS, name is its path with or
+// without the leading slash.
+func (n *NCAReader) OpenRomFSFile(name string) (*io.SectionReader, error) {
+	romfs, err := n.RomFS()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ReadRomFS(romfs)
+	if err != nil {
+		return nil, err
+	}
+
+	name = "/" + strings.TrimPrefix(name, "/")
+	for _, v := range files {
+		if v.Path == name {
+			return io.NewSectionReader(romfs, v.Offset, v.Size), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found in romfs", name)
+}
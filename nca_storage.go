@@ -0,0 +1,73 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Newer NCAs can have two more storage layers in a section. Sparse storage
+// leaves out ranges of the section that are all zeros (used for the
+// program NCAs of some updates), compressed storage stores ranges LZ4
+// compressed. Both are described by a bucket tree like BKTR in the fs
+// header. Sections using them are detected so they fail explicitly instead
+// of being read as garbage.
+
+type ncaSparseInfo struct {
+	TableOffset    uint64
+	TableSize      uint64
+	TableHeader    [0x10]byte
+	PhysicalOffset uint64
+	Generation     uint16
+	_              [6]byte
+}
+
+type ncaCompressionInfo struct {
+	TableOffset uint64
+	TableSize   uint64
+	TableHeader [0x10]byte
+	_           [8]byte
+}
+
+func (fs ncaFsHeader) sparse() bool {
+	si := ncaSparseInfo{}
+	if binary.Read(bytes.NewReader(fs.SparseInfo[:]), binary.LittleEndian, &si) != nil {
+		return false
+	}
+
+	return si.Generation != 0
+}
+
+func (fs ncaFsHeader) compressed() bool {
+	ci := ncaCompressionInfo{}
+	if binary.Read(bytes.NewReader(fs.CompressionInfo[:]), binary.LittleEndian, &ci) != nil {
+		return false
+	}
+
+	return ci.TableOffset != 0 && ci.TableSize != 0
+}
+
+// StorageError is returned when reading an NCA section that uses sparse or
+// compressed storage. It matches ErrUnsupportedStorage.
+type StorageError struct {
+	Section    int
+	Sparse     bool
+	Compressed bool
+}
+
+func (e *StorageError) Error() string {
+	layers := []string{}
+	if e.Sparse {
+		layers = append(layers, "sparse")
+	}
+	if e.Compressed {
+		layers = append(layers, "compressed")
+	}
+
+	return fmt.Sprintf("nca section %d uses %s storage, which isn't supported", e.Section, strings.Join(layers, " and "))
+}
+
+func (e *StorageError) Is(target error) bool {
+	return target == ErrUnsupportedStorage
+}
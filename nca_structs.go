@@ -0,0 +1,72 @@
+package libhac
+
+type ncaHeader struct {
+	FixedKeySignature      [0x100]byte
+	NPDMSignature          [0x100]byte
+	Magic                  [4]byte
+	DistributionType       uint8
+	ContentType            uint8
+	KeyGenerationOld       uint8
+	KeyAreaKeyIndex        uint8
+	ContentSize            uint64
+	ProgramID              uint64
+	ContentIndex           uint32
+	SDKAddonVersion        uint32
+	KeyGeneration          uint8
+	SignatureKeyGeneration uint8
+	_                      [0xE]byte
+	RightsID               [0x10]byte
+	Sections               [4]ncaSectionEntry
+	FsHeaderHashes         [4][0x20]byte
+	KeyArea                [4][0x10]byte
+	_                      [0xC0]byte
+	FsHeaders              [4]ncaFsHeader
+}
+
+type ncaSectionEntry struct {
+	StartOffset uint32
+	EndOffset   uint32
+	_           [8]byte
+}
+
+type ncaFsHeader struct {
+	Version              uint16
+	FsType               uint8
+	HashType             uint8
+	EncryptionType       uint8
+	_                    [3]byte
+	HashInfo             [0xF8]byte
+	PatchInfo            [0x40]byte
+	UpperCounter         uint64
+	SparseInfo           [0x30]byte
+	CompressionInfo      [0x28]byte
+	MetaDataHashDataInfo [0x30]byte
+	_                    [0x30]byte
+}
+
+type pfs0Superblock struct {
+	MasterHash      [0x20]byte
+	BlockSize       uint32
+	LayerCount      uint32
+	HashTableOffset uint64
+	HashTableSize   uint64
+	PFS0Offset      uint64
+	PFS0Size        uint64
+}
+
+type ivfcLevel struct {
+	Offset        uint64
+	Size          uint64
+	BlockSizeLog2 uint32
+	_             uint32
+}
+
+type ivfcSuperblock struct {
+	Magic          [4]byte
+	Version        uint32
+	MasterHashSize uint32
+	LevelCount     uint32
+	Levels         [6]ivfcLevel
+	_              [0x20]byte
+	MasterHash     [0x20]byte
+}
@@ -0,0 +1,90 @@
+package libhac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// readSection reads size bytes at off. Sizes usually come from headers that
+// can't be trusted, so the buffer grows as data is read instead of being
+// allocated up front, a bogus size fails once the data runs out.
+func readSection(r io.ReaderAt, off, size int64) ([]byte, error) {
+	if off < 0 || size < 0 {
+		return nil, errors.New("invalid offset or size")
+	}
+
+	b, err := ioutil.ReadAll(io.NewSectionReader(r, off, size))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(b)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return b, nil
+}
+
+func xorBlock(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// ctrReaderAt decrypts AES-CTR data where the lower half of the counter is
+// the absolute offset of the block within the underlying file.
+type ctrReaderAt struct {
+	r     io.ReaderAt
+	block cipher.Block
+	upper uint64
+}
+
+func newCTRReaderAt(r io.ReaderAt, key []byte, upper uint64) (*ctrReaderAt, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ctrReaderAt{r, block, upper}, nil
+}
+
+func (c *ctrReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	start := off &^ (aes.BlockSize - 1)
+	skip := int(off - start)
+
+	buf := make([]byte, skip+len(p))
+	n, err := c.r.ReadAt(buf, start)
+	if n <= skip {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	xorCTR(c.block, c.upper, start, buf[:n])
+
+	return copy(p, buf[skip:n]), err
+}
+
+// xorCTR applies the keystream for data starting at the absolute offset off,
+// which doesn't need to be block aligned.
+func xorCTR(block cipher.Block, upper uint64, off int64, data []byte) {
+	start := off &^ (aes.BlockSize - 1)
+	skip := int(off - start)
+
+	var iv [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(iv[:8], upper)
+	binary.BigEndian.PutUint64(iv[8:], uint64(start)>>4)
+	ctr := cipher.NewCTR(block, iv[:])
+
+	if skip > 0 {
+		var pad [aes.BlockSize]byte
+		ctr.XORKeyStream(pad[:skip], pad[:skip])
+	}
+
+	ctr.XORKeyStream(data, data)
+}
@@ -0,0 +1,440 @@
+package libhac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The first 0x4000 bytes of an NCZ are the untouched start of the NCA, the
+// rest is the decrypted NCA body compressed with zstandard. The section table
+// holds the keys needed to encrypt it again on decompression.
+const nczHeaderSize = 0x4000
+
+type nczSection struct {
+	Offset        uint64
+	Size          uint64
+	CryptoType    uint64
+	_             uint64
+	CryptoKey     [0x10]byte
+	CryptoCounter [0x10]byte
+}
+
+type nczBlockHeader struct {
+	Version           uint8
+	Type              uint8
+	_                 uint8
+	BlockSizeExponent uint8
+	BlockCount        uint32
+	DecompressedSize  uint64
+}
+
+func CompressNCA(r io.ReaderAt, size int64, w io.Writer, keys *Keyset, level int) error {
+	nca, err := openNCA(r, keys)
+	if err != nil {
+		return err
+	}
+
+	sections := []nczSection{}
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) {
+			continue
+		}
+
+		fs := nca.header.FsHeaders[i]
+		s := nczSection{
+			Offset:     uint64(nca.header.Sections[i].StartOffset) * 0x200,
+			Size:       uint64(nca.header.Sections[i].EndOffset-nca.header.Sections[i].StartOffset) * 0x200,
+			CryptoType: uint64(fs.EncryptionType),
+		}
+
+		switch fs.EncryptionType {
+		case 1:
+		case 3:
+			key, err := nca.ctrKey()
+			if err != nil {
+				return err
+			}
+
+			copy(s.CryptoKey[:], key)
+			binary.BigEndian.PutUint64(s.CryptoCounter[:8], fs.UpperCounter)
+		default:
+			return fmt.Errorf("can't compress nca section %d with encryption type %d", i, fs.EncryptionType)
+		}
+
+		sections = append(sections, s)
+	}
+
+	_, err = io.Copy(w, io.NewSectionReader(r, 0, nczHeaderSize))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write([]byte("NCZSECTN"))
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, uint64(len(sections)))
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, sections)
+	if err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return err
+	}
+
+	ciphers, err := nczCiphers(sections)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1<<20)
+	for off := int64(nczHeaderSize); off < size; {
+		n := int64(len(buf))
+		if size-off < n {
+			n = size - off
+		}
+
+		_, err = r.ReadAt(buf[:n], off)
+		if err != nil {
+			return err
+		}
+
+		applyNCZCrypto(sections, ciphers, off, buf[:n])
+
+		_, err = zw.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		off += n
+	}
+
+	return zw.Close()
+}
+
+func DecompressNCZ(r io.Reader, w io.Writer) error {
+	_, err := io.CopyN(w, r, nczHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	magic := make([]byte, 8)
+	_, err = io.ReadFull(r, magic)
+	if err != nil {
+		return err
+	}
+
+	if string(magic) != "NCZSECTN" {
+		return errors.New("invalid ncz section magic")
+	}
+
+	var count uint64
+	err = binary.Read(r, binary.LittleEndian, &count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0x10 {
+		return fmt.Errorf("ncz has an implausible section count %d", count)
+	}
+
+	sections := make([]nczSection, count)
+	err = binary.Read(r, binary.LittleEndian, sections)
+	if err != nil {
+		return err
+	}
+
+	ciphers, err := nczCiphers(sections)
+	if err != nil {
+		return err
+	}
+
+	ew := &nczEncryptor{w, sections, ciphers, nczHeaderSize}
+
+	var end uint64 = nczHeaderSize
+	for _, v := range sections {
+		if v.Offset+v.Size > end {
+			end = v.Offset + v.Size
+		}
+	}
+
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(8)
+	if err == nil && string(peek) == "NCZBLOCK" {
+		br.Discard(8)
+		return decompressNCZBlocks(br, ew, end-nczHeaderSize)
+	}
+
+	zr, err := zstd.NewReader(br)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	_, err = io.Copy(ew, zr)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decompressNCZBlocks decompresses the block format, size is the length of
+// the nca body the sections cover.
+func decompressNCZBlocks(r io.Reader, w io.Writer, size uint64) error {
+	h := nczBlockHeader{}
+	err := binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return err
+	}
+
+	if h.BlockSizeExponent < 14 || h.BlockSizeExponent > 32 {
+		return fmt.Errorf("invalid ncz block size exponent %d", h.BlockSizeExponent)
+	}
+
+	blockSize := uint64(1) << h.BlockSizeExponent
+	if h.DecompressedSize != size {
+		return fmt.Errorf("ncz blocks decompress to %d bytes, the sections cover %d", h.DecompressedSize, size)
+	}
+
+	if uint64(h.BlockCount) != (h.DecompressedSize+blockSize-1)/blockSize {
+		return fmt.Errorf("ncz has %d blocks for %d bytes", h.BlockCount, h.DecompressedSize)
+	}
+
+	// the table grows as it's read rather than trusting the count
+	table, err := ioutil.ReadAll(io.LimitReader(r, int64(h.BlockCount)*4))
+	if err != nil {
+		return err
+	}
+
+	if len(table) != int(h.BlockCount)*4 {
+		return io.ErrUnexpectedEOF
+	}
+
+	sizes := make([]uint32, h.BlockCount)
+	err = binary.Read(bytes.NewReader(table), binary.LittleEndian, sizes)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	remaining := h.DecompressedSize
+	for _, v := range sizes {
+		expected := blockSize
+		if remaining < expected {
+			expected = remaining
+		}
+
+		// blocks that don't shrink are stored as is, so none is larger
+		if uint64(v) > expected {
+			return errors.New("ncz block is larger than its decompressed size")
+		}
+
+		block := make([]byte, v)
+		_, err = io.ReadFull(r, block)
+		if err != nil {
+			return err
+		}
+
+		// blocks that don't shrink are stored as is
+		if uint64(v) != expected {
+			block, err = zr.DecodeAll(block, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if uint64(len(block)) != expected {
+			return errors.New("ncz block decompressed to the wrong size")
+		}
+
+		_, err = w.Write(block)
+		if err != nil {
+			return err
+		}
+
+		remaining -= expected
+	}
+
+	return nil
+}
+
+type nczEncryptor struct {
+	w        io.Writer
+	sections []nczSection
+	ciphers  []cipher.Block
+	off      int64
+}
+
+func (e *nczEncryptor) Write(p []byte) (int, error) {
+	buf := append([]byte{}, p...)
+	applyNCZCrypto(e.sections, e.ciphers, e.off, buf)
+
+	n, err := e.w.Write(buf)
+	e.off += int64(n)
+
+	return n, err
+}
+
+func nczCiphers(sections []nczSection) ([]cipher.Block, error) {
+	ciphers := make([]cipher.Block, len(sections))
+	for i, v := range sections {
+		switch v.CryptoType {
+		case 3:
+		case 4:
+			// bktr sections use a counter per subsection, a single ctr
+			// stream would produce garbage
+			return nil, errors.New("ncz sections with bktr encryption aren't supported")
+		default:
+			continue
+		}
+
+		block, err := aes.NewCipher(v.CryptoKey[:])
+		if err != nil {
+			return nil, err
+		}
+
+		ciphers[i] = block
+	}
+
+	return ciphers, nil
+}
+
+// applyNCZCrypto encrypts or decrypts the parts of data, which starts at the
+// absolute nca offset off, covered by ctr sections.
+func applyNCZCrypto(sections []nczSection, ciphers []cipher.Block, off int64, data []byte) {
+	end := off + int64(len(data))
+	for i, v := range sections {
+		if ciphers[i] == nil {
+			continue
+		}
+
+		start := int64(v.Offset)
+		if start < off {
+			start = off
+		}
+
+		stop := int64(v.Offset + v.Size)
+		if stop > end {
+			stop = end
+		}
+
+		if start >= stop {
+			continue
+		}
+
+		upper := binary.BigEndian.Uint64(v.CryptoCounter[:8])
+		xorCTR(ciphers[i], upper, start, data[start-off:stop-off])
+	}
+}
+
+// CompressNSP converts an NSP to NSZ, every NCA except the meta is compressed.
+func CompressNSP(in, out string, keys *Keyset, level int) error {
+	return convertNSP(in, out, func(name string, r *io.SectionReader, w io.Writer) (string, error) {
+		if !strings.HasSuffix(name, ".nca") || strings.HasSuffix(name, ".cnmt.nca") {
+			return "", nil
+		}
+
+		return strings.TrimSuffix(name, ".nca") + ".ncz", CompressNCA(r, r.Size(), w, keys, level)
+	})
+}
+
+func DecompressNSZ(in, out string) error {
+	return convertNSP(in, out, func(name string, r *io.SectionReader, w io.Writer) (string, error) {
+		if !strings.HasSuffix(name, ".ncz") {
+			return "", nil
+		}
+
+		return strings.TrimSuffix(name, ".ncz") + ".nca", DecompressNCZ(r, w)
+	})
+}
+
+// convertNSP repacks an NSP, passing each entry through convert. Converted
+// entries are staged in a temp dir since the PFS0 header needs their sizes.
+func convertNSP(in, out string, convert func(name string, r *io.SectionReader, w io.Writer) (string, error)) error {
+	nsp, err := OpenNSP(in)
+	if err != nil {
+		return err
+	}
+	defer nsp.Close()
+
+	tmp, err := ioutil.TempDir("", "libhac")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// converted files stay open until they're packed
+	files := []*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	entries := []NSPEntry{}
+	for i, v := range nsp.Entries {
+		r := io.NewSectionReader(nsp.r, v.Offset, v.Size)
+
+		path := filepath.Join(tmp, fmt.Sprintf("%d", i))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+
+		name, err := convert(v.Name, r, f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", v.Name, err)
+		}
+
+		if name == "" {
+			entries = append(entries, NSPEntry{v.Name, v.Size, r})
+			continue
+		}
+
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, NSPEntry{name, size, io.NewSectionReader(f, 0, size)})
+	}
+
+	o, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+
+	w := NewNSPWriter(o)
+	for _, v := range entries {
+		w.Add(v.Name, v.Size, v.Reader)
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,187 @@
+// Package netinstall serves NSPs over HTTP for network installs with Tinfoil,
+// Goldleaf and other installers compatible with NS-USBloader.
+//
+// The console is told which URLs to fetch by SendURLs, after which it
+// requests the files with Range headers from the Server.
+package netinstall
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ConsolePort is where installers listen for the url list.
+const ConsolePort = 2000
+
+type Server struct {
+	mu    sync.RWMutex
+	files map[string]string
+}
+
+func NewServer() *Server {
+	return &Server{files: map[string]string{}}
+}
+
+// Add makes path available under its base name and returns that name.
+func (s *Server) Add(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	name := filepath.Base(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.files[name]; ok && p != path {
+		return "", fmt.Errorf("a different file named %s is already served", name)
+	}
+	s.files[name] = path
+
+	return name, nil
+}
+
+func (s *Server) Remove(name string) {
+	s.mu.Lock()
+	delete(s.files, name)
+	s.mu.Unlock()
+}
+
+func (s *Server) names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.files))
+	for k := range s.files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// URLs returns the urls of all served files for a server reachable at base,
+// e.g. "http://192.168.1.2:8080".
+func (s *Server) URLs(base string) []string {
+	urls := []string{}
+	for _, v := range s.names() {
+		urls = append(urls, strings.TrimSuffix(base, "/")+"/"+url.PathEscape(v))
+	}
+
+	return urls
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		s.serveIndex(w)
+		return
+	}
+
+	s.mu.RLock()
+	path, ok := s.files[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// ServeContent handles Range and HEAD requests
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+// serveIndex lists all files as links, which is what installers browsing a
+// http location expect.
+func (s *Server) serveIndex(w http.ResponseWriter) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<html><body>\n")
+	for _, v := range s.names() {
+		fmt.Fprintf(buf, "<a href=\"%s\">%s</a><br>\n", url.PathEscape(v), html.EscapeString(v))
+	}
+	buf.WriteString("</body></html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// SendURLs tells the installer on the console at consoleIP to install urls
+// and waits until it acknowledges them, which happens once it's done
+// downloading.
+func SendURLs(ctx context.Context, consoleIP string, urls []string) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(consoleIP, strconv.Itoa(ConsolePort)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	payload := []byte(strings.Join(urls, "\n") + "\n")
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+
+	_, err = conn.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	ack := make([]byte, 1)
+	_, err = conn.Read(ack)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
@@ -0,0 +1,359 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"strings"
+)
+
+// NPDM is the parsed main.npdm of a program: its META header, the ACI0 with
+// what the program actually requests and the signed ACID with what it's
+// allowed to request.
+type NPDM struct {
+	Name                string
+	ProductCode         string
+	Version             uint32
+	Is64Bit             bool
+	AddressSpaceType    uint8
+	MainThreadPriority  uint8
+	MainThreadCore      uint8
+	MainThreadStackSize uint32
+	SystemResourceSize  uint32
+
+	// ProgramID is from the ACI0.
+	ProgramID uint64
+	// FSPermissions is the filesystem permission mask of the ACI0.
+	FSPermissions uint64
+	// Services the program connects to and HostedServices the ones it
+	// registers itself.
+	Services           []string
+	HostedServices     []string
+	KernelCapabilities KernelCapabilities
+
+	ACIDSignature    []byte
+	ACIDPublicKey    []byte
+	ACIDFlags        uint32
+	ACIDProgramIDMin uint64
+	ACIDProgramIDMax uint64
+}
+
+// KernelCapabilities are the decoded kernel capability descriptors of an
+// ACI0.
+type KernelCapabilities struct {
+	LowestThreadPriority  uint8
+	HighestThreadPriority uint8
+	MinCore               uint8
+	MaxCore               uint8
+	SystemCalls           []int
+	MemoryMaps            []MemoryMap
+	Interrupts            []int
+	ProgramType           uint8
+	KernelVersion         string
+	HandleTableSize       int
+	AllowDebug            bool
+	ForceDebug            bool
+	// Raw holds all descriptors as found, including unknown ones.
+	Raw []uint32
+}
+
+type MemoryMap struct {
+	Address  uint64
+	Size     uint64
+	ReadOnly bool
+	IO       bool
+}
+
+type npdmMeta struct {
+	Magic               [4]byte
+	ACIDKeyGeneration   uint32
+	_                   uint32
+	Flags               uint8
+	_                   uint8
+	MainThreadPriority  uint8
+	MainThreadCore      uint8
+	_                   uint32
+	SystemResourceSize  uint32
+	Version             uint32
+	MainThreadStackSize uint32
+	Name                [0x10]byte
+	ProductCode         [0x10]byte
+	_                   [0x30]byte
+	ACI0Offset          uint32
+	ACI0Size            uint32
+	ACIDOffset          uint32
+	ACIDSize            uint32
+}
+
+type npdmACI0 struct {
+	Magic     [4]byte
+	_         [0xC]byte
+	ProgramID uint64
+	_         uint64
+	FACOffset uint32
+	FACSize   uint32
+	SACOffset uint32
+	SACSize   uint32
+	KACOffset uint32
+	KACSize   uint32
+	_         uint64
+}
+
+type npdmACID struct {
+	Signature    [0x100]byte
+	PublicKey    [0x100]byte
+	Magic        [4]byte
+	Size         uint32
+	_            uint32
+	Flags        uint32
+	ProgramIDMin uint64
+	ProgramIDMax uint64
+	FACOffset    uint32
+	FACSize      uint32
+	SACOffset    uint32
+	SACSize      uint32
+	KACOffset    uint32
+	KACSize      uint32
+	_            uint64
+}
+
+func ReadNPDM(path string) (NPDM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	return ParseNPDM(data)
+}
+
+func ParseNPDM(data []byte) (NPDM, error) {
+	m := npdmMeta{}
+	err := readNPDMStruct(data, 0, uint32(len(data)), &m)
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	if string(m.Magic[:]) != "META" {
+		return NPDM{}, errors.New("invalid npdm magic")
+	}
+
+	n := NPDM{
+		Name:                strings.TrimRight(string(m.Name[:]), "\x00"),
+		ProductCode:         strings.TrimRight(string(m.ProductCode[:]), "\x00"),
+		Version:             m.Version,
+		Is64Bit:             m.Flags&1 != 0,
+		AddressSpaceType:    m.Flags >> 1 & 7,
+		MainThreadPriority:  m.MainThreadPriority,
+		MainThreadCore:      m.MainThreadCore,
+		MainThreadStackSize: m.MainThreadStackSize,
+		SystemResourceSize:  m.SystemResourceSize,
+	}
+
+	aci0 := npdmACI0{}
+	err = readNPDMStruct(data, m.ACI0Offset, m.ACI0Size, &aci0)
+	if err != nil {
+		return NPDM{}, fmt.Errorf("aci0: %v", err)
+	}
+
+	if string(aci0.Magic[:]) != "ACI0" {
+		return NPDM{}, errors.New("invalid aci0 magic")
+	}
+	n.ProgramID = aci0.ProgramID
+
+	fac, err := npdmSection(data, m.ACI0Offset, m.ACI0Size, aci0.FACOffset, aci0.FACSize)
+	if err != nil {
+		return NPDM{}, fmt.Errorf("aci0 fs access: %v", err)
+	}
+
+	// the permission mask follows the version and padding
+	if len(fac) >= 0xC {
+		n.FSPermissions = binary.LittleEndian.Uint64(fac[4:])
+	}
+
+	sac, err := npdmSection(data, m.ACI0Offset, m.ACI0Size, aci0.SACOffset, aci0.SACSize)
+	if err != nil {
+		return NPDM{}, fmt.Errorf("aci0 service access: %v", err)
+	}
+
+	n.Services, n.HostedServices, err = parseServiceAccess(sac)
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	kac, err := npdmSection(data, m.ACI0Offset, m.ACI0Size, aci0.KACOffset, aci0.KACSize)
+	if err != nil {
+		return NPDM{}, fmt.Errorf("aci0 kernel capabilities: %v", err)
+	}
+
+	n.KernelCapabilities, err = parseKernelCapabilities(kac)
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	if m.ACIDSize == 0 {
+		return n, nil
+	}
+
+	acid := npdmACID{}
+	err = readNPDMStruct(data, m.ACIDOffset, m.ACIDSize, &acid)
+	if err != nil {
+		return NPDM{}, fmt.Errorf("acid: %v", err)
+	}
+
+	if string(acid.Magic[:]) != "ACID" {
+		return NPDM{}, errors.New("invalid acid magic")
+	}
+
+	n.ACIDSignature = append([]byte{}, acid.Signature[:]...)
+	n.ACIDPublicKey = append([]byte{}, acid.PublicKey[:]...)
+	n.ACIDFlags = acid.Flags
+	n.ACIDProgramIDMin = acid.ProgramIDMin
+	n.ACIDProgramIDMax = acid.ProgramIDMax
+
+	return n, nil
+}
+
+// readNPDMStruct reads v from the block of data at off with size.
+func readNPDMStruct(data []byte, off, size uint32, v interface{}) error {
+	if uint64(off)+uint64(size) > uint64(len(data)) || int(size) < binary.Size(v) {
+		return errors.New("npdm is too small")
+	}
+
+	return binary.Read(bytes.NewReader(data[off:]), binary.LittleEndian, v)
+}
+
+// npdmSection returns the part of the block at blockOff with blockSize that
+// is at off with size relative to the block.
+func npdmSection(data []byte, blockOff, blockSize, off, size uint32) ([]byte, error) {
+	if uint64(off)+uint64(size) > uint64(blockSize) {
+		return nil, errors.New("out of range")
+	}
+
+	start := uint64(blockOff) + uint64(off)
+
+	return data[start : start+uint64(size)], nil
+}
+
+// parseServiceAccess splits the service access control list into services
+// accessed and hosted. Every entry starts with a control byte holding the
+// name length minus one and the host flag in its top bit.
+func parseServiceAccess(sac []byte) ([]string, []string, error) {
+	services, hosted := []string{}, []string{}
+	for len(sac) > 0 {
+		c := sac[0]
+		size := int(c&7) + 1
+		if len(sac) < 1+size {
+			return nil, nil, errors.New("service access list is truncated")
+		}
+
+		name := string(sac[1 : 1+size])
+		if c&0x80 != 0 {
+			hosted = append(hosted, name)
+		} else {
+			services = append(services, name)
+		}
+
+		sac = sac[1+size:]
+	}
+
+	return services, hosted, nil
+}
+
+// parseKernelCapabilities decodes the descriptors, whose type is the number
+// of set low bits before the first clear one.
+func parseKernelCapabilities(kac []byte) (KernelCapabilities, error) {
+	k := KernelCapabilities{}
+	if len(kac)%4 != 0 {
+		return k, errors.New("kernel capabilities are not a multiple of 4 bytes")
+	}
+
+	for i := 0; i < len(kac); i += 4 {
+		k.Raw = append(k.Raw, binary.LittleEndian.Uint32(kac[i:]))
+	}
+
+	for i := 0; i < len(k.Raw); i++ {
+		d := k.Raw[i]
+
+		switch bits.TrailingZeros32(^d) {
+		case 3:
+			k.LowestThreadPriority = uint8(d >> 4 & 0x3F)
+			k.HighestThreadPriority = uint8(d >> 10 & 0x3F)
+			k.MinCore = uint8(d >> 16)
+			k.MaxCore = uint8(d >> 24)
+		case 4:
+			mask, index := d>>5&0xFFFFFF, int(d>>29)
+			for b := 0; b < 24; b++ {
+				if mask&(1<<uint(b)) != 0 {
+					k.SystemCalls = append(k.SystemCalls, index*24+b)
+				}
+			}
+		case 6:
+			// memory maps take two descriptors, the second has the size
+			if i+1 >= len(k.Raw) {
+				return k, errors.New("memory map descriptor is missing its size")
+			}
+			i++
+
+			k.MemoryMaps = append(k.MemoryMaps, MemoryMap{
+				Address:  uint64(d>>7&0xFFFFFF) << 12,
+				Size:     uint64(k.Raw[i]>>7&0xFFFFF) << 12,
+				ReadOnly: d>>31 != 0,
+			})
+		case 7:
+			k.MemoryMaps = append(k.MemoryMaps, MemoryMap{
+				Address: uint64(d>>8&0xFFFFFF) << 12,
+				Size:    0x1000,
+				IO:      true,
+			})
+		case 11:
+			for _, irq := range []uint32{d >> 12 & 0x3FF, d >> 22 & 0x3FF} {
+				if irq != 0x3FF {
+					k.Interrupts = append(k.Interrupts, int(irq))
+				}
+			}
+		case 13:
+			k.ProgramType = uint8(d >> 14 & 7)
+		case 14:
+			k.KernelVersion = fmt.Sprintf("%d.%d", d>>19, d>>15&0xF)
+		case 15:
+			k.HandleTableSize = int(d >> 16 & 0x3FF)
+		case 16:
+			k.AllowDebug = d>>17&1 != 0
+			k.ForceDebug = d>>18&1 != 0
+		}
+	}
+
+	return k, nil
+}
+
+// NPDM reads the main.npdm from the ExeFS of a Program NCA.
+func (n *NCAReader) NPDM() (NPDM, error) {
+	exefs, err := n.nca.exeFS()
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	entries, err := readPFS0(exefs)
+	if err != nil {
+		return NPDM{}, err
+	}
+
+	for _, v := range entries {
+		if v.Name != "main.npdm" {
+			continue
+		}
+
+		b := make([]byte, v.Size)
+		_, err = exefs.ReadAt(b, v.Offset)
+		if err != nil {
+			return NPDM{}, err
+		}
+
+		return ParseNPDM(b)
+	}
+
+	return NPDM{}, errors.New("exefs has no main.npdm")
+}
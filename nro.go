@@ -0,0 +1,136 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// NRO is a homebrew executable with the assets appended to it.
+type NRO struct {
+	Size    uint32
+	Flags   uint32
+	BuildID []byte
+	Text    NROSegment
+	RO      NROSegment
+	Data    NROSegment
+	BSSSize uint32
+
+	// Icon is a JPEG, NACP and RomFS are nil if the NRO doesn't have them.
+	Icon  []byte
+	NACP  *NACP
+	RomFS *io.SectionReader
+}
+
+// NROSegment is a segment of an NRO, the offset is in the file and memory
+// alike.
+type NROSegment struct {
+	Offset uint32
+	Size   uint32
+}
+
+type nroHeader struct {
+	_          uint32
+	Mod0Offset uint32
+	_          uint64
+	Magic      [4]byte
+	Version    uint32
+	Size       uint32
+	Flags      uint32
+	Segments   [3]NROSegment
+	BSSSize    uint32
+	_          uint32
+	BuildID    [0x20]byte
+}
+
+type nroAssetSection struct {
+	Offset uint64
+	Size   uint64
+}
+
+type nroAssetHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Icon    nroAssetSection
+	NACP    nroAssetSection
+	RomFS   nroAssetSection
+}
+
+func ReadNRO(path string) (NRO, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NRO{}, err
+	}
+
+	return ParseNRO(bytes.NewReader(data))
+}
+
+// ParseNRO reads the header and the assets of an NRO. The RomFS is left in r
+// to be read with ReadRomFS or NewRomFSFS.
+func ParseNRO(r io.ReaderAt) (NRO, error) {
+	h := nroHeader{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x80), binary.LittleEndian, &h)
+	if err != nil {
+		return NRO{}, errors.New("nro is too small")
+	}
+
+	if string(h.Magic[:]) != "NRO0" {
+		return NRO{}, errors.New("invalid nro magic")
+	}
+
+	n := NRO{
+		Size:    h.Size,
+		Flags:   h.Flags,
+		BuildID: append([]byte{}, h.BuildID[:]...),
+		Text:    h.Segments[0],
+		RO:      h.Segments[1],
+		Data:    h.Segments[2],
+		BSSSize: h.BSSSize,
+	}
+
+	// the assets are optional and follow the executable
+	a := nroAssetHeader{}
+	err = binary.Read(io.NewSectionReader(r, int64(h.Size), 0x38), binary.LittleEndian, &a)
+	if err != nil || string(a.Magic[:]) != "ASET" {
+		return n, nil
+	}
+
+	section := func(s nroAssetSection) *io.SectionReader {
+		return io.NewSectionReader(r, int64(h.Size)+int64(s.Offset), int64(s.Size))
+	}
+
+	if a.Icon.Size > 0 {
+		n.Icon, err = ioutil.ReadAll(section(a.Icon))
+		if err != nil {
+			return NRO{}, fmt.Errorf("nro icon: %v", err)
+		}
+	}
+
+	if a.NACP.Size > 0 {
+		b, err := ioutil.ReadAll(section(a.NACP))
+		if err != nil {
+			return NRO{}, fmt.Errorf("nro nacp: %v", err)
+		}
+
+		nacp, err := ParseNACP(b)
+		if err != nil {
+			return NRO{}, err
+		}
+		n.NACP = &nacp
+	}
+
+	if a.RomFS.Size > 0 {
+		n.RomFS = section(a.RomFS)
+	}
+
+	return n, nil
+}
+
+// BuildIDString returns the build id in hex without its zero padding.
+func (n NRO) BuildIDString() string {
+	return hex.EncodeToString(bytes.TrimRight(n.BuildID, "\x00"))
+}
@@ -0,0 +1,213 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NSO is a parsed NSO0 executable like main or the sdk modules of an ExeFS,
+// with its segments decompressed.
+type NSO struct {
+	ModuleName string
+	// BuildID is the 0x20 byte module id, usually a 0x14 byte GNU build id
+	// padded with zeros.
+	BuildID []byte
+	Flags   uint32
+	Text    NSOSegment
+	RO      NSOSegment
+	Data    NSOSegment
+	BSSSize uint32
+}
+
+type NSOSegment struct {
+	MemoryOffset uint32
+	Data         []byte
+}
+
+type nsoSegmentHeader struct {
+	FileOffset   uint32
+	MemoryOffset uint32
+	Size         uint32
+}
+
+type nsoHeader struct {
+	Magic            [4]byte
+	Version          uint32
+	_                uint32
+	Flags            uint32
+	Text             nsoSegmentHeader
+	ModuleNameOffset uint32
+	RO               nsoSegmentHeader
+	ModuleNameSize   uint32
+	Data             nsoSegmentHeader
+	BSSSize          uint32
+	BuildID          [0x20]byte
+	FileSizes        [3]uint32
+	_                [0x1C]byte
+	APIInfo          [2]uint32
+	DynStr           [2]uint32
+	DynSym           [2]uint32
+	Hashes           [3][0x20]byte
+}
+
+func ReadNSO(path string) (NSO, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NSO{}, err
+	}
+
+	return ParseNSO(data)
+}
+
+// ParseNSO decompresses the segments of an NSO and checks their hashes if
+// its flags say so.
+func ParseNSO(data []byte) (NSO, error) {
+	h := nsoHeader{}
+	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h)
+	if err != nil {
+		return NSO{}, errors.New("nso is too small")
+	}
+
+	if string(h.Magic[:]) != "NSO0" {
+		return NSO{}, errors.New("invalid nso magic")
+	}
+
+	n := NSO{
+		BuildID: append([]byte{}, h.BuildID[:]...),
+		Flags:   h.Flags,
+		BSSSize: h.BSSSize,
+	}
+
+	if end := uint64(h.ModuleNameOffset) + uint64(h.ModuleNameSize); h.ModuleNameSize > 0 && end <= uint64(len(data)) {
+		n.ModuleName = string(bytes.TrimRight(data[h.ModuleNameOffset:end], "\x00"))
+	}
+
+	names := []string{"text", "ro", "data"}
+	segments := []*NSOSegment{&n.Text, &n.RO, &n.Data}
+	for i, sh := range []nsoSegmentHeader{h.Text, h.RO, h.Data} {
+		end := uint64(sh.FileOffset) + uint64(h.FileSizes[i])
+		if end > uint64(len(data)) {
+			return NSO{}, fmt.Errorf("nso %s segment is out of range", names[i])
+		}
+
+		seg := data[sh.FileOffset:end]
+		if h.Flags&(1<<uint(i)) != 0 {
+			seg, err = lz4Decompress(seg, int(sh.Size))
+			if err != nil {
+				return NSO{}, fmt.Errorf("nso %s segment: %v", names[i], err)
+			}
+		} else {
+			if uint32(len(seg)) != sh.Size {
+				return NSO{}, fmt.Errorf("nso %s segment has the wrong size", names[i])
+			}
+			seg = append([]byte{}, seg...)
+		}
+
+		if h.Flags&(1<<uint(i+3)) != 0 {
+			if sum := sha256.Sum256(seg); !bytes.Equal(sum[:], h.Hashes[i][:]) {
+				return NSO{}, fmt.Errorf("%w: nso %s segment", ErrHashMismatch, names[i])
+			}
+		}
+
+		*segments[i] = NSOSegment{sh.MemoryOffset, seg}
+	}
+
+	return n, nil
+}
+
+// BuildIDString returns the build id in hex without its zero padding, the way
+// cheat and patch databases name modules.
+func (n NSO) BuildIDString() string {
+	return hex.EncodeToString(bytes.TrimRight(n.BuildID, "\x00"))
+}
+
+// lz4Decompress decompresses a raw LZ4 block of known decompressed size.
+func lz4Decompress(src []byte, size int) ([]byte, error) {
+	// lz4 can't compress better than about 255:1, larger sizes are bogus and
+	// mustn't be allocated
+	if size < 0 || size > len(src)*255+16 {
+		return nil, fmt.Errorf("lz4 block of %d bytes can't decompress to %d", len(src), size)
+	}
+
+	dst := make([]byte, 0, size)
+
+	// lengths of 15 continue in the following bytes until one isn't 255
+	length := func(i int, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+
+		for {
+			if i >= len(src) {
+				return 0, 0, errors.New("lz4 length is truncated")
+			}
+
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	var lit, match int
+	var err error
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		i, lit, err = length(i, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+
+		if i+lit > len(src) || len(dst)+lit > size {
+			return nil, errors.New("lz4 literals are out of range")
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+
+		// the last sequence has no match
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errors.New("lz4 match offset is truncated")
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+
+		if off == 0 || off > len(dst) {
+			return nil, errors.New("lz4 match offset is out of range")
+		}
+
+		i, match, err = length(i, int(token&15))
+		if err != nil {
+			return nil, err
+		}
+		match += 4
+
+		if len(dst)+match > size {
+			return nil, errors.New("lz4 data is larger than expected")
+		}
+
+		// matches may overlap what they copy, so go byte by byte
+		start := len(dst) - off
+		for j := 0; j < match; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+
+	if len(dst) != size {
+		return nil, fmt.Errorf("lz4 data is %d bytes, expected %d", len(dst), size)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,222 @@
+package libhac
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type NSPEntry struct {
+	Name   string
+	Size   int64
+	Reader io.Reader
+}
+
+// NSPWriter streams a PFS0 archive to w. Entries are only read once Close
+// is called, after the header (which needs every size up front) is written.
+type NSPWriter struct {
+	// Log is optional and gets every entry as it's written.
+	Log Logger
+
+	w       io.Writer
+	entries []NSPEntry
+}
+
+func NewNSPWriter(w io.Writer) *NSPWriter {
+	return &NSPWriter{w: w}
+}
+
+func (n *NSPWriter) Add(name string, size int64, r io.Reader) {
+	n.entries = append(n.entries, NSPEntry{name, size, r})
+}
+
+func (n *NSPWriter) Close() error {
+	_, err := n.w.Write(buildPFS0Header(n.entries))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range n.entries {
+		logf(n.Log, "packing %s, %d bytes", v.Name, v.Size)
+		written, err := io.Copy(n.w, io.LimitReader(v.Reader, v.Size))
+		if err != nil {
+			return err
+		}
+
+		if written != v.Size {
+			return fmt.Errorf("%s is %d bytes, expected %d", v.Name, written, v.Size)
+		}
+	}
+
+	return nil
+}
+
+// PackEntries writes entries to w as an NSP in the order given, reading each
+// one from its Reader. Nothing has to be on disk, so NSPs can be assembled
+// from downloads and generated tickets or xml directly.
+func PackEntries(w io.Writer, entries []NSPEntry) error {
+	seen := map[string]bool{}
+	for _, v := range entries {
+		err := checkNSPEntryName(v.Name)
+		if err != nil {
+			return err
+		}
+
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate nsp entry %s", v.Name)
+		}
+		seen[v.Name] = true
+	}
+
+	n := NewNSPWriter(w)
+	for _, v := range entries {
+		n.Add(v.Name, v.Size, v.Reader)
+	}
+
+	return n.Close()
+}
+
+func checkNSPEntryName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("invalid nsp entry name %q", name)
+	}
+
+	return nil
+}
+
+// SortNSPEntries sorts entries by order, usually DefaultNSPOrder.
+func SortNSPEntries(entries []NSPEntry, order NSPOrder) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return order(entries[i].Name, entries[j].Name)
+	})
+}
+
+func buildPFS0Header(entries []NSPEntry) []byte {
+	n := []string{}
+	for _, v := range entries {
+		n = append(n, v.Name)
+	}
+
+	stringTable := strings.Join(n, "\x00")
+	headerSize := 0x10 + (len(entries) * 0x18) + len(stringTable)
+	remainder := 0x10 - headerSize%0x10
+	headerSize += remainder
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, []byte("PFS0")...)
+	header = append(header, toBinary32(int32(len(entries)))...)
+	header = append(header, toBinary32(int32(len(stringTable)+remainder))...)
+	header = append(header, []byte("\x00\x00\x00\x00")...)
+
+	var fileOffset int64
+	var stringTableOffset int
+	for _, v := range entries {
+		header = append(header, toBinary64(fileOffset)...)
+		header = append(header, toBinary64(v.Size)...)
+		header = append(header, toBinary32(int32(stringTableOffset))...)
+		header = append(header, []byte("\x00\x00\x00\x00")...)
+
+		fileOffset += v.Size
+		stringTableOffset += len(v.Name) + 1
+	}
+
+	header = append(header, []byte(stringTable)...)
+	header = append(header, make([]byte, remainder)...)
+
+	return header
+}
+
+type NSPReader struct {
+	r       io.ReaderAt
+	c       io.Closer
+	Entries []PFS0Entry
+}
+
+func NewNSPReader(r io.ReaderAt) (*NSPReader, error) {
+	entries, err := readPFS0(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NSPReader{r: r, Entries: entries}, nil
+}
+
+func OpenNSP(path string) (*NSPReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := NewNSPReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	n.c = f
+
+	return n, nil
+}
+
+func (n *NSPReader) Close() error {
+	if n.c == nil {
+		return nil
+	}
+
+	return n.c.Close()
+}
+
+func (n *NSPReader) Entry(name string) (PFS0Entry, error) {
+	for _, v := range n.Entries {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+
+	return PFS0Entry{}, fmt.Errorf("%s not found in nsp", name)
+}
+
+func (n *NSPReader) Open(name string) (*io.SectionReader, error) {
+	e, err := n.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(n.r, e.Offset, e.Size), nil
+}
+
+// Extract writes the named entries to out, or every entry if none are given.
+func (n *NSPReader) Extract(out string, names ...string) error {
+	err := os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	entries := n.Entries
+	if len(names) > 0 {
+		entries = []PFS0Entry{}
+		for _, name := range names {
+			e, err := n.Entry(name)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	for _, v := range entries {
+		if strings.ContainsAny(v.Name, `/\`) || v.Name == ".." {
+			return fmt.Errorf("refusing to extract unsafe name %q", v.Name)
+		}
+
+		err = writeFile(filepath.Join(out, v.Name), io.NewSectionReader(n.r, v.Offset, v.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
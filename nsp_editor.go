@@ -0,0 +1,224 @@
+package libhac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NSPEditor adds, replaces and removes entries of an NSP in place, e.g. to
+// add a missing ticket or swap the cnmt xml. Kept entries are shifted within
+// the file rather than copied to a new one, which is cheap for the small
+// entries at the end and never needs the space of the NSP twice. The NSP is
+// corrupt if Commit fails midway.
+type NSPEditor struct {
+	// Log is optional and gets every entry that's moved or written.
+	Log Logger
+
+	f          *os.File
+	dataOffset int64
+	entries    []nspEdit
+}
+
+// nspEdit is an entry of the edited NSP, either kept at offset or new from r.
+type nspEdit struct {
+	name   string
+	size   int64
+	offset int64
+	r      io.Reader
+}
+
+func OpenNSPEditor(path string) (*NSPEditor, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &NSPEditor{f: f}
+	err = e.load()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *NSPEditor) load() error {
+	entries, err := readPFS0(e.f)
+	if err != nil {
+		return err
+	}
+
+	e.dataOffset, err = pfs0DataOffset(e.f)
+	if err != nil {
+		return err
+	}
+
+	e.entries = nil
+	for _, v := range entries {
+		e.entries = append(e.entries, nspEdit{v.Name, v.Size, v.Offset, nil})
+	}
+
+	return nil
+}
+
+// Names returns the names of the entries as they'll be after Commit.
+func (e *NSPEditor) Names() []string {
+	names := []string{}
+	for _, v := range e.entries {
+		names = append(names, v.name)
+	}
+
+	return names
+}
+
+// Add replaces the entry called name, or inserts it where DefaultNSPOrder
+// puts it. r is only read by Commit.
+func (e *NSPEditor) Add(name string, size int64, r io.Reader) error {
+	err := checkNSPEntryName(name)
+	if err != nil {
+		return err
+	}
+
+	edit := nspEdit{name, size, -1, r}
+	for i, v := range e.entries {
+		if v.name == name {
+			e.entries[i] = edit
+			return nil
+		}
+	}
+
+	for i, v := range e.entries {
+		if DefaultNSPOrder(name, v.name) {
+			e.entries = append(e.entries[:i], append([]nspEdit{edit}, e.entries[i:]...)...)
+			return nil
+		}
+	}
+	e.entries = append(e.entries, edit)
+
+	return nil
+}
+
+func (e *NSPEditor) Remove(name string) error {
+	for i, v := range e.entries {
+		if v.name == name {
+			e.entries = append(e.entries[:i], e.entries[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s not found in nsp", name)
+}
+
+// Commit rewrites the NSP with the changes. Kept entries moving towards the
+// start are moved first to last and the ones moving towards the end last to
+// first, so no entry is overwritten before it's moved. New data and the
+// header are written after.
+func (e *NSPEditor) Commit() error {
+	list := []NSPEntry{}
+	for _, v := range e.entries {
+		list = append(list, NSPEntry{v.name, v.size, v.r})
+	}
+
+	header := buildPFS0Header(list)
+
+	// reuse the space of the old header if the new one is smaller, then
+	// nothing has to move unless entry sizes change
+	if pad := e.dataOffset - int64(len(header)); pad > 0 {
+		size := binary.LittleEndian.Uint32(header[8:])
+		binary.LittleEndian.PutUint32(header[8:], size+uint32(pad))
+		header = append(header, make([]byte, pad)...)
+	}
+
+	offsets := make([]int64, len(e.entries))
+	off := int64(len(header))
+	for i, v := range e.entries {
+		offsets[i] = off
+		off += v.size
+	}
+
+	for i, v := range e.entries {
+		if v.r == nil && offsets[i] < v.offset {
+			err := e.move(v, offsets[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(e.entries) - 1; i >= 0; i-- {
+		if v := e.entries[i]; v.r == nil && offsets[i] > v.offset {
+			err := e.move(v, offsets[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, v := range e.entries {
+		if v.r == nil {
+			continue
+		}
+
+		logf(e.Log, "writing %s, %d bytes", v.name, v.size)
+		written, err := io.Copy(&offsetWriter{e.f, offsets[i]}, io.LimitReader(v.r, v.size))
+		if err != nil {
+			return err
+		}
+
+		if written != v.size {
+			return fmt.Errorf("%s is %d bytes, expected %d", v.name, written, v.size)
+		}
+	}
+
+	_, err := e.f.WriteAt(header, 0)
+	if err != nil {
+		return err
+	}
+
+	err = e.f.Truncate(off)
+	if err != nil {
+		return err
+	}
+
+	return e.load()
+}
+
+// move copies the data of a kept entry to offset in chunks, starting from
+// the end when moving it towards the end so it can overlap itself.
+func (e *NSPEditor) move(v nspEdit, offset int64) error {
+	logf(e.Log, "moving %s from %d to %d", v.name, v.offset, offset)
+
+	buf := make([]byte, 1<<20)
+	for done := int64(0); done < v.size; {
+		n := int64(len(buf))
+		if v.size-done < n {
+			n = v.size - done
+		}
+
+		pos := done
+		if offset > v.offset {
+			pos = v.size - done - n
+		}
+
+		_, err := e.f.ReadAt(buf[:n], v.offset+pos)
+		if err != nil {
+			return err
+		}
+
+		_, err = e.f.WriteAt(buf[:n], offset+pos)
+		if err != nil {
+			return err
+		}
+
+		done += n
+	}
+
+	return nil
+}
+
+func (e *NSPEditor) Close() error {
+	return e.f.Close()
+}
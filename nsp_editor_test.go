@@ -0,0 +1,190 @@
+package libhac_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jakibaki/libhac"
+)
+
+// writeTestNSP packs files, in order, into an NSP in a temporary directory.
+func writeTestNSP(t *testing.T, files map[string]string, order []string) string {
+	path := filepath.Join(t.TempDir(), "test.nsp")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := libhac.NewNSPWriter(f)
+	for _, name := range order {
+		w.Add(name, int64(len(files[name])), strings.NewReader(files[name]))
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// readTestNSP returns the names in order and contents of the entries of
+// the NSP at path.
+func readTestNSP(t *testing.T, path string) ([]string, map[string]string) {
+	nsp, err := libhac.OpenNSP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nsp.Close()
+
+	names := []string{}
+	files := map[string]string{}
+	for _, v := range nsp.Entries {
+		r, err := nsp.Open(v.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, v.Name)
+		files[v.Name] = string(data)
+	}
+
+	return names, files
+}
+
+func TestNSPEditorCommit(t *testing.T) {
+	files := map[string]string{
+		"0123456789abcdef0123456789abcdef.nca":      strings.Repeat("program", 0x1000),
+		"fedcba9876543210fedcba9876543210.cnmt.nca": strings.Repeat("meta", 0x100),
+		"fedcba9876543210fedcba9876543210.cnmt.xml": "<ContentMeta/>",
+	}
+	path := writeTestNSP(t, files, []string{
+		"0123456789abcdef0123456789abcdef.nca",
+		"fedcba9876543210fedcba9876543210.cnmt.nca",
+		"fedcba9876543210fedcba9876543210.cnmt.xml",
+	})
+
+	e, err := libhac.OpenNSPEditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// a longer name grows the header, so every kept entry has to move
+	tik := "0100000000010000000000000000000a.tik"
+	cert := "0100000000010000000000000000000a.cert"
+	xml := "<ContentMeta><Digest>00</Digest></ContentMeta>"
+
+	for _, v := range []struct {
+		name, data string
+	}{
+		{tik, strings.Repeat("t", 0x2c0)},
+		{cert, strings.Repeat("c", 0x700)},
+		{"fedcba9876543210fedcba9876543210.cnmt.xml", xml},
+	} {
+		err = e.Add(v.name, int64(len(v.data)), strings.NewReader(v.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[v.name] = v.data
+	}
+
+	err = e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, got := readTestNSP(t, path)
+	if len(got) != len(files) {
+		t.Fatalf("got entries %v", names)
+	}
+	for name, data := range files {
+		if got[name] != data {
+			t.Errorf("%s differs after commit", name)
+		}
+	}
+
+	if names[len(names)-2] != tik || names[len(names)-1] != cert {
+		t.Errorf("tickets aren't at the end: %v", names)
+	}
+
+	// removing shrinks the header again and moves everything back
+	err = e.Remove(tik)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Remove(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(files, tik)
+	delete(files, cert)
+
+	err = e.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, got = readTestNSP(t, path)
+	if len(got) != len(files) {
+		t.Fatalf("got entries %v", names)
+	}
+	for name, data := range files {
+		if got[name] != data {
+			t.Errorf("%s differs after removing", name)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsp, err := libhac.OpenNSP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := nsp.Entries[len(nsp.Entries)-1]
+	nsp.Close()
+
+	if end := last.Offset + last.Size; fi.Size() != end {
+		t.Errorf("nsp is %d bytes, its last entry ends at %d", fi.Size(), end)
+	}
+}
+
+func TestNSPEditorErrors(t *testing.T) {
+	path := writeTestNSP(t, map[string]string{"a.nca": "a"}, []string{"a.nca"})
+
+	e, err := libhac.OpenNSPEditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if err := e.Remove("missing.nca"); err == nil {
+		t.Error("removing a missing entry didn't fail")
+	}
+
+	if err := e.Add("../escape.nca", 1, bytes.NewReader([]byte{0})); err == nil {
+		t.Error("adding a path didn't fail")
+	}
+
+	// a reader shorter than the announced size
+	err = e.Add("b.nca", 10, strings.NewReader("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Commit(); err == nil {
+		t.Error("committing a short entry didn't fail")
+	}
+}
@@ -0,0 +1,116 @@
+package libhac
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MergeNSPs packs several titles into one multi-title NSP at out, e.g. a base
+// game with its update and add-ons. Inputs are NSPs or directories of
+// downloaded titles. Entries of the same name, like NCAs or certificates
+// shared between titles, are packed once.
+func MergeNSPs(out string, inputs ...string) error {
+	entries := []NSPEntry{}
+	seen := map[string]int64{}
+
+	for _, in := range inputs {
+		found, closer, err := mergeInput(in)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		metas := 0
+		for _, v := range found {
+			if strings.HasSuffix(v.Name, ".cnmt.nca") {
+				metas++
+			}
+
+			size, ok := seen[v.Name]
+			if ok && size != v.Size {
+				return fmt.Errorf("%s is in several inputs with different sizes", v.Name)
+			}
+			if ok {
+				continue
+			}
+
+			seen[v.Name] = v.Size
+			entries = append(entries, v)
+		}
+
+		if metas == 0 {
+			return fmt.Errorf("%s has no meta nca", in)
+		}
+	}
+	SortNSPEntries(entries, DefaultNSPOrder)
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = PackEntries(f, entries)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// mergeInput returns the entries of an NSP or the files of a directory, and
+// a func to close them once they're packed.
+func mergeInput(path string) ([]NSPEntry, func(), error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !fi.IsDir() {
+		nsp, err := OpenNSP(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		entries := []NSPEntry{}
+		for _, v := range nsp.Entries {
+			entries = append(entries, NSPEntry{v.Name, v.Size, io.NewSectionReader(nsp.r, v.Offset, v.Size)})
+		}
+
+		return entries, func() { nsp.Close() }, nil
+	}
+
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := []NSPEntry{}
+	files := []*os.File{}
+	closer := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, v := range dir {
+		if !packable(v) {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(path, v.Name()))
+		if err != nil {
+			closer()
+			return nil, nil, err
+		}
+		files = append(files, f)
+
+		entries = append(entries, NSPEntry{v.Name(), v.Size(), f})
+	}
+
+	return entries, closer, nil
+}
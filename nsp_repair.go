@@ -0,0 +1,351 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type RepairOptions struct {
+	// TitleKeyDB and the title.keys of the keyset are tried in that order
+	// to generate missing tickets. TitleKey is only used if neither has the
+	// key and the NSP has a single rights id. TicketTemplate is optional.
+	TitleKey       string
+	TitleKeyDB     *TitleKeyDB
+	TicketTemplate string
+	// CertChain is used for tickets without a certificate if the NSP has
+	// no other certificate to copy.
+	CertChain string
+}
+
+// RepairNSP checks an NSP for the usual defects of badly packed ones: tickets
+// missing for rights id NCAs, certificates missing for tickets, cnmt xmls
+// missing or with wrong hashes, header padding and entry order. If any are
+// found a fixed copy is written to out and the changes are returned, if not
+// out isn't written. Defects that can't be fixed with opts are an error.
+// out has to differ from path, the NSP is read while out is written.
+func RepairNSP(path, out string, keys *Keyset, opts RepairOptions) ([]string, error) {
+	if sameFile(path, out) {
+		return nil, errors.New("out must differ from the nsp being repaired")
+	}
+
+	nsp, err := OpenNSP(path)
+	if err != nil {
+		return nil, err
+	}
+	defer nsp.Close()
+
+	entries := []NSPEntry{}
+	byName := map[string]PFS0Entry{}
+	for _, v := range nsp.Entries {
+		entries = append(entries, NSPEntry{v.Name, v.Size, io.NewSectionReader(nsp.r, v.Offset, v.Size)})
+		byName[v.Name] = v
+	}
+
+	changes := []string{}
+	set := func(name string, data []byte) {
+		e := NSPEntry{name, int64(len(data)), bytes.NewReader(data)}
+		for i, v := range entries {
+			if v.Name == name {
+				entries[i] = e
+				return
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	dataOffset, err := pfs0DataOffset(nsp.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataOffset%0x10 != 0 {
+		changes = append(changes, "padded the pfs0 header to 0x10 bytes")
+	}
+
+	for _, v := range nsp.Entries {
+		if !strings.HasSuffix(v.Name, ".cnmt.nca") {
+			continue
+		}
+
+		name := strings.TrimSuffix(v.Name, ".nca") + ".xml"
+		x, reason, err := repairCNMTXML(nsp, v, byName, keys)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name, err)
+		}
+
+		if x != nil {
+			set(name, x)
+			changes = append(changes, fmt.Sprintf("generated %s, %s", name, reason))
+		}
+	}
+
+	tickets, err := repairTickets(nsp, byName, keys, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range tickets {
+		set(v.name, v.data)
+		changes = append(changes, "generated "+v.name)
+		byName[v.name] = PFS0Entry{Name: v.name}
+	}
+
+	certs, err := repairCerts(nsp, byName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range certs {
+		set(v.name, v.data)
+		changes = append(changes, "added "+v.name)
+	}
+
+	sorted := append([]NSPEntry{}, entries...)
+	SortNSPEntries(sorted, DefaultNSPOrder)
+	for i := range nsp.Entries {
+		if i >= len(sorted) || nsp.Entries[i].Name != sorted[i].Name {
+			changes = append(changes, "sorted the entries into install order")
+			break
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = PackEntries(f, sorted)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, f.Close()
+}
+
+// repairCNMTXML returns a new xml for the meta NCA e and why, or nil if the
+// one in the NSP matches.
+func repairCNMTXML(nsp *NSPReader, e PFS0Entry, byName map[string]PFS0Entry, keys *Keyset) ([]byte, string, error) {
+	r := io.NewSectionReader(nsp.r, e.Offset, e.Size)
+
+	cnmt, err := readCNMTFromNCA(r, keys, e.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, io.NewSectionReader(r, 0, e.Size))
+	if err != nil {
+		return nil, "", err
+	}
+
+	want, err := generateCNMTXML(cnmt, e.Size, h.Sum(nil))
+	if err != nil {
+		return nil, "", err
+	}
+
+	old, ok := byName[strings.TrimSuffix(e.Name, ".nca")+".xml"]
+	if !ok {
+		return want, "it was missing", nil
+	}
+
+	data, err := ioutil.ReadAll(io.NewSectionReader(nsp.r, old.Offset, old.Size))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !cnmtXMLHashesEqual(data, want) {
+		return want, "its digest or hashes didn't match the meta", nil
+	}
+
+	return nil, "", nil
+}
+
+// cnmtXMLHashesEqual compares the digest and content hashes of two xmls,
+// other tools format them differently.
+func cnmtXMLHashesEqual(a, b []byte) bool {
+	var xa, xb cnmtXML
+	if xml.Unmarshal(a, &xa) != nil || xml.Unmarshal(b, &xb) != nil {
+		return false
+	}
+
+	if !strings.EqualFold(xa.Digest, xb.Digest) || len(xa.Contents) != len(xb.Contents) {
+		return false
+	}
+
+	hashes := map[string]string{}
+	for _, v := range xa.Contents {
+		hashes[strings.ToLower(v.ID)] = strings.ToLower(v.Hash)
+	}
+
+	for _, v := range xb.Contents {
+		if hashes[strings.ToLower(v.ID)] != strings.ToLower(v.Hash) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// repairedFile is a ticket or certificate added by RepairNSP.
+type repairedFile struct {
+	name string
+	data []byte
+}
+
+// repairTickets generates the tickets missing for rights id NCAs.
+func repairTickets(nsp *NSPReader, byName map[string]PFS0Entry, keys *Keyset, opts RepairOptions) ([]repairedFile, error) {
+	headers := []NCAHeader{}
+	names := []string{}
+	rightsIDs := map[string]bool{}
+
+	for _, v := range nsp.Entries {
+		if !strings.HasSuffix(v.Name, ".nca") || strings.HasSuffix(v.Name, ".cnmt.nca") {
+			continue
+		}
+
+		h, err := parseNCAHeader(io.NewSectionReader(nsp.r, v.Offset, v.Size), keys)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name, err)
+		}
+
+		if h.HasRightsID() {
+			headers = append(headers, h)
+			names = append(names, v.Name)
+			rightsIDs[h.RightsID] = true
+		}
+	}
+
+	// a single titlekey can't belong to more than one rights id
+	single := len(rightsIDs) == 1
+
+	tickets := []repairedFile{}
+	done := map[string]bool{}
+	for i, h := range headers {
+		name := h.RightsID + ".tik"
+		if done[name] {
+			continue
+		}
+		done[name] = true
+
+		if _, ok := byName[name]; ok {
+			continue
+		}
+
+		titleKey := repairTitleKey(h.RightsID, keys, opts, single)
+		if titleKey == "" {
+			return nil, fmt.Errorf("%s is missing and the titlekey of %s isn't known", name, names[i])
+		}
+
+		template, err := ticketTemplate(opts.TicketTemplate)
+		if err != nil {
+			return nil, err
+		}
+
+		tik, err := patchTicket(template, titleKey, fmt.Sprintf("%02x", h.MasterKeyRevision()), h.RightsID)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, repairedFile{name, tik})
+	}
+
+	return tickets, nil
+}
+
+// repairTitleKey looks up the titlekey of rightsID, opts.TitleKey is only
+// a fallback for NSPs with a single rights id.
+func repairTitleKey(rightsID string, keys *Keyset, opts RepairOptions, single bool) string {
+	if opts.TitleKeyDB != nil {
+		if tk, ok := opts.TitleKeyDB.Get(rightsID); ok {
+			return tk
+		}
+	}
+
+	if keys != nil {
+		if tk, ok := keys.TitleKeys[rightsID]; ok {
+			return fmt.Sprintf("%x", tk)
+		}
+	}
+
+	if single {
+		return opts.TitleKey
+	}
+
+	return ""
+}
+
+// repairCerts returns the certificates missing for tickets, copied from
+// another certificate in the NSP or read from opts.CertChain.
+func repairCerts(nsp *NSPReader, byName map[string]PFS0Entry, opts RepairOptions) ([]repairedFile, error) {
+	var chain []byte
+	for _, v := range nsp.Entries {
+		if strings.HasSuffix(v.Name, ".cert") {
+			data, err := ioutil.ReadAll(io.NewSectionReader(nsp.r, v.Offset, v.Size))
+			if err != nil {
+				return nil, err
+			}
+
+			chain = data
+			break
+		}
+	}
+
+	names := []string{}
+	for name := range byName {
+		if strings.HasSuffix(name, ".tik") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	certs := []repairedFile{}
+	for _, name := range names {
+
+		cert := strings.TrimSuffix(name, ".tik") + ".cert"
+		if _, ok := byName[cert]; ok {
+			continue
+		}
+
+		if chain == nil && opts.CertChain != "" {
+			data, err := ReadCertChain(opts.CertChain)
+			if err != nil {
+				return nil, err
+			}
+			chain = data
+		}
+
+		if chain == nil {
+			return nil, errors.New(cert + " is missing and there's no certificate chain to copy")
+		}
+		certs = append(certs, repairedFile{cert, chain})
+	}
+
+	return certs, nil
+}
+
+// sameFile reports whether a and b are the same existing file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fa, fb)
+}
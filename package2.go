@@ -0,0 +1,200 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// BootImagePackageTitleID has package2 in its RomFS as nx/package2.
+	BootImagePackageTitleID = "0100000000000819"
+
+	package2HeaderOffset = 0x100
+	package2DataOffset   = 0x200
+)
+
+// Package2 is a decrypted package2 with the kernel and the KIPs of its INI1.
+type Package2 struct {
+	KeyGeneration int
+	VersionMax    uint8
+	VersionMin    uint8
+	Kernel        []byte
+	INI1          []byte
+	KIPs          []KIP
+}
+
+type package2Header struct {
+	HeaderCtr      [0x10]byte
+	SectionCtrs    [4][0x10]byte
+	Magic          [4]byte
+	BaseOffset     uint32
+	_              uint32
+	VersionMax     uint8
+	VersionMin     uint8
+	_              uint16
+	SectionSizes   [4]uint32
+	SectionOffsets [4]uint32
+	SectionHashes  [4][0x20]byte
+}
+
+func ReadPackage2(path string, keys *Keyset) (Package2, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Package2{}, err
+	}
+
+	return ParsePackage2(data, keys)
+}
+
+// ReadPackage2FromNCA reads package2 from the RomFS of a BootImagePackage NCA
+// of a downloaded firmware.
+func ReadPackage2FromNCA(path string, keys *Keyset) (Package2, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Package2{}, err
+	}
+	defer f.Close()
+
+	nca, err := NewNCAReader(f, keys)
+	if err != nil {
+		return Package2{}, err
+	}
+
+	r, err := nca.OpenRomFSFile("/nx/package2")
+	if err != nil {
+		return Package2{}, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Package2{}, err
+	}
+
+	return ParsePackage2(data, keys)
+}
+
+// ParsePackage2 decrypts package2 with the package2 key of whichever key
+// generation it's encrypted with. data may also be a whole BCPKG2 partition,
+// where package2 follows the 0x4000 byte boot config.
+func ParsePackage2(data []byte, keys *Keyset) (Package2, error) {
+	p, err := parsePackage2(data, keys)
+	if err != nil && len(data) > 0x4000 {
+		if bp, berr := parsePackage2(data[0x4000:], keys); berr == nil {
+			return bp, nil
+		}
+	}
+
+	return p, err
+}
+
+func parsePackage2(data []byte, keys *Keyset) (Package2, error) {
+	if len(data) < package2DataOffset {
+		return Package2{}, errors.New("package2 is too small")
+	}
+
+	enc := data[package2HeaderOffset:package2DataOffset]
+
+	var h package2Header
+	gen := -1
+	for g := 0; g < 0x20; g++ {
+		key, err := keys.package2Key(g)
+		if err != nil {
+			continue
+		}
+
+		dec, err := package2Decrypt(key, enc[:0x10], enc)
+		if err != nil {
+			return Package2{}, err
+		}
+
+		if string(dec[0x50:0x54]) != "PK21" {
+			continue
+		}
+
+		err = binary.Read(bytes.NewReader(dec), binary.LittleEndian, &h)
+		if err != nil {
+			return Package2{}, err
+		}
+		gen = g
+
+		break
+	}
+
+	if gen < 0 {
+		return Package2{}, errors.New("no package2 key decrypts the package2 header")
+	}
+
+	key, _ := keys.package2Key(gen)
+	p := Package2{KeyGeneration: gen, VersionMax: h.VersionMax, VersionMin: h.VersionMin}
+
+	sections := [][]byte{}
+	off := package2DataOffset
+	for i, size := range h.SectionSizes[:2] {
+		if off+int(size) > len(data) {
+			return Package2{}, fmt.Errorf("package2 section %d is out of range", i)
+		}
+
+		dec, err := package2Decrypt(key, h.SectionCtrs[i][:], data[off:off+int(size)])
+		if err != nil {
+			return Package2{}, err
+		}
+
+		sections = append(sections, dec)
+		off += int(size)
+	}
+
+	p.Kernel, p.INI1 = sections[0], sections[1]
+
+	// since 8.0.0 the INI1 is embedded in the kernel
+	if len(p.INI1) == 0 {
+		p.INI1 = findINI1(p.Kernel)
+		if p.INI1 == nil {
+			return Package2{}, errors.New("no ini1 found in package2")
+		}
+	}
+
+	kips, err := ParseINI1(p.INI1)
+	if err != nil {
+		return Package2{}, err
+	}
+	p.KIPs = kips
+
+	return p, nil
+}
+
+// findINI1 searches the kernel for an INI1 whose size fits.
+func findINI1(kernel []byte) []byte {
+	for off := 0; ; {
+		i := bytes.Index(kernel[off:], []byte("INI1"))
+		if i < 0 {
+			return nil
+		}
+		off += i
+
+		if off+0x10 <= len(kernel) {
+			size := int(binary.LittleEndian.Uint32(kernel[off+4:]))
+			if size >= 0x10 && off+size <= len(kernel) {
+				return kernel[off : off+size]
+			}
+		}
+		off += 4
+	}
+}
+
+func package2Decrypt(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(out, data)
+
+	return out, nil
+}
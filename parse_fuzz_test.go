@@ -0,0 +1,376 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The parsers read files from the cdn, the sd card and other tools, so they
+// have to fail cleanly on any input. Every fuzz target is seeded with a
+// valid file cut off at every length, which go test runs without -fuzz.
+
+// addTruncations adds every prefix of data as a seed, stepping by step.
+func addTruncations(f *testing.F, data []byte, step int) {
+	for n := 0; n < len(data); n += step {
+		f.Add(data[:n])
+	}
+	f.Add(data)
+}
+
+func writeLE(b *bytes.Buffer, v interface{}) {
+	err := binary.Write(b, binary.LittleEndian, v)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func pfs0Seed() []byte {
+	b := &bytes.Buffer{}
+	w := NewNSPWriter(b)
+	w.Add("0123456789abcdef0123456789abcdef.nca", 6, strings.NewReader("nca..."))
+	w.Add("0123456789abcdef0123456789abcdef.tik", 3, strings.NewReader("tik"))
+	w.Close()
+
+	return b.Bytes()
+}
+
+func hfs0Seed() []byte {
+	b := &bytes.Buffer{}
+	w := NewHFS0Writer(b)
+	w.Add("update", 0x300, bytes.NewReader(bytes.Repeat([]byte{1}, 0x300)))
+	w.Add("normal", 4, strings.NewReader("data"))
+	w.Close()
+
+	return b.Bytes()
+}
+
+// romfsSeed has a root directory with one file and one empty directory.
+func romfsSeed() []byte {
+	dirs := &bytes.Buffer{}
+	writeLE(dirs, romfsDirEntry{Parent: 0, Sibling: romfsNone, Child: 0x18, File: 0, Hash: romfsNone})
+	writeLE(dirs, romfsDirEntry{Parent: 0, Sibling: romfsNone, Child: romfsNone, File: romfsNone, Hash: romfsNone, NameSize: 3})
+	dirs.WriteString("sub\x00")
+
+	files := &bytes.Buffer{}
+	writeLE(files, romfsFileEntry{Parent: 0, Sibling: romfsNone, DataSize: 5, Hash: romfsNone, NameSize: 5})
+	files.WriteString("a.txt\x00\x00\x00")
+
+	h := romfsHeader{HeaderSize: 0x50}
+	h.DirMetaTableOffset = 0x50
+	h.DirMetaTableSize = uint64(dirs.Len())
+	h.FileMetaTableOffset = h.DirMetaTableOffset + h.DirMetaTableSize
+	h.FileMetaTableSize = uint64(files.Len())
+	h.DataOffset = h.FileMetaTableOffset + h.FileMetaTableSize
+
+	b := &bytes.Buffer{}
+	writeLE(b, h)
+	b.Write(dirs.Bytes())
+	b.Write(files.Bytes())
+	b.WriteString("hello")
+
+	return b.Bytes()
+}
+
+// nsoSeed has an lz4 compressed and hashed text segment and plain ro and
+// data segments.
+func nsoSeed() []byte {
+	text := []byte("\x50hello")
+	hash := sha256.Sum256([]byte("hello"))
+
+	h := nsoHeader{Magic: [4]byte{'N', 'S', 'O', '0'}, Flags: 1 | 1<<3}
+	off := uint32(binary.Size(h))
+	h.Text = nsoSegmentHeader{off, 0, 5}
+	h.RO = nsoSegmentHeader{off + uint32(len(text)), 0x1000, 4}
+	h.Data = nsoSegmentHeader{off + uint32(len(text)) + 4, 0x2000, 4}
+	h.FileSizes = [3]uint32{uint32(len(text)), 4, 4}
+	h.Hashes[0] = hash
+	h.BSSSize = 0x100
+
+	b := &bytes.Buffer{}
+	writeLE(b, h)
+	b.Write(text)
+	b.WriteString("ro..data")
+
+	return b.Bytes()
+}
+
+func kipSeed() []byte {
+	h := kipHeader{Magic: [4]byte{'K', 'I', 'P', '1'}, ProgramID: 0x0100000000000000}
+	copy(h.Name[:], "FS")
+	for i := 0; i < 3; i++ {
+		h.Segments[i] = kipSegmentHeader{MemoryOffset: uint32(i) * 0x1000, DecompressedSize: 8, CompressedSize: 4}
+	}
+	for i := range h.Capabilities {
+		h.Capabilities[i] = 0xFF
+	}
+
+	b := &bytes.Buffer{}
+	writeLE(b, h)
+	b.WriteString("textro..data")
+
+	return b.Bytes()
+}
+
+func ini1Seed() []byte {
+	kip := kipSeed()
+
+	b := &bytes.Buffer{}
+	writeLE(b, ini1Header{Magic: [4]byte{'I', 'N', 'I', '1'}, Size: uint32(0x10 + len(kip)), ProcessCount: 1})
+	b.Write(kip)
+
+	return b.Bytes()
+}
+
+var package2SeedKey = bytes.Repeat([]byte{0x24}, 0x10)
+
+func package2Keys() *Keyset {
+	k := NewKeyset()
+	k.ReadKeys(strings.NewReader("package2_key_00 = " + strings.Repeat("24", 0x10) + "\n"))
+
+	return k
+}
+
+// package2Seed has an empty kernel and an INI1 section, encrypted with
+// package2SeedKey.
+func package2Seed() []byte {
+	ini1 := ini1Seed()
+
+	h := package2Header{Magic: [4]byte{'P', 'K', '2', '1'}, VersionMax: 0x10}
+	h.HeaderCtr[0] = 1
+	h.SectionCtrs[1][0] = 2
+	h.SectionSizes[1] = uint32(len(ini1))
+
+	header := &bytes.Buffer{}
+	writeLE(header, h)
+
+	crypt := func(iv, data []byte) []byte {
+		block, _ := aes.NewCipher(package2SeedKey)
+		out := make([]byte, len(data))
+		cipher.NewCTR(block, iv).XORKeyStream(out, data)
+		return out
+	}
+
+	enc := crypt(h.HeaderCtr[:], header.Bytes())
+	copy(enc, h.HeaderCtr[:])
+
+	data := make([]byte, package2HeaderOffset)
+	data = append(data, enc...)
+	data = append(data, crypt(h.SectionCtrs[1][:], ini1)...)
+
+	return data
+}
+
+func npdmSeed() []byte {
+	sac := []byte("\x06fsp-srv")
+	kac := []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+	aci0 := npdmACI0{Magic: [4]byte{'A', 'C', 'I', '0'}, ProgramID: 0x0100000000010000}
+	aci0Size := uint32(binary.Size(aci0))
+	aci0.SACOffset, aci0.SACSize = aci0Size, uint32(len(sac))
+	aci0.KACOffset, aci0.KACSize = aci0Size+uint32(len(sac)), uint32(len(kac))
+
+	m := npdmMeta{Magic: [4]byte{'M', 'E', 'T', 'A'}, Flags: 1, MainThreadStackSize: 0x100000}
+	copy(m.Name[:], "Application")
+	m.ACI0Offset = uint32(binary.Size(m))
+	m.ACI0Size = aci0Size + uint32(len(sac)+len(kac))
+
+	acid := npdmACID{Magic: [4]byte{'A', 'C', 'I', 'D'}}
+	m.ACIDOffset = m.ACI0Offset + m.ACI0Size
+	m.ACIDSize = uint32(binary.Size(acid))
+
+	b := &bytes.Buffer{}
+	writeLE(b, m)
+	writeLE(b, aci0)
+	b.Write(sac)
+	b.Write(kac)
+	writeLE(b, acid)
+
+	return b.Bytes()
+}
+
+// nroSeed has an asset section with an icon.
+func nroSeed() []byte {
+	h := nroHeader{Magic: [4]byte{'N', 'R', 'O', '0'}}
+	size := uint32(binary.Size(h)) + 0x10
+	h.Size = size
+	h.Segments = [3]NROSegment{{0, 0x80}, {0x80, 8}, {0x88, 8}}
+
+	b := &bytes.Buffer{}
+	writeLE(b, h)
+	b.Write(make([]byte, 0x10))
+
+	a := nroAssetHeader{Magic: [4]byte{'A', 'S', 'E', 'T'}}
+	a.Icon = nroAssetSection{uint64(binary.Size(a)), 4}
+	writeLE(b, a)
+	b.WriteString("icon")
+
+	return b.Bytes()
+}
+
+func nczSeed(t testing.TB) []byte {
+	keys := NewKeyset()
+	keys.HeaderKey = bytes.Repeat([]byte{1}, 0x20)
+	keys.KeyAreaKeyApplication[0] = bytes.Repeat([]byte{2}, 0x10)
+
+	b := NewNCABuilder(0, 0, keys)
+	romfs := bytes.Repeat([]byte("romfs data "), 0x800)
+	b.AddRomFS(bytes.NewReader(romfs), int64(len(romfs)))
+
+	nca := &bytes.Buffer{}
+	_, err := b.WriteTo(nca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ncz := &bytes.Buffer{}
+	err = CompressNCA(bytes.NewReader(nca.Bytes()), int64(nca.Len()), ncz, keys, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ncz.Bytes()
+}
+
+func TestParserSeeds(t *testing.T) {
+	if _, err := NewNSPReader(bytes.NewReader(pfs0Seed())); err != nil {
+		t.Errorf("pfs0: %v", err)
+	}
+
+	if h, err := NewHFS0Reader(bytes.NewReader(hfs0Seed())); err != nil {
+		t.Errorf("hfs0: %v", err)
+	} else if res, err := h.Verify(); err != nil || len(res) != 2 || !res[0].OK || !res[1].OK {
+		t.Errorf("hfs0 verify: %v %v", res, err)
+	}
+
+	if files, err := ReadRomFS(bytes.NewReader(romfsSeed())); err != nil || len(files) != 1 || files[0].Path != "/a.txt" {
+		t.Errorf("romfs: %v %v", files, err)
+	}
+
+	if n, err := ParseNSO(nsoSeed()); err != nil || string(n.Text.Data) != "hello" {
+		t.Errorf("nso: %v", err)
+	}
+
+	if k, err := ParseKIP(kipSeed()); err != nil || k.Name != "FS" || len(k.Data.Data) != 8 {
+		t.Errorf("kip: %v", err)
+	}
+
+	if kips, err := ParseINI1(ini1Seed()); err != nil || len(kips) != 1 {
+		t.Errorf("ini1: %v", err)
+	}
+
+	if p, err := ParsePackage2(package2Seed(), package2Keys()); err != nil || len(p.KIPs) != 1 {
+		t.Errorf("package2: %v", err)
+	}
+
+	if n, err := ParseNPDM(npdmSeed()); err != nil || n.Name != "Application" || len(n.Services) != 1 {
+		t.Errorf("npdm: %v %v", n.Services, err)
+	}
+
+	if n, err := ParseNRO(bytes.NewReader(nroSeed())); err != nil || string(n.Icon) != "icon" {
+		t.Errorf("nro: %v", err)
+	}
+
+	if err := DecompressNCZ(bytes.NewReader(nczSeed(t)), ioutil.Discard); err != nil {
+		t.Errorf("ncz: %v", err)
+	}
+}
+
+func FuzzReadPFS0(f *testing.F) {
+	addTruncations(f, pfs0Seed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		nsp, err := NewNSPReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		for _, v := range nsp.Entries {
+			r, err := nsp.Open(v.Name)
+			if err == nil {
+				ioutil.ReadAll(r)
+			}
+		}
+	})
+}
+
+func FuzzReadHFS0(f *testing.F) {
+	addTruncations(f, hfs0Seed(), 7)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := NewHFS0Reader(bytes.NewReader(data))
+		if err == nil {
+			h.Verify()
+		}
+	})
+}
+
+func FuzzReadRomFS(f *testing.F) {
+	addTruncations(f, romfsSeed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadRomFS(bytes.NewReader(data))
+	})
+}
+
+func FuzzParseNSO(f *testing.F) {
+	addTruncations(f, nsoSeed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseNSO(data)
+	})
+}
+
+func FuzzParseKIP(f *testing.F) {
+	addTruncations(f, kipSeed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseKIP(data)
+	})
+}
+
+func FuzzParseINI1(f *testing.F) {
+	addTruncations(f, ini1Seed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseINI1(data)
+	})
+}
+
+func FuzzParsePackage2(f *testing.F) {
+	addTruncations(f, package2Seed(), 1)
+	keys := package2Keys()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParsePackage2(data, keys)
+	})
+}
+
+func FuzzParseNPDM(f *testing.F) {
+	addTruncations(f, npdmSeed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseNPDM(data)
+	})
+}
+
+func FuzzParseNRO(f *testing.F) {
+	addTruncations(f, nroSeed(), 1)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseNRO(bytes.NewReader(data))
+	})
+}
+
+func FuzzDecompressNCZ(f *testing.F) {
+	addTruncations(f, nczSeed(f), 0x61)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecompressNCZ(bytes.NewReader(data), ioutil.Discard)
+	})
+}
@@ -0,0 +1,89 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type PFS0Entry struct {
+	Name   string
+	Offset int64
+	Size   int64
+}
+
+type pfs0Header struct {
+	Magic           [4]byte
+	FileCount       uint32
+	StringTableSize uint32
+	_               uint32
+}
+
+type pfs0FileEntry struct {
+	Offset           uint64
+	Size             uint64
+	StringTableIndex uint32
+	_                uint32
+}
+
+func readPFS0(r io.ReaderAt) ([]PFS0Entry, error) {
+	h := pfs0Header{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x10), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(h.Magic[:]) != "PFS0" {
+		return nil, errors.New("invalid pfs0 magic")
+	}
+
+	stringTableOffset := 0x10 + int64(h.FileCount)*0x18
+	dataOffset := stringTableOffset + int64(h.StringTableSize)
+
+	tables, err := readSection(r, 0x10, dataOffset-0x10)
+	if err != nil {
+		return nil, fmt.Errorf("pfs0 header: %w", err)
+	}
+
+	fes := make([]pfs0FileEntry, h.FileCount)
+	err = binary.Read(bytes.NewReader(tables), binary.LittleEndian, fes)
+	if err != nil {
+		return nil, err
+	}
+
+	stringTable := tables[stringTableOffset-0x10:]
+
+	entries := []PFS0Entry{}
+	for _, v := range fes {
+		if v.StringTableIndex >= h.StringTableSize {
+			return nil, errors.New("pfs0 string table index out of range")
+		}
+
+		name := stringTable[v.StringTableIndex:]
+		if i := bytes.IndexByte(name, 0); i != -1 {
+			name = name[:i]
+		}
+
+		entries = append(entries, PFS0Entry{
+			string(name),
+			dataOffset + int64(v.Offset),
+			int64(v.Size),
+		})
+	}
+
+	return entries, nil
+}
+
+// pfs0DataOffset returns where the data of a PFS0 starts, the size of its
+// header including the padded string table.
+func pfs0DataOffset(r io.ReaderAt) (int64, error) {
+	h := pfs0Header{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x10), binary.LittleEndian, &h)
+	if err != nil {
+		return 0, err
+	}
+
+	return 0x10 + int64(h.FileCount)*0x18 + int64(h.StringTableSize), nil
+}
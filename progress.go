@@ -0,0 +1,80 @@
+package libhac
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressFunc receives the bytes written so far, the expected total (-1 if
+// unknown) and the average speed of the transfer in bytes per second.
+type ProgressFunc func(done, total int64, speed float64)
+
+const progressInterval = 200 * time.Millisecond
+
+type progressTracker struct {
+	mu    sync.Mutex
+	fn    ProgressFunc
+	done  int64
+	total int64
+	base  int64
+	start time.Time
+	last  time.Time
+}
+
+func newProgressTracker(fn ProgressFunc, done, total int64) *progressTracker {
+	now := time.Now()
+
+	return &progressTracker{fn: fn, done: done, total: total, base: done, start: now, last: now}
+}
+
+func (p *progressTracker) add(n int64) {
+	if p == nil || p.fn == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval && p.done != p.total {
+		return
+	}
+	p.last = now
+
+	p.report(now)
+}
+
+func (p *progressTracker) finish() {
+	if p == nil || p.fn == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.report(time.Now())
+}
+
+func (p *progressTracker) report(now time.Time) {
+	var speed float64
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		speed = float64(p.done-p.base) / elapsed
+	}
+
+	p.fn(p.done, p.total, speed)
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progressTracker
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(int64(n))
+
+	return n, err
+}
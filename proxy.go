@@ -0,0 +1,76 @@
+package libhac
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+// ProxyURL routes every request through the given proxy, http://, https://
+// and socks5:// urls are supported.
+func ProxyURL(rawurl string) (ProxyFunc, error) {
+	u, err := parseProxyURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// PerHostProxy picks a proxy by request host, hosts may be given exactly or as
+// a ".suffix" to match subdomains. Hosts without a match use fallback, which
+// may be empty to connect directly.
+func PerHostProxy(hosts map[string]string, fallback string) (ProxyFunc, error) {
+	proxies := map[string]*url.URL{}
+	for host, rawurl := range hosts {
+		u, err := parseProxyURL(rawurl)
+		if err != nil {
+			return nil, err
+		}
+
+		proxies[strings.ToLower(host)] = u
+	}
+
+	var def *url.URL
+	if fallback != "" {
+		u, err := parseProxyURL(fallback)
+		if err != nil {
+			return nil, err
+		}
+
+		def = u
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		if u, ok := proxies[host]; ok {
+			return u, nil
+		}
+
+		for k, u := range proxies {
+			if strings.HasPrefix(k, ".") && strings.HasSuffix(host, k) {
+				return u, nil
+			}
+		}
+
+		return def, nil
+	}, nil
+}
+
+func parseProxyURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	return u, nil
+}
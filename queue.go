@@ -0,0 +1,189 @@
+package libhac
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DownloadJob downloads either URL or Entry to Path.
+type DownloadJob struct {
+	URL   string        `json:"url,omitempty"`
+	Entry *ContentEntry `json:"entry,omitempty"`
+	Path  string        `json:"path"`
+}
+
+type DownloadResult struct {
+	Job DownloadJob
+	Err error
+}
+
+// DownloadQueue runs download jobs on a fixed number of workers. Progress
+// is reported for all jobs together.
+type DownloadQueue struct {
+	Workers  int
+	Progress ProgressFunc
+
+	client  *HacClient
+	jobs    []DownloadJob
+	ids     []int
+	journal *downloadJournal
+
+	mu    sync.Mutex
+	done  []int64
+	total []int64
+	start time.Time
+	last  time.Time
+}
+
+func NewDownloadQueue(c *HacClient, workers int) *DownloadQueue {
+	return &DownloadQueue{Workers: workers, client: c}
+}
+
+func (q *DownloadQueue) Add(job DownloadJob) {
+	id := 0
+	if q.journal != nil {
+		id = q.journal.add(job)
+	}
+
+	q.jobs = append(q.jobs, job)
+	q.ids = append(q.ids, id)
+}
+
+func (q *DownloadQueue) AddURL(url, path string) {
+	q.Add(DownloadJob{URL: url, Path: path})
+}
+
+func (q *DownloadQueue) AddContentEntry(ce ContentEntry, path string) {
+	q.Add(DownloadJob{Entry: &ce, Path: path})
+}
+
+// Run downloads every job added so far and returns their results in the
+// order they were added. Once ctx is canceled the remaining jobs fail with
+// its error.
+func (q *DownloadQueue) Run(ctx context.Context) []DownloadResult {
+	jobs, ids := q.jobs, q.ids
+	q.jobs, q.ids = nil, nil
+
+	q.mu.Lock()
+	q.done = make([]int64, len(jobs))
+	q.total = make([]int64, len(jobs))
+	for i, v := range jobs {
+		q.total[i] = -1
+		if v.Entry != nil {
+			q.total[i] = v.Entry.Size
+		}
+	}
+	q.start = time.Now()
+	q.mu.Unlock()
+
+	workers := q.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]DownloadResult, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = DownloadResult{jobs[i], q.run(ctx, i, ids[i], jobs[i])}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	q.report(true)
+
+	return results
+}
+
+func (q *DownloadQueue) run(ctx context.Context, i, id int, job DownloadJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// every job gets its own copy of the client to report progress to
+	c := *q.client
+	c.Progress = func(done, total int64, speed float64) {
+		q.mu.Lock()
+		q.done[i], q.total[i] = done, total
+		q.mu.Unlock()
+
+		q.report(false)
+	}
+
+	if q.journal != nil {
+		// jobs from the journal continue their partial files
+		c.Resume = true
+	}
+
+	var err error
+	if job.Entry != nil {
+		err = c.DownloadContentEntryContext(ctx, *job.Entry, job.Path)
+	} else {
+		err = c.DownloadContext(ctx, job.URL, job.Path)
+	}
+
+	switch {
+	case err == nil:
+		q.journal.write(journalRecord{Op: journalDone, ID: id})
+	case ctx.Err() == nil:
+		q.journal.write(journalRecord{Op: journalFailed, ID: id, Error: err.Error()})
+	}
+
+	return err
+}
+
+// Close closes the journal of queues opened with OpenDownloadQueue, returning
+// the first error writing it.
+func (q *DownloadQueue) Close() error {
+	if q.journal == nil {
+		return nil
+	}
+
+	return q.journal.close()
+}
+
+// report calls Progress with the sum of all jobs, the total is -1 while
+// the size of any job is unknown.
+func (q *DownloadQueue) report(force bool) {
+	if q.Progress == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(q.last) < progressInterval {
+		return
+	}
+	q.last = now
+
+	var done, total int64
+	for i := range q.done {
+		done += q.done[i]
+		if total >= 0 && q.total[i] >= 0 {
+			total += q.total[i]
+		} else {
+			total = -1
+		}
+	}
+
+	var speed float64
+	if elapsed := now.Sub(q.start).Seconds(); elapsed > 0 {
+		speed = float64(done) / elapsed
+	}
+
+	q.Progress(done, total, speed)
+}
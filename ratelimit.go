@@ -0,0 +1,140 @@
+package libhac
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimit zero values mean no limit.
+type RateLimit struct {
+	RequestsPerSecond float64
+	// MaxConnections limits the requests in flight, a request counts until
+	// its response body is closed.
+	MaxConnections int
+}
+
+// RateLimiter limits the requests of every client sharing it, both in total
+// and per host, so metadata probes don't trip the cdn's abuse protection.
+type RateLimiter struct {
+	global  RateLimit
+	perHost RateLimit
+
+	mu    sync.Mutex
+	all   *limiterState
+	hosts map[string]*limiterState
+}
+
+type limiterState struct {
+	next  time.Time
+	conns chan struct{}
+}
+
+func NewRateLimiter(global, perHost RateLimit) *RateLimiter {
+	return &RateLimiter{
+		global:  global,
+		perHost: perHost,
+		all:     newLimiterState(global),
+		hosts:   map[string]*limiterState{},
+	}
+}
+
+func newLimiterState(r RateLimit) *limiterState {
+	s := &limiterState{}
+	if r.MaxConnections > 0 {
+		s.conns = make(chan struct{}, r.MaxConnections)
+	}
+
+	return s
+}
+
+// acquire waits until a request to host is allowed, the returned function
+// has to be called once it's done.
+func (l *RateLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = newLimiterState(l.perHost)
+		l.hosts[host] = h
+	}
+	l.mu.Unlock()
+
+	release := func() {}
+	for _, v := range []*limiterState{l.all, h} {
+		if v.conns == nil {
+			continue
+		}
+
+		select {
+		case v.conns <- struct{}{}:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+
+		prev, conns := release, v.conns
+		release = func() {
+			<-conns
+			prev()
+		}
+	}
+
+	err := l.wait(ctx, l.all, l.global.RequestsPerSecond)
+	if err == nil {
+		err = l.wait(ctx, h, l.perHost.RequestsPerSecond)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(release) }, nil
+}
+
+// wait reserves the next free slot of s and sleeps until it.
+func (l *RateLimiter) wait(ctx context.Context, s *limiterState, rate float64) error {
+	if rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+	at := s.next
+	s.next = s.next.Add(time.Duration(float64(time.Second) / rate))
+	l.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// releasingBody gives up its connection slot once closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
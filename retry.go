@@ -0,0 +1,94 @@
+package libhac
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// RetryStatusCodes lists the response codes worth retrying, if empty any
+	// 5xx response is retried.
+	RetryStatusCodes []int
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+func (r RetryPolicy) attempts() int {
+	if r.MaxAttempts < 1 {
+		return 1
+	}
+
+	return r.MaxAttempts
+}
+
+func (r RetryPolicy) retryStatus(code int) bool {
+	if len(r.RetryStatusCodes) == 0 {
+		return code >= 500
+	}
+
+	for _, v := range r.RetryStatusCodes {
+		if v == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r RetryPolicy) wait(ctx context.Context, attempt int) error {
+	d := r.Backoff << uint(attempt)
+	overflow := attempt >= 63 || d < r.Backoff
+	if r.MaxBackoff > 0 && (d > r.MaxBackoff || overflow) {
+		d = r.MaxBackoff
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retryableError reports if a failed request could succeed when sent again,
+// timeouts and broken connections can but certificate errors never will.
+func retryableError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// transferError marks a failure while reading a response body, which can be
+// retried by resuming from the bytes already written.
+type transferError struct {
+	err error
+}
+
+func (t *transferError) Error() string {
+	return t.err.Error()
+}
+
+func (t *transferError) Unwrap() error {
+	return t.err
+}
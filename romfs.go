@@ -0,0 +1,207 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+type romfsHeader struct {
+	HeaderSize          uint64
+	DirHashTableOffset  uint64
+	DirHashTableSize    uint64
+	DirMetaTableOffset  uint64
+	DirMetaTableSize    uint64
+	FileHashTableOffset uint64
+	FileHashTableSize   uint64
+	FileMetaTableOffset uint64
+	FileMetaTableSize   uint64
+	DataOffset          uint64
+}
+
+type romfsDirEntry struct {
+	Parent   uint32
+	Sibling  uint32
+	Child    uint32
+	File     uint32
+	Hash     uint32
+	NameSize uint32
+}
+
+type romfsFileEntry struct {
+	Parent     uint32
+	Sibling    uint32
+	DataOffset uint64
+	DataSize   uint64
+	Hash       uint32
+	NameSize   uint32
+}
+
+const romfsNone = 0xFFFFFFFF
+
+// RomFSFile is a file in a RomFS image, Path is absolute and uses forward
+// slashes.
+type RomFSFile struct {
+	Path   string
+	Offset int64
+	Size   int64
+}
+
+// ReadRomFS walks the directory tree of a decrypted RomFS image and returns
+// all files with their absolute offsets in r.
+func ReadRomFS(r io.ReaderAt) ([]RomFSFile, error) {
+	h := romfsHeader{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x50), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.HeaderSize != 0x50 {
+		return nil, errors.New("invalid romfs header size")
+	}
+
+	dirs, err := readSection(r, int64(h.DirMetaTableOffset), int64(h.DirMetaTableSize))
+	if err != nil {
+		return nil, fmt.Errorf("romfs directory table: %w", err)
+	}
+
+	files, err := readSection(r, int64(h.FileMetaTableOffset), int64(h.FileMetaTableSize))
+	if err != nil {
+		return nil, fmt.Errorf("romfs file table: %w", err)
+	}
+
+	out := []RomFSFile{}
+	seen := map[uint32]bool{}
+	seenFiles := map[uint32]bool{}
+
+	var walk func(off uint32, dir string) error
+	walk = func(off uint32, dir string) error {
+		if seen[off] {
+			return errors.New("romfs directory loop")
+		}
+		seen[off] = true
+
+		d, name, err := readRomFSDir(dirs, off)
+		if err != nil {
+			return err
+		}
+		dir = path.Join(dir, name)
+
+		for f := d.File; f != romfsNone; {
+			if seenFiles[f] {
+				return errors.New("romfs file loop")
+			}
+			seenFiles[f] = true
+
+			fe, name, err := readRomFSFile(files, f)
+			if err != nil {
+				return err
+			}
+
+			out = append(out, RomFSFile{
+				path.Join(dir, name),
+				int64(h.DataOffset + fe.DataOffset),
+				int64(fe.DataSize),
+			})
+			f = fe.Sibling
+		}
+
+		for c := d.Child; c != romfsNone; {
+			err = walk(c, dir)
+			if err != nil {
+				return err
+			}
+
+			cd, _, err := readRomFSDir(dirs, c)
+			if err != nil {
+				return err
+			}
+			c = cd.Sibling
+		}
+
+		return nil
+	}
+
+	err = walk(0, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ExtractRomFS writes all files of a decrypted RomFS image to out, keeping
+// the directory tree.
+func ExtractRomFS(r io.ReaderAt, out string) error {
+	files, err := ReadRomFS(r)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range files {
+		// paths are rooted so they can't walk out of out
+		p := filepath.Join(out, filepath.FromSlash(v.Path))
+
+		err = os.MkdirAll(filepath.Dir(p), 0700)
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(p, io.NewSectionReader(r, v.Offset, v.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readRomFSDir(table []byte, off uint32) (romfsDirEntry, string, error) {
+	e := romfsDirEntry{}
+	err := readRomFSEntry(table, off, &e)
+	if err != nil {
+		return e, "", err
+	}
+
+	name, err := romfsName(table, int64(off)+0x18, e.NameSize)
+
+	return e, name, err
+}
+
+func readRomFSFile(table []byte, off uint32) (romfsFileEntry, string, error) {
+	e := romfsFileEntry{}
+	err := readRomFSEntry(table, off, &e)
+	if err != nil {
+		return e, "", err
+	}
+
+	name, err := romfsName(table, int64(off)+0x20, e.NameSize)
+
+	return e, name, err
+}
+
+func readRomFSEntry(table []byte, off uint32, e interface{}) error {
+	if int64(off)+int64(binary.Size(e)) > int64(len(table)) {
+		return errors.New("romfs entry out of range")
+	}
+
+	return binary.Read(bytes.NewReader(table[off:]), binary.LittleEndian, e)
+}
+
+func romfsName(table []byte, off int64, size uint32) (string, error) {
+	if off+int64(size) > int64(len(table)) {
+		return "", errors.New("romfs entry name out of range")
+	}
+
+	return string(table[off : off+int64(size)]), nil
+}
@@ -0,0 +1,258 @@
+// Package server exposes a HacClient over a small REST API so downloads can
+// be driven without linking Go code.
+//
+//	POST /downloads                  {"title_id": "...", "version": 0}
+//	GET  /downloads                  all downloads
+//	GET  /downloads/{id}             a single download
+//	GET  /downloads/{id}/progress    progress of a download
+//	POST /downloads/{id}/cancel      cancel a running download
+//	GET  /titles/{tid}/versions      versions available for a title
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakibaki/libhac"
+)
+
+const (
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateFailed   = "failed"
+	StateCanceled = "canceled"
+)
+
+type Server struct {
+	// Dir is where titles are downloaded to, each into its own directory.
+	Dir string
+
+	client *libhac.HacClient
+	mu     sync.Mutex
+	jobs   map[string]*job
+	nextID int
+}
+
+type Progress struct {
+	Done  int64   `json:"done"`
+	Total int64   `json:"total"`
+	Speed float64 `json:"speed"`
+}
+
+type Download struct {
+	ID       string    `json:"id"`
+	TitleID  string    `json:"title_id"`
+	Version  int       `json:"version"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Progress Progress  `json:"progress"`
+	Files    []string  `json:"files,omitempty"`
+}
+
+type job struct {
+	Download
+	cancel context.CancelFunc
+}
+
+type downloadRequest struct {
+	TitleID string `json:"title_id"`
+	Version int    `json:"version"`
+}
+
+type versionsResponse struct {
+	TitleID  string `json:"title_id"`
+	Latest   int    `json:"latest"`
+	Versions []int  `json:"versions"`
+}
+
+func New(c *libhac.HacClient, dir string) *Server {
+	return &Server{
+		Dir:    dir,
+		client: c,
+		jobs:   map[string]*job{},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "downloads" && r.Method == "POST":
+		s.startDownload(w, r)
+	case len(parts) == 1 && parts[0] == "downloads" && r.Method == "GET":
+		s.listDownloads(w)
+	case len(parts) == 2 && parts[0] == "downloads" && r.Method == "GET":
+		s.getDownload(w, parts[1], false)
+	case len(parts) == 3 && parts[0] == "downloads" && parts[2] == "progress" && r.Method == "GET":
+		s.getDownload(w, parts[1], true)
+	case len(parts) == 3 && parts[0] == "downloads" && parts[2] == "cancel" && r.Method == "POST":
+		s.cancelDownload(w, parts[1])
+	case len(parts) == 3 && parts[0] == "titles" && parts[2] == "versions" && r.Method == "GET":
+		s.getVersions(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) startDownload(w http.ResponseWriter, r *http.Request) {
+	req := downloadRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err = strconv.ParseUint(req.TitleID, 16, 64); err != nil || len(req.TitleID) != 16 {
+		writeError(w, http.StatusBadRequest, "invalid title id")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.nextID++
+	j := &job{
+		Download: Download{
+			ID:      strconv.Itoa(s.nextID),
+			TitleID: strings.ToLower(req.TitleID),
+			Version: req.Version,
+			State:   StateRunning,
+			Started: time.Now(),
+		},
+		cancel: cancel,
+	}
+	s.jobs[j.ID] = j
+	d := j.Download
+	s.mu.Unlock()
+
+	go s.run(ctx, j)
+
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+func (s *Server) run(ctx context.Context, j *job) {
+	defer j.cancel()
+
+	// every download gets its own copy of the client for the progress callback
+	c := *s.client
+	c.Progress = func(done, total int64, speed float64) {
+		s.mu.Lock()
+		j.Progress = Progress{done, total, speed}
+		s.mu.Unlock()
+	}
+
+	dest := filepath.Join(s.Dir, fmt.Sprintf("%s_v%d", j.TitleID, j.Version))
+	m, err := c.DownloadTitleContext(ctx, j.TitleID, j.Version, dest)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case ctx.Err() != nil:
+		j.State = StateCanceled
+	case err != nil:
+		j.State = StateFailed
+		j.Error = err.Error()
+	default:
+		j.State = StateDone
+		j.Files = m.Files
+	}
+}
+
+func (s *Server) listDownloads(w http.ResponseWriter) {
+	s.mu.Lock()
+	downloads := []Download{}
+	for _, v := range s.jobs {
+		downloads = append(downloads, v.Download)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].Started.Before(downloads[j].Started)
+	})
+
+	writeJSON(w, http.StatusOK, downloads)
+}
+
+func (s *Server) getDownload(w http.ResponseWriter, id string, progress bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	var d Download
+	if ok {
+		d = j.Download
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such download")
+		return
+	}
+
+	if progress {
+		writeJSON(w, http.StatusOK, d.Progress)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *Server) cancelDownload(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such download")
+		return
+	}
+
+	j.cancel()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getVersions(w http.ResponseWriter, r *http.Request, tid string) {
+	vl, err := s.client.GetVersionListContext(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	latest, err := vl.LatestVersion(tid)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	versions, err := libhac.VersionRange(0, latest)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	resp := versionsResponse{TitleID: strings.ToLower(tid), Latest: latest}
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, int(v))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -1,34 +1,55 @@
 package libhac
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 )
 
-func (c *HacClient) doShogunRequest(endpoint string) (response []byte, err error) {
-	resp, err := c.DoRequest("GET", fmt.Sprintf("https://bugyo.hac.lp1.eshop.nintendo.net/shogun/v1%s",
-		endpoint), []tls.Certificate{c.ShopCert}, true, false)
+func (c *HacClient) doShogunRequest(ctx context.Context, endpoint string) (response []byte, err error) {
+	resp, err := c.DoRequestContext(ctx, "GET", c.serviceURL(ServiceShogun, "/shogun/v1%s", endpoint), []tls.Certificate{c.ShopCert}, true, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	bytes, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(ServiceShogun, resp)
+	}
 
-	return bytes, nil
+	return ioutil.ReadAll(resp.Body)
 }
 
 func (c *HacClient) TestDauthToken() error {
-	resp, err := c.doShogunRequest("/contents/ids?shop_id=4&lang=en&country=US&type=title&title_ids=999")
-	if err != nil || string(resp) != "{\"id_pairs\":[]}" {
-		return errors.New("edge token is invalid!")
+	return c.TestDauthTokenContext(context.Background())
+}
+
+func (c *HacClient) TestDauthTokenContext(ctx context.Context) error {
+	resp, err := c.doShogunRequest(ctx, "/contents/ids?shop_id=4&lang=en&country=US&type=title&title_ids=999")
+	if errors.Is(err, ErrInvalidDauthToken) {
+		return ErrInvalidDauthToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if string(resp) != "{\"id_pairs\":[]}" {
+		return fmt.Errorf("unexpected shogun response %q", resp)
 	}
 
 	return nil
 }
 
 func (c *HacClient) GetNSID(tid string) (nsID int, err error) {
-	resp, err := c.doShogunRequest(fmt.Sprintf("/contents/ids?shop_id=4&lang=en&country=US&type=title&title_ids=%s",
+	return c.GetNSIDContext(context.Background(), tid)
+}
+
+func (c *HacClient) GetNSIDContext(ctx context.Context, tid string) (nsID int, err error) {
+	resp, err := c.doShogunRequest(ctx, fmt.Sprintf("/contents/ids?shop_id=4&lang=en&country=US&type=title&title_ids=%s",
 		tid))
 	if err != nil {
 		return -1, err
@@ -49,7 +70,11 @@ func (c *HacClient) GetNSID(tid string) (nsID int, err error) {
 }
 
 func (c *HacClient) GetTitleData(nsID int) (title Title, err error) {
-	resp, err := c.doShogunRequest(fmt.Sprintf("/titles/%d?shop_id=4&lang=en&country=US", nsID))
+	return c.GetTitleDataContext(context.Background(), nsID)
+}
+
+func (c *HacClient) GetTitleDataContext(ctx context.Context, nsID int) (title Title, err error) {
+	resp, err := c.doShogunRequest(ctx, fmt.Sprintf("/titles/%d?shop_id=4&lang=en&country=US", nsID))
 	if err != nil {
 		return Title{}, err
 	}
@@ -0,0 +1,179 @@
+package libhac
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Source is a place content can come from for a FallbackCDN.
+type Source struct {
+	Name string
+	CDN  CDNClient
+	// BaseURL replaces the scheme, host and base path of urls before
+	// they're passed to CDN, for secondary http mirrors serving the cdn's
+	// paths.
+	BaseURL string
+}
+
+// FallbackCDN tries its sources in order until one has what's requested,
+// e.g. the cdn, then a LocalMirror, then a secondary http mirror:
+//
+//	direct := client
+//	client.CDN = libhac.NewFallbackCDN(
+//		libhac.Source{Name: "cdn", CDN: &direct},
+//		libhac.Source{Name: "local", CDN: mirror},
+//		libhac.Source{Name: "lan", CDN: &direct, BaseURL: "http://mirror.lan"},
+//	)
+type FallbackCDN struct {
+	Sources []Source
+	Log     Logger
+
+	mu   sync.Mutex
+	used map[string]string
+}
+
+func NewFallbackCDN(sources ...Source) *FallbackCDN {
+	return &FallbackCDN{Sources: sources, used: map[string]string{}}
+}
+
+// SourceOf returns the name of the source that satisfied the last request
+// for url, or "" if none did.
+func (f *FallbackCDN) SourceOf(url string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.used[url]
+}
+
+func (f *FallbackCDN) record(url, source string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.used == nil {
+		f.used = map[string]string{}
+	}
+	f.used[url] = source
+
+	logf(f.Log, "%s from %s", url, source)
+}
+
+// rebase moves u onto the base url of s.
+func (s Source) rebase(u string) string {
+	if s.BaseURL == "" {
+		return u
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	return strings.TrimSuffix(s.BaseURL, "/") + parsed.RequestURI()
+}
+
+func (f *FallbackCDN) DoRequestContext(ctx context.Context, method, url string, certs []tls.Certificate,
+	sendDauthToken, sendEdgeToken bool) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+
+	for i, s := range f.Sources {
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		resp, err = s.CDN.DoRequestContext(ctx, method, s.rebase(url), certs, sendDauthToken, sendEdgeToken)
+		if ctx.Err() != nil {
+			return resp, err
+		}
+
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			f.record(url, s.Name)
+			return resp, nil
+		}
+
+		// the response of the last source is returned as is
+		if i == len(f.Sources)-1 {
+			break
+		}
+	}
+
+	if resp == nil && err == nil {
+		err = errors.New("no sources configured")
+	}
+
+	return resp, err
+}
+
+func (f *FallbackCDN) DownloadContext(ctx context.Context, url, path string) error {
+	return f.downloadVerified(ctx, url, path, nil)
+}
+
+// downloadVerified also moves on to the next source if the file from one
+// doesn't match hash.
+func (f *FallbackCDN) downloadVerified(ctx context.Context, url, path string, hash []byte) error {
+	errs := []string{}
+	for _, s := range f.Sources {
+		err := downloadVerified(ctx, s.CDN, s.rebase(url), path, hash)
+		if err == nil {
+			f.record(url, s.Name)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		logf(f.Log, "%s failed for %s: %v", s.Name, url, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", s.Name, err))
+	}
+
+	return fmt.Errorf("no source has %s (%s)", url, strings.Join(errs, "; "))
+}
+
+func (f *FallbackCDN) GetCNMTIDContext(ctx context.Context, tid string, ver int) (string, error) {
+	err := error(ErrTitleNotOnCDN)
+	for _, s := range f.Sources {
+		var id string
+		if s.BaseURL != "" {
+			id, err = f.getCNMTIDAt(ctx, s, tid, ver)
+		} else {
+			id, err = s.CDN.GetCNMTIDContext(ctx, tid, ver)
+		}
+
+		if err == nil {
+			return id, nil
+		}
+
+		if ctx.Err() != nil {
+			return "", err
+		}
+	}
+
+	return "", err
+}
+
+// getCNMTIDAt asks a rebased source for the content meta id directly, its
+// own GetCNMTIDContext would use its own base url.
+func (f *FallbackCDN) getCNMTIDAt(ctx context.Context, s Source, tid string, ver int) (string, error) {
+	resp, err := s.CDN.DoRequestContext(ctx, "HEAD", fmt.Sprintf("%s/t/a/%s/%d",
+		strings.TrimSuffix(s.BaseURL, "/"), tid, ver), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	id := resp.Header.Get("X-Nintendo-Content-ID")
+	if id == "" {
+		return "", fmt.Errorf("%s: %w", s.Name, ErrTitleNotOnCDN)
+	}
+
+	return id, nil
+}
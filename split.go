@@ -0,0 +1,112 @@
+package libhac
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// FAT32ChunkSize is the part size used by most homebrew, just below 4GiB.
+const FAT32ChunkSize = 0xFFFF0000
+
+var splitPartName = regexp.MustCompile(`^[0-9]{2,}$`)
+
+// SplitNSP writes path as numbered parts (00, 01, ...) into the directory out,
+// which by convention is named like the original file, e.g. "game.nsp".
+func SplitNSP(path, out string, chunkSize int64) error {
+	if chunkSize <= 0 {
+		chunkSize = FAT32ChunkSize
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(out, 0700)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		part, err := os.Create(filepath.Join(out, fmt.Sprintf("%02d", i)))
+		if err != nil {
+			return err
+		}
+
+		n, err := io.CopyN(part, in, chunkSize)
+		part.Close()
+
+		if err == io.EOF {
+			if n == 0 && i > 0 {
+				return os.Remove(filepath.Join(out, fmt.Sprintf("%02d", i)))
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// JoinNSP reassembles the parts in dir written by SplitNSP into out.
+func JoinNSP(dir, out string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	parts := []string{}
+	for _, v := range files {
+		if !v.IsDir() && splitPartName.MatchString(v.Name()) {
+			parts = append(parts, v.Name())
+		}
+	}
+
+	if len(parts) == 0 {
+		return errors.New("no split parts found")
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		if len(parts[i]) != len(parts[j]) {
+			return len(parts[i]) < len(parts[j])
+		}
+
+		return parts[i] < parts[j]
+	})
+
+	for i, v := range parts {
+		if v != fmt.Sprintf("%02d", i) {
+			return fmt.Errorf("split part %02d is missing", i)
+		}
+	}
+
+	o, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+
+	for _, v := range parts {
+		f, err := os.Open(filepath.Join(dir, v))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(o, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
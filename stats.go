@@ -0,0 +1,175 @@
+package libhac
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Stats struct {
+	Since           time.Time
+	Requests        int64
+	Errors          int64
+	Retries         int64
+	BytesDownloaded int64
+	// ActiveTransfers is the number of response bodies currently being read.
+	ActiveTransfers int64
+	Hosts           map[string]HostStats
+}
+
+type HostStats struct {
+	Requests int64
+	// Errors counts failed requests, error responses and broken transfers.
+	Errors int64
+	// Retries counts retried requests and resumed transfers.
+	Retries int64
+	Bytes   int64
+	// Duration is the time spent waiting for response bodies.
+	Duration time.Duration
+	// StatusCodes counts the responses by status code.
+	StatusCodes map[int]int64
+}
+
+// Throughput returns the average bytes per second of a single connection to
+// the host.
+func (h HostStats) Throughput() float64 {
+	if h.Duration <= 0 {
+		return 0
+	}
+
+	return float64(h.Bytes) / h.Duration.Seconds()
+}
+
+type statsCollector struct {
+	mu     sync.Mutex
+	since  time.Time
+	hosts  map[string]*HostStats
+	active int64
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{since: time.Now(), hosts: map[string]*HostStats{}}
+}
+
+// Stats returns the totals of every request made by the client and its
+// copies since it was created.
+func (c *HacClient) Stats() Stats {
+	s := Stats{Hosts: map[string]HostStats{}}
+	if c.stats == nil {
+		return s
+	}
+
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	s.Since = c.stats.since
+	s.ActiveTransfers = c.stats.active
+	for k, v := range c.stats.hosts {
+		s.Requests += v.Requests
+		s.Errors += v.Errors
+		s.Retries += v.Retries
+		s.BytesDownloaded += v.Bytes
+
+		h := *v
+		h.StatusCodes = map[int]int64{}
+		for code, n := range v.StatusCodes {
+			h.StatusCodes[code] = n
+		}
+		s.Hosts[k] = h
+	}
+
+	return s
+}
+
+func (s *statsCollector) add(host string, f func(h *HostStats)) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &HostStats{StatusCodes: map[int]int64{}}
+		s.hosts[host] = h
+	}
+	f(h)
+}
+
+// request records a finished request, wrapping the response body to count
+// what's read from it.
+func (s *statsCollector) request(req *http.Request, resp *http.Response, err error) {
+	host := req.URL.Host
+	s.add(host, func(h *HostStats) {
+		h.Requests++
+		if err != nil || resp.StatusCode >= 400 {
+			h.Errors++
+		}
+		if err == nil {
+			h.StatusCodes[resp.StatusCode]++
+		}
+	})
+
+	if s == nil || err != nil {
+		return
+	}
+
+	b := &countingBody{ReadCloser: resp.Body, s: s, host: host}
+	if req.Method != "HEAD" {
+		b.active = true
+		s.mu.Lock()
+		s.active++
+		s.mu.Unlock()
+	}
+	resp.Body = b
+}
+
+func (s *statsCollector) retry(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+
+	s.add(u.Host, func(h *HostStats) {
+		h.Retries++
+	})
+}
+
+type countingBody struct {
+	io.ReadCloser
+	s      *statsCollector
+	host   string
+	once   sync.Once
+	active bool
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(func() {
+		if b.active {
+			b.s.mu.Lock()
+			b.s.active--
+			b.s.mu.Unlock()
+		}
+	})
+
+	return b.ReadCloser.Close()
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	d := time.Since(start)
+
+	b.s.add(b.host, func(h *HostStats) {
+		h.Bytes += int64(n)
+		h.Duration += d
+		if err != nil && err != io.EOF {
+			h.Errors++
+		}
+	})
+
+	return n, err
+}
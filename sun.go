@@ -0,0 +1,72 @@
+package libhac
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+type SystemUpdateMeta struct {
+	Timestamp int64              `json:"timestamp"`
+	Metas     []SystemUpdateInfo `json:"system_update_metas"`
+}
+
+type SystemUpdateInfo struct {
+	ID      string `json:"title_id"`
+	Version int    `json:"title_version"`
+}
+
+func (c *HacClient) GetSystemUpdateMeta() (SystemUpdateMeta, error) {
+	return c.GetSystemUpdateMetaContext(context.Background())
+}
+
+func (c *HacClient) GetSystemUpdateMetaContext(ctx context.Context) (SystemUpdateMeta, error) {
+	resp, err := c.DoRequestContext(ctx, "GET",
+		c.serviceURL(ServiceSun, "/v1/system_update_meta?device_id=%s", c.DeviceID),
+		[]tls.Certificate{c.DeviceCert}, false, false)
+	if err != nil {
+		return SystemUpdateMeta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return SystemUpdateMeta{}, statusError(ServiceSun, resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return SystemUpdateMeta{}, err
+	}
+
+	m := SystemUpdateMeta{}
+
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return SystemUpdateMeta{}, err
+	}
+
+	return m, nil
+}
+
+func (c *HacClient) GetSystemCNMTID(tid string, ver int) (string, error) {
+	return c.GetSystemCNMTIDContext(context.Background(), tid, ver)
+}
+
+func (c *HacClient) GetSystemCNMTIDContext(ctx context.Context, tid string, ver int) (string, error) {
+	return c.getCNMTID(ctx, "s", tid, ver)
+}
+
+func (c *HacClient) DownloadSystemCNMT(cnmtID, out string) error {
+	return c.DownloadSystemCNMTContext(context.Background(), cnmtID, out)
+}
+
+func (c *HacClient) DownloadSystemCNMTContext(ctx context.Context, cnmtID, out string) error {
+	err := c.DownloadContext(ctx, c.serviceURL(ServiceAtum, "/c/s/%s", cnmtID), out)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,10 +1,11 @@
 package libhac
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
+	"net/http"
 )
 
 type SuperflyTitle struct {
@@ -14,17 +15,25 @@ type SuperflyTitle struct {
 }
 
 func (c *HacClient) GetSuperflyResponse(tid string) ([]SuperflyTitle, error) {
-	resp, err := c.DoRequest("GET", fmt.Sprintf("https://superfly.hac.lp1.d4c.nintendo.net/v1/a/%s/dv", tid),
+	return c.GetSuperflyResponseContext(context.Background(), tid)
+}
+
+func (c *HacClient) GetSuperflyResponseContext(ctx context.Context, tid string) ([]SuperflyTitle, error) {
+	resp, err := c.DoRequestContext(ctx, "GET", c.serviceURL(ServiceSuperfly, "/v1/a/%s/dv", tid),
 		[]tls.Certificate{c.DeviceCert}, false, true)
 	if err != nil {
 		return []SuperflyTitle{}, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return []SuperflyTitle{}, statusError(ServiceSuperfly, resp)
+	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return []SuperflyTitle{}, err
 	}
-	resp.Body.Close()
 
 	t := []SuperflyTitle{}
 
@@ -35,3 +44,42 @@ func (c *HacClient) GetSuperflyResponse(tid string) ([]SuperflyTitle, error) {
 
 	return t, nil
 }
+
+func (c *HacClient) GetSuperflyTitles(tid, titleType string) ([]SuperflyTitle, error) {
+	return c.GetSuperflyTitlesContext(context.Background(), tid, titleType)
+}
+
+func (c *HacClient) GetSuperflyTitlesContext(ctx context.Context, tid, titleType string) ([]SuperflyTitle, error) {
+	t, err := c.GetSuperflyResponseContext(ctx, tid)
+	if err != nil {
+		return []SuperflyTitle{}, err
+	}
+
+	return FilterSuperflyTitles(t, titleType), nil
+}
+
+func FilterSuperflyTitles(titles []SuperflyTitle, titleType string) []SuperflyTitle {
+	t := []SuperflyTitle{}
+	for _, v := range titles {
+		if v.Type == titleType {
+			t = append(t, v)
+		}
+	}
+
+	return t
+}
+
+// ListAddOnContent returns all published add-ons of the application baseTID
+// with their latest versions.
+func (c *HacClient) ListAddOnContent(baseTID string) ([]SuperflyTitle, error) {
+	return c.ListAddOnContentContext(context.Background(), baseTID)
+}
+
+func (c *HacClient) ListAddOnContentContext(ctx context.Context, baseTID string) ([]SuperflyTitle, error) {
+	t, err := c.GetSuperflyResponseContext(ctx, baseTID)
+	if err != nil {
+		return []SuperflyTitle{}, err
+	}
+
+	return FilterSuperflyTitles(t, "AddOnContent"), nil
+}
@@ -0,0 +1,68 @@
+package libhac
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type VersionList struct {
+	FormatVersion int                `json:"format_version"`
+	LastModified  int64              `json:"last_modified"`
+	Titles        []VersionListTitle `json:"titles"`
+}
+
+type VersionListTitle struct {
+	ID              string `json:"id"`
+	Version         int    `json:"version"`
+	RequiredVersion int    `json:"required_version"`
+}
+
+func (c *HacClient) GetVersionList() (VersionList, error) {
+	return c.GetVersionListContext(context.Background())
+}
+
+func (c *HacClient) GetVersionListContext(ctx context.Context) (VersionList, error) {
+	v := VersionList{}
+	if c.Cache.get("versionlist", &v) {
+		return v, nil
+	}
+
+	resp, err := c.DoRequestContext(ctx, "GET", c.serviceURL(ServiceTagaya, "/tagaya/hac_versionlist"),
+		[]tls.Certificate{c.DeviceCert}, false, false)
+	if err != nil {
+		return VersionList{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return VersionList{}, statusError(ServiceTagaya, resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return VersionList{}, err
+	}
+
+	err = json.Unmarshal(body, &v)
+	if err != nil {
+		return VersionList{}, err
+	}
+	c.Cache.set("versionlist", v)
+
+	return v, nil
+}
+
+func (v VersionList) LatestVersion(tid string) (int, error) {
+	for _, t := range v.Titles {
+		if strings.EqualFold(t.ID, tid) {
+			return t.Version, nil
+		}
+	}
+
+	return -1, errors.New("title not in version list")
+}
@@ -0,0 +1,108 @@
+package libhac
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Throttle is a token bucket limiting the combined throughput of every
+// download sharing it. The limit can be changed while transfers are running.
+type Throttle struct {
+	mu     sync.Mutex
+	limit  int64
+	tokens float64
+	last   time.Time
+}
+
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	return &Throttle{limit: bytesPerSecond, last: time.Now()}
+}
+
+// SetLimit changes the limit in bytes per second, 0 disables throttling.
+func (t *Throttle) SetLimit(bytesPerSecond int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limit = bytesPerSecond
+	if t.tokens < 0 {
+		t.tokens = 0
+	}
+}
+
+func (t *Throttle) Limit() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.limit
+}
+
+func (t *Throttle) wait(ctx context.Context, n int) error {
+	t.mu.Lock()
+
+	now := time.Now()
+	if t.limit <= 0 {
+		t.last = now
+		t.mu.Unlock()
+		return nil
+	}
+
+	t.tokens += now.Sub(t.last).Seconds() * float64(t.limit)
+	if t.tokens > float64(t.limit) {
+		t.tokens = float64(t.limit)
+	}
+	t.last = now
+	t.tokens -= float64(n)
+
+	var d time.Duration
+	if t.tokens < 0 {
+		d = time.Duration(-t.tokens / float64(t.limit) * float64(time.Second))
+	}
+	t.mu.Unlock()
+
+	if d == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *Throttle
+}
+
+func (t *Throttle) reader(ctx context.Context, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+
+	return &throttledReader{ctx, r, t}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// keep reads small so a lowered limit takes effect quickly
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		werr := tr.t.wait(tr.ctx, n)
+		if werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,232 @@
+package libhac
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	SignatureRSA4096SHA1   uint32 = 0x010000
+	SignatureRSA2048SHA1   uint32 = 0x010001
+	SignatureECDSASHA1     uint32 = 0x010002
+	SignatureRSA4096SHA256 uint32 = 0x010003
+	SignatureRSA2048SHA256 uint32 = 0x010004
+	SignatureECDSASHA256   uint32 = 0x010005
+)
+
+const (
+	TitleKeyCommon       uint8 = 0
+	TitleKeyPersonalized uint8 = 1
+)
+
+type Ticket struct {
+	SignatureType uint32
+	Signature     []byte
+	Issuer        string
+	// TitleKeyBlock holds the plain encrypted titlekey for common tickets and
+	// an RSA-OAEP blob for personalized ones.
+	TitleKeyBlock       []byte
+	FormatVersion       uint8
+	TitleKeyType        uint8
+	TicketVersion       uint16
+	LicenseType         uint8
+	KeyGeneration       uint8
+	PropertyMask        uint16
+	TicketID            uint64
+	DeviceID            uint64
+	RightsID            []byte
+	AccountID           uint32
+	SectionTotalSize    uint32
+	SectionHeaderOffset uint32
+	SectionCount        uint16
+	SectionEntrySize    uint16
+	// SectionData is whatever follows the ticket body, kept as is.
+	SectionData []byte
+}
+
+type ticketBody struct {
+	Issuer              [0x40]byte
+	TitleKeyBlock       [0x100]byte
+	FormatVersion       uint8
+	TitleKeyType        uint8
+	TicketVersion       uint16
+	LicenseType         uint8
+	KeyGeneration       uint8
+	PropertyMask        uint16
+	_                   [8]byte
+	TicketID            uint64
+	DeviceID            uint64
+	RightsID            [0x10]byte
+	AccountID           uint32
+	SectionTotalSize    uint32
+	SectionHeaderOffset uint32
+	SectionCount        uint16
+	SectionEntrySize    uint16
+}
+
+// signatureSizes returns the size of the signature and of the padding
+// following it for a signature type.
+func signatureSizes(t uint32) (int, int, error) {
+	switch t {
+	case SignatureRSA4096SHA1, SignatureRSA4096SHA256:
+		return 0x200, 0x3C, nil
+	case SignatureRSA2048SHA1, SignatureRSA2048SHA256:
+		return 0x100, 0x3C, nil
+	case SignatureECDSASHA1, SignatureECDSASHA256:
+		return 0x3C, 0x40, nil
+	}
+
+	return 0, 0, fmt.Errorf("unknown signature type %#x", t)
+}
+
+// NewCommonTicket builds an unsigned common ticket like the ones the CDN
+// hands out, so no template file is needed.
+func NewCommonTicket(rightsID, titleKey []byte, keyGeneration uint8) Ticket {
+	t := Ticket{
+		SignatureType:       SignatureRSA2048SHA256,
+		Signature:           bytes.Repeat([]byte{0xFF}, 0x100),
+		Issuer:              "Root-CA00000003-XS00000020",
+		FormatVersion:       2,
+		KeyGeneration:       keyGeneration,
+		RightsID:            append([]byte{}, rightsID...),
+		SectionHeaderOffset: 0x2C0,
+	}
+	t.SetTitleKey(titleKey)
+
+	return t
+}
+
+func ReadTicket(path string) (Ticket, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return ParseTicket(data)
+}
+
+func ParseTicket(data []byte) (Ticket, error) {
+	if len(data) < 4 {
+		return Ticket{}, errors.New("ticket is too small")
+	}
+
+	sigType := binary.LittleEndian.Uint32(data)
+	sigSize, padSize, err := signatureSizes(sigType)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	bodyOffset := 4 + sigSize + padSize
+	if len(data) < bodyOffset+binary.Size(ticketBody{}) {
+		return Ticket{}, errors.New("ticket is too small")
+	}
+
+	r := bytes.NewReader(data[bodyOffset:])
+
+	b := ticketBody{}
+	err = binary.Read(r, binary.LittleEndian, &b)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	// anything past the sections, like the certificates appended to cetks,
+	// isn't part of the ticket
+	sections := data[len(data)-r.Len():]
+	if int(b.SectionTotalSize) < len(sections) {
+		sections = sections[:b.SectionTotalSize]
+	}
+
+	return Ticket{
+		SignatureType:       sigType,
+		Signature:           append([]byte{}, data[4:4+sigSize]...),
+		Issuer:              strings.TrimRight(string(b.Issuer[:]), "\x00"),
+		TitleKeyBlock:       append([]byte{}, b.TitleKeyBlock[:]...),
+		FormatVersion:       b.FormatVersion,
+		TitleKeyType:        b.TitleKeyType,
+		TicketVersion:       b.TicketVersion,
+		LicenseType:         b.LicenseType,
+		KeyGeneration:       b.KeyGeneration,
+		PropertyMask:        b.PropertyMask,
+		TicketID:            b.TicketID,
+		DeviceID:            b.DeviceID,
+		RightsID:            append([]byte{}, b.RightsID[:]...),
+		AccountID:           b.AccountID,
+		SectionTotalSize:    b.SectionTotalSize,
+		SectionHeaderOffset: b.SectionHeaderOffset,
+		SectionCount:        b.SectionCount,
+		SectionEntrySize:    b.SectionEntrySize,
+		SectionData:         append([]byte{}, sections...),
+	}, nil
+}
+
+func (t Ticket) Bytes() ([]byte, error) {
+	sigSize, padSize, err := signatureSizes(t.SignatureType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Signature) > sigSize {
+		return nil, errors.New("ticket signature is too large")
+	}
+
+	if len(t.Issuer) > 0x40 || len(t.TitleKeyBlock) > 0x100 || len(t.RightsID) > 0x10 {
+		return nil, errors.New("ticket field is too large")
+	}
+
+	b := ticketBody{
+		FormatVersion:       t.FormatVersion,
+		TitleKeyType:        t.TitleKeyType,
+		TicketVersion:       t.TicketVersion,
+		LicenseType:         t.LicenseType,
+		KeyGeneration:       t.KeyGeneration,
+		PropertyMask:        t.PropertyMask,
+		TicketID:            t.TicketID,
+		DeviceID:            t.DeviceID,
+		AccountID:           t.AccountID,
+		SectionTotalSize:    t.SectionTotalSize,
+		SectionHeaderOffset: t.SectionHeaderOffset,
+		SectionCount:        t.SectionCount,
+		SectionEntrySize:    t.SectionEntrySize,
+	}
+	copy(b.Issuer[:], t.Issuer)
+	copy(b.TitleKeyBlock[:], t.TitleKeyBlock)
+	copy(b.RightsID[:], t.RightsID)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, t.SignatureType)
+	buf.Write(t.Signature)
+	buf.Write(make([]byte, sigSize-len(t.Signature)+padSize))
+	binary.Write(buf, binary.LittleEndian, b)
+	buf.Write(t.SectionData)
+
+	return buf.Bytes(), nil
+}
+
+// TitleKey returns the encrypted titlekey of a common ticket.
+func (t Ticket) TitleKey() ([]byte, error) {
+	if t.TitleKeyType != TitleKeyCommon {
+		return nil, errors.New("titlekey of personalized tickets is rsa encrypted")
+	}
+
+	if len(t.TitleKeyBlock) < 0x10 {
+		return nil, errors.New("titlekey block is too small")
+	}
+
+	return t.TitleKeyBlock[:0x10], nil
+}
+
+// SetTitleKey replaces the titlekey, turning the ticket into a common one.
+func (t *Ticket) SetTitleKey(titleKey []byte) {
+	t.TitleKeyType = TitleKeyCommon
+	t.TitleKeyBlock = make([]byte, 0x100)
+	copy(t.TitleKeyBlock, titleKey)
+}
+
+func (t Ticket) RightsIDString() string {
+	return hex.EncodeToString(t.RightsID)
+}
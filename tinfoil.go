@@ -0,0 +1,176 @@
+package libhac
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	TinfoilCompressionNone = 0x00
+	TinfoilCompressionZlib = 0x0D
+	TinfoilCompressionZstd = 0x0E
+
+	tinfoilEncrypted = 0xF0
+)
+
+type TinfoilIndex struct {
+	Files       []TinfoilFile `json:"files"`
+	Directories []string      `json:"directories,omitempty"`
+	Success     string        `json:"success,omitempty"`
+}
+
+type TinfoilFile struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+var tinfoilExtensions = map[string]bool{".nsp": true, ".nsz": true, ".xci": true, ".xcz": true}
+
+// BuildTinfoilIndex lists all NSP, NSZ, XCI and XCZ files below dir with
+// their urls relative to baseURL, where dir is expected to be served.
+func BuildTinfoilIndex(dir, baseURL, success string) (TinfoilIndex, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return TinfoilIndex{}, err
+	}
+
+	index := TinfoilIndex{Files: []TinfoilFile{}, Success: success}
+
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || !tinfoilExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		u := base.ResolveReference(&url.URL{Path: filepath.ToSlash(rel)})
+		index.Files = append(index.Files, TinfoilFile{u.String(), fi.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return TinfoilIndex{}, err
+	}
+
+	sort.Slice(index.Files, func(i, j int) bool {
+		return index.Files[i].URL < index.Files[j].URL
+	})
+
+	return index, nil
+}
+
+// Marshal returns the index as plain json.
+func (i TinfoilIndex) Marshal() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+// MarshalEncrypted returns the index in Tinfoil's binary container: the json
+// compressed with compression and, if pub isn't nil, encrypted with a random
+// AES key that is wrapped with RSA-OAEP for pub.
+func (i TinfoilIndex) MarshalEncrypted(pub *rsa.PublicKey, compression byte) ([]byte, error) {
+	data, err := i.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = tinfoilCompress(data, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := compression
+	sessionKey := make([]byte, 0x100)
+	size := len(data)
+
+	if pub != nil {
+		key := make([]byte, 0x10)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		sessionKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sessionKey) != 0x100 {
+			return nil, errors.New("tinfoil index encryption needs a 2048 bit rsa key")
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if pad := len(data) % aes.BlockSize; pad != 0 {
+			data = append(data, make([]byte, aes.BlockSize-pad)...)
+		}
+
+		for off := 0; off < len(data); off += aes.BlockSize {
+			block.Encrypt(data[off:], data[off:])
+		}
+
+		flag |= tinfoilEncrypted
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("TINFOIL")
+	buf.WriteByte(flag)
+	buf.Write(sessionKey)
+	binary.Write(buf, binary.LittleEndian, uint64(size))
+	buf.Write(data)
+
+	return buf.Bytes(), nil
+}
+
+func tinfoilCompress(data []byte, compression byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	switch compression {
+	case TinfoilCompressionNone:
+		return data, nil
+	case TinfoilCompressionZlib:
+		w := zlib.NewWriter(buf)
+		w.Write(data)
+		err := w.Close()
+		if err != nil {
+			return nil, err
+		}
+	case TinfoilCompressionZstd:
+		w, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(data)
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown tinfoil index compression")
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,179 @@
+package libhac
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type TitleManifest struct {
+	TitleID    string
+	Name       string
+	Version    int
+	CNMTID     string
+	CNMTPath   string
+	CNMT       CNMT
+	RightsID   string
+	TicketPath string
+	CertPath   string
+	Files      []string
+}
+
+func (c *HacClient) DownloadTitle(tid string, ver int, dest string) (TitleManifest, error) {
+	return c.DownloadTitleContext(context.Background(), tid, ver, dest)
+}
+
+func (c *HacClient) DownloadTitleContext(ctx context.Context, tid string, ver int, dest string) (TitleManifest, error) {
+	ctx, span := c.startSpan(ctx, "DownloadTitle", map[string]interface{}{"title_id": tid, "version": ver, "dest": dest})
+	m, err := c.downloadTitle(ctx, tid, ver, dest)
+	span.End(err)
+
+	return m, err
+}
+
+func (c *HacClient) downloadTitle(ctx context.Context, tid string, ver int, dest string) (TitleManifest, error) {
+	err := os.MkdirAll(dest, 0700)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+
+	cnmtID, err := c.GetCNMTIDContext(ctx, tid, ver)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+
+	m := TitleManifest{
+		TitleID:  tid,
+		Version:  ver,
+		CNMTID:   cnmtID,
+		CNMTPath: filepath.Join(dest, cnmtID+".cnmt.nca"),
+	}
+
+	if c.TitleDB != nil {
+		m.Name = c.TitleDB.Name(tid, ver)
+	}
+
+	err = c.DownloadCNMTContext(ctx, cnmtID, m.CNMTPath)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+	m.Files = append(m.Files, m.CNMTPath)
+
+	m.CNMT, err = c.decryptAndParseCNMT(m.CNMTPath)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+
+	entries := []ContentEntry{}
+	for _, ce := range m.CNMT.ContentEntries {
+		if ce.IsDeltaFragment() && !c.IncludeDeltaFragments {
+			continue
+		}
+		entries = append(entries, ce)
+	}
+
+	err = c.checkContentSpace(dest, entries)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+
+	for _, ce := range entries {
+		path := filepath.Join(dest, ce.IDString()+".nca")
+
+		err = c.DownloadContentEntryContext(ctx, ce, path)
+		if err != nil {
+			return TitleManifest{}, err
+		}
+		m.Files = append(m.Files, path)
+	}
+
+	rightsID := GetRightsID(tid, m.CNMT.MasterKeyRevisionString())
+	ok, err := c.hasCetk(ctx, rightsID)
+	if err != nil {
+		return TitleManifest{}, err
+	}
+
+	if ok {
+		m.RightsID = rightsID
+		m.TicketPath = filepath.Join(dest, rightsID+".tik")
+		m.CertPath = filepath.Join(dest, rightsID+".cert")
+
+		// the cetk is the ticket with the certificate chain appended, NSPs
+		// carry them as separate files
+		cetk := filepath.Join(dest, rightsID+".cetk")
+		err = c.DownloadCetkContext(ctx, rightsID, cetk)
+		if err != nil {
+			return TitleManifest{}, err
+		}
+
+		logf(c.Log, "splitting %s into %s and %s", cetk, m.TicketPath, m.CertPath)
+		err = ExtractCetk(cetk, m.TicketPath, m.CertPath)
+		if err != nil {
+			return TitleManifest{}, err
+		}
+
+		err = os.Remove(cetk)
+		if err != nil {
+			return TitleManifest{}, err
+		}
+		m.Files = append(m.Files, m.TicketPath, m.CertPath)
+	}
+
+	return m, nil
+}
+
+func (c *HacClient) decryptAndParseCNMT(path string) (CNMT, error) {
+	tmp, err := ioutil.TempDir("", "libhac")
+	if err != nil {
+		return CNMT{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	d, err := c.decryptor()
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	err = d.DecryptNCA(path, tmp)
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(tmp, "section0"))
+	if err != nil {
+		return CNMT{}, err
+	}
+
+	for _, v := range files {
+		if strings.HasSuffix(v.Name(), ".cnmt") {
+			return ParseCNMT(filepath.Join(tmp, "section0", v.Name()), filepath.Join(tmp, "header.bin"))
+		}
+	}
+
+	return CNMT{}, fmt.Errorf("no cnmt found in %s", path)
+}
+
+func (c *HacClient) hasCetk(ctx context.Context, rightsID string) (bool, error) {
+	resp, err := c.DoRequestContext(ctx, "HEAD", c.serviceURL(ServiceAtum, "/r/t/%s", rightsID),
+		[]tls.Certificate{c.DeviceCert}, false, true)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	// anything else could be a transient error, guessing would download
+	// the title without its ticket
+	return false, statusError(ServiceAtum, resp)
+}
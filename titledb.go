@@ -0,0 +1,186 @@
+package libhac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const titleDBURL = "https://raw.githubusercontent.com/blawar/titledb/master/%s.%s.json"
+
+type TitleInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Publisher string `json:"publisher"`
+	Region    string `json:"region"`
+}
+
+// TitleDB resolves title ids to names using the community titledb. The json
+// is cached on disk and only downloaded again once it's older than MaxAge.
+type TitleDB struct {
+	URL       string
+	CachePath string
+	MaxAge    time.Duration
+	Client    *http.Client
+
+	region string
+	mu     sync.RWMutex
+	titles map[uint64]TitleInfo
+}
+
+// NewTitleDB creates a resolver for a titledb region and language, e.g. "US"
+// and "en". cacheDir may be empty to not cache the json.
+func NewTitleDB(region, language, cacheDir string) *TitleDB {
+	db := &TitleDB{
+		URL:    fmt.Sprintf(titleDBURL, region, language),
+		MaxAge: 24 * time.Hour,
+		Client: http.DefaultClient,
+		region: region,
+	}
+
+	if cacheDir != "" {
+		db.CachePath = filepath.Join(cacheDir, fmt.Sprintf("titledb.%s.%s.json", region, language))
+	}
+
+	return db
+}
+
+func (db *TitleDB) Load() error {
+	return db.LoadContext(context.Background())
+}
+
+func (db *TitleDB) LoadContext(ctx context.Context) error {
+	data, err := db.cached()
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		data, err = db.fetch(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.parse(data)
+}
+
+func (db *TitleDB) cached() ([]byte, error) {
+	if db.CachePath == "" {
+		return nil, nil
+	}
+
+	fi, err := os.Stat(db.CachePath)
+	if os.IsNotExist(err) || (err == nil && time.Since(fi.ModTime()) > db.MaxAge) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(db.CachePath)
+}
+
+func (db *TitleDB) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", db.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := db.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("titledb returned status code %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.CachePath != "" {
+		err = os.MkdirAll(filepath.Dir(db.CachePath), 0700)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(db.CachePath, data, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+func (db *TitleDB) parse(data []byte) error {
+	// the json is keyed by nsuid, the title id is in the entries
+	entries := map[string]TitleInfo{}
+	err := json.Unmarshal(data, &entries)
+	if err != nil {
+		return err
+	}
+
+	titles := map[uint64]TitleInfo{}
+	for _, v := range entries {
+		id, err := strconv.ParseUint(v.ID, 16, 64)
+		if err != nil {
+			continue
+		}
+
+		v.ID = fmt.Sprintf("%016x", id)
+		if v.Region == "" {
+			v.Region = db.region
+		}
+		titles[id] = v
+	}
+
+	db.mu.Lock()
+	db.titles = titles
+	db.mu.Unlock()
+
+	return nil
+}
+
+// Lookup returns the info for tid. Updates and add-ons that aren't listed
+// themselves resolve to their application.
+func (db *TitleDB) Lookup(tid string) (TitleInfo, bool) {
+	id, err := ParseTitleID(tid)
+	if err != nil {
+		return TitleInfo{}, false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if t, ok := db.titles[uint64(id)]; ok {
+		return t, true
+	}
+
+	t, ok := db.titles[uint64(id.BaseID())]
+	return t, ok
+}
+
+// Name formats a title as "Game Name [TID][vN]", or just "[TID][vN]" if
+// it's unknown.
+func (db *TitleDB) Name(tid string, ver int) string {
+	name := fmt.Sprintf("[%s][v%d]", strings.ToUpper(tid), ver)
+
+	if t, ok := db.Lookup(tid); ok && t.Name != "" {
+		name = t.Name + " " + name
+	}
+
+	return name
+}
@@ -0,0 +1,78 @@
+package libhac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TitleID is the 64 bit id of a title. Applications end in 000, their
+// update is the same id ending in 800 and their add-ons are numbered from
+// the application id with bit 12 flipped.
+type TitleID uint64
+
+// ParseTitleID parses a title id in hex, with or without 0x.
+func ParseTitleID(s string) (TitleID, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	if len(s) == 0 || len(s) > 16 {
+		return 0, fmt.Errorf("invalid title id %s", s)
+	}
+
+	id, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid title id %s", s)
+	}
+
+	return TitleID(id), nil
+}
+
+// String formats the id as 16 lower case hex digits, like the cdn uses.
+func (t TitleID) String() string {
+	return fmt.Sprintf("%016x", uint64(t))
+}
+
+func (t TitleID) IsApplication() bool {
+	return t&0xFFF == 0
+}
+
+func (t TitleID) IsUpdate() bool {
+	return t&0xFFF == 0x800
+}
+
+func (t TitleID) IsAddOn() bool {
+	return !t.IsApplication() && !t.IsUpdate()
+}
+
+// BaseID returns the application id of an application, update or add-on.
+func (t TitleID) BaseID() TitleID {
+	if t.IsAddOn() {
+		return t&^0xFFF ^ 0x1000
+	}
+
+	return t &^ 0xFFF
+}
+
+// UpdateID returns the id of the update of the title's application.
+func (t TitleID) UpdateID() TitleID {
+	return t.BaseID() | 0x800
+}
+
+// AOCBaseID returns the id add-ons of the title's application are numbered
+// from, the first add-on is AOCBaseID() + 1.
+func (t TitleID) AOCBaseID() TitleID {
+	return t.BaseID() ^ 0x1000
+}
+
+func (t TitleID) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *TitleID) UnmarshalText(text []byte) error {
+	id, err := ParseTitleID(string(text))
+	if err != nil {
+		return err
+	}
+	*t = id
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package libhac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TitleKeyDB stores encrypted titlekeys by rights id in a json file so keys
+// collected from cetks can be reused later.
+type TitleKeyDB struct {
+	path string
+	gcm  cipher.AEAD
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// OpenTitleKeyDB opens the database at path, which is created on the first
+// Save if it doesn't exist yet.
+func OpenTitleKeyDB(path string) (*TitleKeyDB, error) {
+	db := &TitleKeyDB{path: path, keys: map[string]string{}}
+
+	return db, db.load()
+}
+
+// OpenEncryptedTitleKeyDB is like OpenTitleKeyDB, but the file is encrypted
+// with AES-GCM using key, which has to be 0x10, 0x18 or 0x20 bytes.
+func OpenEncryptedTitleKeyDB(path string, key []byte) (*TitleKeyDB, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &TitleKeyDB{path: path, gcm: gcm, keys: map[string]string{}}
+
+	return db, db.load()
+}
+
+func (db *TitleKeyDB) load() error {
+	data, err := ioutil.ReadFile(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if db.gcm != nil {
+		n := db.gcm.NonceSize()
+		if len(data) < n {
+			return errors.New("titlekey database is truncated")
+		}
+
+		data, err = db.gcm.Open(nil, data[:n], data[n:], nil)
+		if err != nil {
+			return fmt.Errorf("can't decrypt titlekey database: %v", err)
+		}
+	}
+
+	return json.Unmarshal(data, &db.keys)
+}
+
+func (db *TitleKeyDB) Save() error {
+	db.mu.Lock()
+	data, err := json.MarshalIndent(db.keys, "", "\t")
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if db.gcm != nil {
+		nonce := make([]byte, db.gcm.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return err
+		}
+
+		data = db.gcm.Seal(nonce, nonce, data, nil)
+	}
+
+	tmp := db.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, db.path)
+}
+
+func (db *TitleKeyDB) Get(rightsID string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tk, ok := db.keys[strings.ToLower(rightsID)]
+	return tk, ok
+}
+
+func (db *TitleKeyDB) Set(rightsID, titleKey string) error {
+	rid, err := getHexBytes(rightsID)
+	if err != nil || len(rid) != 0x10 {
+		return fmt.Errorf("invalid rights id %s", rightsID)
+	}
+
+	tk, err := getHexBytes(titleKey)
+	if err != nil || len(tk) != 0x10 {
+		return fmt.Errorf("invalid titlekey for %s", rightsID)
+	}
+
+	db.mu.Lock()
+	db.keys[hex.EncodeToString(rid)] = hex.EncodeToString(tk)
+	db.mu.Unlock()
+
+	return nil
+}
+
+func (db *TitleKeyDB) Delete(rightsID string) {
+	db.mu.Lock()
+	delete(db.keys, strings.ToLower(rightsID))
+	db.mu.Unlock()
+}
+
+// RightsIDs returns all rights ids in the database, sorted.
+func (db *TitleKeyDB) RightsIDs() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ids := make([]string, 0, len(db.keys))
+	for k := range db.keys {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// AddCetk reads the titlekey out of a cetk and stores it under the cetk's
+// rights id.
+func (db *TitleKeyDB) AddCetk(path string) (string, error) {
+	t, err := ReadTicket(path)
+	if err != nil {
+		return "", err
+	}
+
+	tk, err := t.TitleKey()
+	if err != nil {
+		return "", err
+	}
+
+	titleKey := hex.EncodeToString(tk)
+
+	return titleKey, db.Set(t.RightsIDString(), titleKey)
+}
+
+// GenerateTicket is like the package level GenerateTicket, with the titlekey
+// looked up in the database.
+func (db *TitleKeyDB) GenerateTicket(in, mKeyRev, rightsID, out string) error {
+	tk, ok := db.Get(rightsID)
+	if !ok {
+		return fmt.Errorf("no titlekey for %s in the database", rightsID)
+	}
+
+	return GenerateTicket(in, tk, mKeyRev, rightsID, out)
+}
+
+// Import reads keys in the title.keys format, one "rightsid = titlekey" per
+// line, and returns how many were read.
+func (db *TitleKeyDB) Import(r io.Reader) (int, error) {
+	n := 0
+	err := readKeyFile(r, func(name string, key []byte) error {
+		n++
+		return db.Set(name, hex.EncodeToString(key))
+	})
+
+	return n, err
+}
+
+// Export writes all keys in the title.keys format.
+func (db *TitleKeyDB) Export(w io.Writer) error {
+	for _, v := range db.RightsIDs() {
+		tk, _ := db.Get(v)
+
+		_, err := fmt.Fprintf(w, "%s = %s\n", v, tk)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *TitleKeyDB) ImportFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return db.Import(f)
+}
+
+func (db *TitleKeyDB) ExportFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = db.Export(f)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// AddToKeyset adds all keys in the database to the titlekeys of k, for native
+// decryption of rights id titles.
+func (db *TitleKeyDB) AddToKeyset(k *Keyset) error {
+	if k.TitleKeys == nil {
+		k.TitleKeys = map[string][]byte{}
+	}
+
+	for _, v := range db.RightsIDs() {
+		tk, _ := db.Get(v)
+
+		key, err := getHexBytes(tk)
+		if err != nil {
+			return err
+		}
+
+		k.TitleKeys[v] = key
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package libhac
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadCABundle reads PEM certificates from path into a pool for RootCAs.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in ca bundle")
+	}
+
+	return pool, nil
+}
+
+// CertificatePin returns the pin of a certificate for PinnedCertificates,
+// the hex SHA-256 of its public key.
+func CertificatePin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *HacClient) tlsConfig(certs []tls.Certificate) *tls.Config {
+	cfg := &tls.Config{
+		Certificates: certs,
+		RootCAs:      c.RootCAs,
+	}
+
+	switch {
+	case c.InsecureSkipVerify:
+		cfg.InsecureSkipVerify = true
+	case len(c.PinnedCertificates) > 0:
+		// pinned certificates replace chain verification, nintendo's own ca
+		// isn't trusted by the system anyway
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = c.verifyPin
+	}
+
+	return cfg
+}
+
+// verifyPin accepts the server's leaf certificate if it's pinned itself or
+// chains up to a pinned certificate. Only the leaf's key is proven by the
+// handshake, so a pinned certificate elsewhere in the chain counts only once
+// the leaf verifies against it.
+func (c *HacClient) verifyPin(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server sent no certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	if c.pinned(certs[0]) {
+		return nil
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		if c.pinned(cert) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return errors.New("server certificate doesn't match any pinned certificate")
+	}
+
+	return nil
+}
+
+func (c *HacClient) pinned(cert *x509.Certificate) bool {
+	pin := CertificatePin(cert)
+	for _, v := range c.PinnedCertificates {
+		if strings.EqualFold(v, pin) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,28 @@
+package libhac
+
+import "context"
+
+// Tracer starts spans around requests, downloads and title downloads, the
+// tracing subpackage implements it with OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttribute(key string, value interface{})
+	// End finishes the span, marking it as failed if err is set.
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End(error)                        {}
+
+func (c *HacClient) startSpan(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return c.Tracer.Start(ctx, name, attrs)
+}
@@ -0,0 +1,16 @@
+module github.com/jakibaki/libhac/tracing
+
+go 1.22
+
+require (
+	github.com/jakibaki/libhac v0.0.0
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+)
+
+require (
+	github.com/klauspost/compress v1.17.11 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+)
+
+replace github.com/jakibaki/libhac => ../
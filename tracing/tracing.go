@@ -0,0 +1,78 @@
+// Package tracing records the spans of a HacClient with OpenTelemetry.
+//
+//	client.Tracer = tracing.New(otel.Tracer("libhac"))
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jakibaki/libhac"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+func New(t trace.Tracer) *Tracer {
+	return &Tracer{t}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, libhac.Span) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, keyValue(k, v))
+	}
+
+	ctx, span := t.tracer.Start(ctx, "libhac."+name, trace.WithAttributes(kvs...))
+
+	return ctx, &otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(keyValue(key, value))
+}
+
+func (s *otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.span.End()
+}
+
+func keyValue(k string, v interface{}) attribute.KeyValue {
+	switch v := v.(type) {
+	case string:
+		return attribute.String(k, v)
+	case int:
+		return attribute.Int(k, v)
+	case int64:
+		return attribute.Int64(k, v)
+	case bool:
+		return attribute.Bool(k, v)
+	default:
+		return attribute.String(k, fmt.Sprint(v))
+	}
+}
+
+// PackToNSP is libhac.PackToNSP in a span, packing has no client to take
+// the tracer from.
+func PackToNSP(ctx context.Context, t trace.Tracer, path, out string) error {
+	_, span := t.Start(ctx, "libhac.PackToNSP", trace.WithAttributes(
+		attribute.String("path", path), attribute.String("out", out)))
+
+	s := &otelSpan{span}
+	err := libhac.PackToNSP(path, out)
+	s.End(err)
+
+	return err
+}
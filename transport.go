@@ -0,0 +1,71 @@
+package libhac
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxCachedTransports bounds transportCache, clients building a new RootCAs
+// pool or proxy for every request would otherwise grow it forever.
+const maxCachedTransports = 32
+
+// transportCache keeps a transport per client certificate, tls and proxy
+// configuration, so connections are reused between requests. Copies of a
+// client share it.
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// sharedTransports is used by clients not created with NewHacClient.
+var sharedTransports = &transportCache{}
+
+// transportKey identifies everything the transport of c for certs depends on.
+func (c *HacClient) transportKey(certs []tls.Certificate) string {
+	h := sha256.New()
+	for _, v := range certs {
+		for _, der := range v.Certificate {
+			h.Write(der)
+		}
+		h.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("%x|%p|%p|%t|%s", h.Sum(nil), c.Proxy, c.RootCAs, c.InsecureSkipVerify,
+		strings.ToLower(strings.Join(c.PinnedCertificates, ",")))
+}
+
+// transport returns the cached transport for certs, creating it on first use.
+func (c *HacClient) transport(certs []tls.Certificate) *http.Transport {
+	cache := c.transports
+	if cache == nil {
+		cache = sharedTransports
+	}
+
+	key := c.transportKey(certs)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if t, ok := cache.transports[key]; ok {
+		return t
+	}
+
+	if cache.transports == nil || len(cache.transports) >= maxCachedTransports {
+		for _, t := range cache.transports {
+			t.CloseIdleConnections()
+		}
+		cache.transports = map[string]*http.Transport{}
+	}
+
+	t := &http.Transport{
+		Proxy:           c.Proxy,
+		TLSClientConfig: c.tlsConfig(certs),
+	}
+	cache.transports[key] = t
+
+	return t
+}
@@ -0,0 +1,5 @@
+module github.com/jakibaki/libhac/usbinstall
+
+go 1.22
+
+require github.com/google/gousb v1.1.3
@@ -0,0 +1,79 @@
+//go:build libusb
+
+package usbinstall
+
+import (
+	"errors"
+
+	"github.com/google/gousb"
+)
+
+// Device is a console connected over USB with Tinfoil or Awoo Installer
+// waiting for a USB install.
+type Device struct {
+	ctx  *gousb.Context
+	dev  *gousb.Device
+	done func()
+	in   *gousb.InEndpoint
+	out  *gousb.OutEndpoint
+}
+
+// Open finds the first connected console.
+func Open() (*Device, error) {
+	ctx := gousb.NewContext()
+
+	dev, err := ctx.OpenDeviceWithVIDPID(VendorID, ProductID)
+	if err == nil && dev == nil {
+		err = errors.New("no switch connected")
+	}
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+
+	dev.SetAutoDetach(true)
+
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		dev.Close()
+		ctx.Close()
+		return nil, err
+	}
+
+	d := &Device{ctx: ctx, dev: dev, done: done}
+	for _, v := range intf.Setting.Endpoints {
+		switch {
+		case v.Direction == gousb.EndpointDirectionIn && d.in == nil:
+			d.in, err = intf.InEndpoint(v.Number)
+		case v.Direction == gousb.EndpointDirectionOut && d.out == nil:
+			d.out, err = intf.OutEndpoint(v.Number)
+		}
+
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	if d.in == nil || d.out == nil {
+		d.Close()
+		return nil, errors.New("console is missing bulk endpoints")
+	}
+
+	return d, nil
+}
+
+func (d *Device) Read(p []byte) (int, error) {
+	return d.in.Read(p)
+}
+
+func (d *Device) Write(p []byte) (int, error) {
+	return d.out.Write(p)
+}
+
+func (d *Device) Close() error {
+	d.done()
+	d.dev.Close()
+
+	return d.ctx.Close()
+}
@@ -0,0 +1,203 @@
+// Package usbinstall pushes NSPs to a Switch running Tinfoil or Awoo
+// Installer over their USB install protocol.
+//
+// The protocol runs over any pair of bulk endpoints passed as an
+// io.ReadWriter. Building with -tags libusb adds Open, which finds the console
+// through libusb.
+package usbinstall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VendorID  = 0x057E
+	ProductID = 0x3000
+
+	cmdTypeRequest  = 0
+	cmdTypeResponse = 1
+
+	cmdExit      = 0
+	cmdFileRange = 1
+
+	chunkSize = 0x100000
+)
+
+// ProgressFunc is called after every chunk sent with the bytes of name sent
+// so far in the current range request and the size of the range.
+type ProgressFunc func(name string, done, total int64)
+
+type commandHeader struct {
+	Magic    [4]byte
+	Type     uint8
+	_        [3]byte
+	ID       uint32
+	DataSize uint64
+	_        [0xC]byte
+}
+
+type fileRangeHeader struct {
+	Size    uint64
+	Offset  uint64
+	NameLen uint64
+	_       uint64
+}
+
+type Sender struct {
+	Progress ProgressFunc
+
+	rw    io.ReadWriter
+	files map[string]string
+	names []string
+}
+
+// NewSender prepares sending paths over rw, which reads from the console's
+// bulk in endpoint and writes to its bulk out endpoint.
+func NewSender(rw io.ReadWriter, paths []string) (*Sender, error) {
+	s := &Sender{rw: rw, files: map[string]string{}}
+
+	for _, v := range paths {
+		name := filepath.Base(v)
+		if _, ok := s.files[name]; ok {
+			return nil, fmt.Errorf("duplicate file name %s", name)
+		}
+
+		s.files[name] = v
+		s.names = append(s.names, name)
+	}
+
+	return s, nil
+}
+
+// Send announces the files and serves range requests until the console sends
+// the exit command.
+func (s *Sender) Send() error {
+	err := s.sendList()
+	if err != nil {
+		return err
+	}
+
+	for {
+		h := commandHeader{}
+		err = binary.Read(s.rw, binary.LittleEndian, &h)
+		if err != nil {
+			return err
+		}
+
+		if string(h.Magic[:]) != "TUC0" {
+			// the console sends garbage while it isn't listening yet
+			continue
+		}
+
+		switch h.ID {
+		case cmdExit:
+			return nil
+		case cmdFileRange:
+			err = s.sendFileRange(h)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown usb install command %d", h.ID)
+		}
+	}
+}
+
+func (s *Sender) sendList() error {
+	list := &bytes.Buffer{}
+	for _, v := range s.names {
+		list.WriteString(v + "\n")
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("TUL0")
+	binary.Write(buf, binary.LittleEndian, uint32(list.Len()))
+	buf.Write(make([]byte, 8))
+	buf.Write(list.Bytes())
+
+	_, err := s.rw.Write(buf.Bytes())
+	return err
+}
+
+func (s *Sender) sendFileRange(h commandHeader) error {
+	r := fileRangeHeader{}
+	err := binary.Read(s.rw, binary.LittleEndian, &r)
+	if err != nil {
+		return err
+	}
+
+	// the name must be one of ours, so it's never longer than those
+	longest := 0
+	for k := range s.files {
+		if len(k) > longest {
+			longest = len(k)
+		}
+	}
+
+	if r.NameLen > uint64(longest) {
+		return fmt.Errorf("console requested a file name of %d bytes", r.NameLen)
+	}
+
+	name := make([]byte, r.NameLen)
+	_, err = io.ReadFull(s.rw, name)
+	if err != nil {
+		return err
+	}
+
+	path, ok := s.files[string(name)]
+	if !ok {
+		return fmt.Errorf("console requested unknown file %s", name)
+	}
+
+	resp := commandHeader{
+		Magic:    [4]byte{'T', 'U', 'C', '0'},
+		Type:     cmdTypeResponse,
+		ID:       h.ID,
+		DataSize: r.Size,
+	}
+
+	err = binary.Write(s.rw, binary.LittleEndian, resp)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sr := io.NewSectionReader(f, int64(r.Offset), int64(r.Size))
+	buf := make([]byte, chunkSize)
+
+	var done int64
+	for done < int64(r.Size) {
+		n, err := sr.Read(buf)
+		if n > 0 {
+			_, werr := s.rw.Write(buf[:n])
+			if werr != nil {
+				return werr
+			}
+
+			done += int64(n)
+			if s.Progress != nil {
+				s.Progress(string(name), done, int64(r.Size))
+			}
+		}
+
+		if err == io.EOF && done < int64(r.Size) {
+			return errors.New("requested range is past the end of the file")
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	return nil
+}
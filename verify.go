@@ -0,0 +1,278 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type VerifyResult struct {
+	Name   string
+	OK     bool
+	Reason string
+}
+
+// VerifyNSP checks every content entry listed in the NSP's meta against the
+// NCAs it contains. An error is only returned if the NSP or its meta can't be
+// read, individual failures are reported in the results.
+func VerifyNSP(path string, keys *Keyset) ([]VerifyResult, error) {
+	nsp, err := OpenNSP(path)
+	if err != nil {
+		return nil, err
+	}
+	defer nsp.Close()
+
+	var meta *PFS0Entry
+	for i, v := range nsp.Entries {
+		if strings.HasSuffix(v.Name, ".cnmt.nca") {
+			meta = &nsp.Entries[i]
+			break
+		}
+	}
+
+	if meta == nil {
+		return nil, errors.New("no .cnmt.nca found in nsp")
+	}
+
+	cnmt, err := readCNMTFromNCA(io.NewSectionReader(nsp.r, meta.Offset, meta.Size), keys, meta.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []VerifyResult{}
+	for _, ce := range cnmt.ContentEntries {
+		results = append(results, verifyContentEntry(nsp, ce))
+	}
+
+	return results, nil
+}
+
+func verifyContentEntry(nsp *NSPReader, ce ContentEntry) VerifyResult {
+	name := ce.IDString() + ".nca"
+
+	e, err := nsp.Entry(name)
+	if err != nil {
+		return VerifyResult{name, false, "missing from nsp"}
+	}
+
+	if e.Size != ce.Size {
+		return VerifyResult{name, false, fmt.Sprintf("size is %d, expected %d", e.Size, ce.Size)}
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, io.NewSectionReader(nsp.r, e.Offset, e.Size))
+	if err != nil {
+		return VerifyResult{name, false, err.Error()}
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, ce.Hash) {
+		return VerifyResult{name, false, fmt.Sprintf("hash is %x, expected %x", sum, ce.Hash)}
+	}
+
+	return VerifyResult{name, true, ""}
+}
+
+// VerifyTitleKey decrypts the start of the NCA's first encrypted section
+// with titleKey and checks that it looks like a PFS0 or RomFS, to catch bad
+// cetks or typos before packing an unplayable NSP.
+func VerifyTitleKey(rightsID, titleKey, ncaPath string, keys *Keyset) error {
+	if keys == nil {
+		return errors.New("no keys given to verify the titlekey with")
+	}
+
+	tk, err := getHexBytes(titleKey)
+	if err != nil || len(tk) != 0x10 {
+		return fmt.Errorf("invalid titlekey %s", titleKey)
+	}
+
+	f, err := os.Open(ncaPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// only use the titlekey being checked, not one already in the keyset
+	k := *keys
+	k.TitleKeys = map[string][]byte{strings.ToLower(rightsID): tk}
+
+	nca, err := openNCA(f, &k)
+	if err != nil {
+		return err
+	}
+
+	if !nca.hasRightsID() {
+		return errors.New("nca doesn't use rights id crypto")
+	}
+
+	if rid := hex.EncodeToString(nca.header.RightsID[:]); rid != strings.ToLower(rightsID) {
+		return fmt.Errorf("nca has rights id %s, not %s", rid, rightsID)
+	}
+
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) || nca.header.FsHeaders[i].EncryptionType != 3 {
+			continue
+		}
+
+		return verifySectionPlausible(nca, i)
+	}
+
+	return errors.New("nca has no encrypted section to check the titlekey with")
+}
+
+func verifySectionPlausible(nca *ncaFile, i int) error {
+	data, err := nca.dataReader(i)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	_, err = data.ReadAt(b, 0)
+	if err != nil {
+		return err
+	}
+
+	fs := nca.header.FsHeaders[i]
+	switch fs.FsType {
+	case 0:
+		// the romfs header starts with its own size
+		if binary.LittleEndian.Uint64(b) != 0x50 {
+			return errors.New("romfs header is garbage, wrong titlekey?")
+		}
+	case 1:
+		if string(b[:4]) != "PFS0" {
+			return errors.New("invalid pfs0 magic, wrong titlekey?")
+		}
+
+		sb := pfs0Superblock{}
+		err = binary.Read(bytes.NewReader(fs.HashInfo[:]), binary.LittleEndian, &sb)
+		if err != nil {
+			return err
+		}
+
+		sr, err := nca.sectionReader(i)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, io.NewSectionReader(sr, int64(sb.HashTableOffset), int64(sb.HashTableSize)))
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(h.Sum(nil), sb.MasterHash[:]) {
+			return errors.New("pfs0 hash table doesn't match the master hash")
+		}
+	}
+
+	return nil
+}
+
+// VerifyNCA checks the header hash of every section of the NCA at path and
+// then its data, against the HierarchicalSha256 table of PFS0 sections or
+// the IVFC tree of RomFS sections. Unlike the content hash this needs the
+// keys but proves the decrypted data intact. Corruption is reported as an
+// error matching ErrHashMismatch.
+func VerifyNCA(path string, keys *Keyset) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nca, err := openNCA(f, keys)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 4; i++ {
+		if !nca.sectionExists(i) {
+			continue
+		}
+
+		sum := sha256.Sum256(nca.raw[0x400+i*0x200 : 0x600+i*0x200])
+		if !bytes.Equal(sum[:], nca.header.FsHeaderHashes[i][:]) {
+			return fmt.Errorf("%w: fs header of section %d", ErrHashMismatch, i)
+		}
+
+		switch nca.header.FsHeaders[i].FsType {
+		case 0:
+			err = nca.verifyIVFC(i)
+		case 1:
+			err = nca.verifySha256(i)
+		default:
+			err = fmt.Errorf("unknown fs type %d in nca section %d", nca.header.FsHeaders[i].FsType, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifySha256 checks the HierarchicalSha256 hash table of section i against
+// its master hash and the PFS0 against the table. Unlike IVFC the last block
+// is hashed without padding.
+func (n *ncaFile) verifySha256(i int) error {
+	sb := pfs0Superblock{}
+	err := binary.Read(bytes.NewReader(n.header.FsHeaders[i].HashInfo[:]), binary.LittleEndian, &sb)
+	if err != nil {
+		return err
+	}
+
+	if sb.BlockSize == 0 || sb.BlockSize > 1<<24 {
+		return fmt.Errorf("invalid hash block size %d in nca section %d", sb.BlockSize, i)
+	}
+
+	sr, err := n.sectionReader(i)
+	if err != nil {
+		return err
+	}
+
+	table, err := readSection(sr, int64(sb.HashTableOffset), int64(sb.HashTableSize))
+	if err != nil {
+		return err
+	}
+
+	if sum := sha256.Sum256(table); !bytes.Equal(sum[:], sb.MasterHash[:]) {
+		return fmt.Errorf("%w: hash table of section %d", ErrHashMismatch, i)
+	}
+
+	bs := int64(sb.BlockSize)
+	blocks := (int64(sb.PFS0Size) + bs - 1) / bs
+	if blocks*sha256.Size > int64(len(table)) {
+		return fmt.Errorf("nca section %d has more blocks than hashes", i)
+	}
+
+	data := io.NewSectionReader(sr, int64(sb.PFS0Offset), int64(sb.PFS0Size))
+	buf := make([]byte, bs)
+
+	bad, first := int64(0), int64(-1)
+	for b := int64(0); b < blocks; b++ {
+		read, err := data.ReadAt(buf, b*bs)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		sum := sha256.Sum256(buf[:read])
+		if !bytes.Equal(sum[:], table[b*sha256.Size:(b+1)*sha256.Size]) {
+			if first < 0 {
+				first = b
+			}
+			bad++
+		}
+	}
+
+	if bad > 0 {
+		return fmt.Errorf("%w: %d blocks of section %d, first block %d", ErrHashMismatch, bad, i, first)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package libhac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a raw title or system version, e.g. 131072. Its bits are the
+// major (6), minor (6), micro (4) and bugfix (16) parts of the display form.
+// Title versions go up by 65536 per update, see Release and Next.
+type Version uint32
+
+// versionStep is the difference between two title releases, only the upper
+// 16 bits of title versions change.
+const versionStep = 0x10000
+
+// ReleaseVersion returns the version of release n of a title, release 2 is
+// v131072.
+func ReleaseVersion(n int) Version {
+	return Version(n) * versionStep
+}
+
+// ParseVersion parses a raw version like "131072" or "v131072", or the
+// display form "major.minor.micro[.bugfix]".
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimPrefix(s, "v")
+
+	parts := strings.Split(s, ".")
+	if len(parts) == 1 {
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %s", s)
+		}
+
+		return Version(v), nil
+	}
+
+	if len(parts) < 3 || len(parts) > 4 {
+		return 0, fmt.Errorf("invalid version %s", s)
+	}
+
+	bits := []int{6, 6, 4, 16}
+	shifts := []uint{26, 20, 16, 0}
+
+	var v Version
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, bits[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %s", s)
+		}
+		v |= Version(n) << shifts[i]
+	}
+
+	return v, nil
+}
+
+// maxReleases bounds the releases of a title, version lists come from the
+// cdn and every release stepped through costs a request.
+const maxReleases = 1024
+
+// VersionRange returns the versions of every release from first to latest.
+// latest usually comes from a version list, negative values or ones beyond
+// a sane number of releases are rejected.
+func VersionRange(first Version, latest int) ([]Version, error) {
+	if latest < 0 || latest >= int(ReleaseVersion(maxReleases)) {
+		return nil, fmt.Errorf("invalid title version %d", latest)
+	}
+
+	versions := []Version{}
+	for r := first.Release(); r <= Version(latest).Release(); r++ {
+		versions = append(versions, ReleaseVersion(r))
+	}
+
+	return versions, nil
+}
+
+// Major returns the first part of the display form.
+func (v Version) Major() int {
+	return int(v >> 26)
+}
+
+// Minor returns the second part of the display form.
+func (v Version) Minor() int {
+	return int(v >> 20 & 0x3F)
+}
+
+// Micro returns the third part of the display form.
+func (v Version) Micro() int {
+	return int(v >> 16 & 0xF)
+}
+
+// Bugfix returns the last part of the display form, always 0 for title
+// versions.
+func (v Version) Bugfix() int {
+	return int(v & 0xFFFF)
+}
+
+// Release returns the number of a title version, v131072 is release 2.
+func (v Version) Release() int {
+	return int(v >> 16)
+}
+
+// Next returns the version of the release after v.
+func (v Version) Next() Version {
+	return ReleaseVersion(v.Release() + 1)
+}
+
+// String returns the display form "major.minor.micro.bugfix".
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v.Major(), v.Minor(), v.Micro(), v.Bugfix())
+}
+
+// FirmwareString returns the form system versions are shown in,
+// "major.minor.micro".
+func (v Version) FirmwareString() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Micro())
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than o.
+func (v Version) Compare(o Version) int {
+	switch {
+	case v < o:
+		return -1
+	case v > o:
+		return 1
+	}
+
+	return 0
+}
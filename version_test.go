@@ -0,0 +1,160 @@
+package libhac_test
+
+import (
+	"testing"
+
+	"github.com/jakibaki/libhac"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want libhac.Version
+	}{
+		{"0", 0},
+		{"131072", 131072},
+		{"v65536", 65536},
+		{"0.0.2", 131072},
+		{"11.0.1.0", 11<<26 | 1<<16},
+		{"1.2.3.4", 1<<26 | 2<<20 | 3<<16 | 4},
+	}
+
+	for _, v := range tests {
+		got, err := libhac.ParseVersion(v.in)
+		if err != nil {
+			t.Errorf("%s: %v", v.in, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("%s: got %d, want %d", v.in, got, v.want)
+		}
+	}
+
+	for _, v := range []string{"", "v", "x", "1.2", "1.2.3.4.5", "64.0.0", "0.0.16", "0.0.0.65536", "4294967296"} {
+		if _, err := libhac.ParseVersion(v); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	v := libhac.Version(1<<26 | 2<<20 | 3<<16 | 4)
+	if s := v.String(); s != "1.2.3.4" {
+		t.Errorf("got %s", s)
+	}
+	if s := v.FirmwareString(); s != "1.2.3" {
+		t.Errorf("got firmware string %s", s)
+	}
+
+	p, err := libhac.ParseVersion(v.String())
+	if err != nil || p != v {
+		t.Errorf("round trip got %d, %v", p, err)
+	}
+}
+
+func TestVersionReleases(t *testing.T) {
+	if v := libhac.ReleaseVersion(2); v != 131072 {
+		t.Errorf("release 2 is %d", v)
+	}
+	if r := libhac.Version(196608).Release(); r != 3 {
+		t.Errorf("v196608 is release %d", r)
+	}
+	if n := libhac.Version(65536).Next(); n != 131072 {
+		t.Errorf("next of v65536 is %d", n)
+	}
+	if n := libhac.Version(0).Next(); n != 65536 {
+		t.Errorf("next of v0 is %d", n)
+	}
+
+	if c := libhac.Version(65536).Compare(131072); c != -1 {
+		t.Errorf("compare got %d", c)
+	}
+	if c := libhac.Version(131072).Compare(131072); c != 0 {
+		t.Errorf("compare got %d", c)
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	got, err := libhac.VersionRange(libhac.ReleaseVersion(1), 196608)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []libhac.Version{65536, 131072, 196608}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	got, err = libhac.VersionRange(libhac.ReleaseVersion(1), 0)
+	if err != nil || len(got) != 0 {
+		t.Errorf("latest before first got %v, %v", got, err)
+	}
+
+	for _, v := range []int{-1, 1 << 30, int(^uint32(0))} {
+		if _, err := libhac.VersionRange(0, v); err == nil {
+			t.Errorf("%d: no error", v)
+		}
+	}
+}
+
+func TestTitleID(t *testing.T) {
+	id, err := libhac.ParseTitleID("0x01007EF00011E000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := id.String(); s != "01007ef00011e000" {
+		t.Errorf("got %s", s)
+	}
+	if !id.IsApplication() || id.IsUpdate() || id.IsAddOn() {
+		t.Errorf("%s isn't only an application", id)
+	}
+
+	tests := []struct {
+		name string
+		got  libhac.TitleID
+		want string
+	}{
+		{"update", id.UpdateID(), "01007ef00011e800"},
+		{"aoc base", id.AOCBaseID(), "01007ef00011f000"},
+		{"base of update", id.UpdateID().BaseID(), "01007ef00011e000"},
+		{"base of add-on", (id.AOCBaseID() + 1).BaseID(), "01007ef00011e000"},
+		{"update of add-on", (id.AOCBaseID() + 5).UpdateID(), "01007ef00011e800"},
+	}
+
+	for _, v := range tests {
+		if s := v.got.String(); s != v.want {
+			t.Errorf("%s: got %s, want %s", v.name, s, v.want)
+		}
+	}
+
+	if a := id.AOCBaseID() + 1; !a.IsAddOn() {
+		t.Errorf("%s isn't an add-on", a)
+	}
+
+	for _, v := range []string{"", "0x", "01007ef00011e0000", "01007ef00011g000"} {
+		if _, err := libhac.ParseTitleID(v); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+}
+
+func TestTitleIDText(t *testing.T) {
+	id := libhac.TitleID(0x0100000000010800)
+
+	text, err := id.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got libhac.TitleID
+	err = got.UnmarshalText(text)
+	if err != nil || got != id {
+		t.Errorf("round trip got %s, %v", got, err)
+	}
+}
@@ -0,0 +1,165 @@
+package libhac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// maxProbedVersions bounds probing the cdn for titles not in the version list.
+const maxProbedVersions = 128
+
+// GetLatestVersion returns the highest version of tid on the cdn. The version
+// list is used if it has the title, otherwise versions are probed.
+func (c *HacClient) GetLatestVersion(tid string) (int, error) {
+	return c.GetLatestVersionContext(context.Background(), tid)
+}
+
+func (c *HacClient) GetLatestVersionContext(ctx context.Context, tid string) (int, error) {
+	vl, err := c.GetVersionListContext(ctx)
+	if err == nil {
+		if v, err := vl.LatestVersion(tid); err == nil {
+			return v, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+
+	versions, err := c.probeVersions(ctx, tid)
+	if err != nil {
+		return -1, err
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+// GetVersions returns every version of tid published on the cdn, oldest
+// first.
+func (c *HacClient) GetVersions(tid string) ([]int, error) {
+	return c.GetVersionsContext(context.Background(), tid)
+}
+
+func (c *HacClient) GetVersionsContext(ctx context.Context, tid string) ([]int, error) {
+	vl, err := c.GetVersionListContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return c.probeVersions(ctx, tid)
+	}
+
+	latest, err := vl.LatestVersion(tid)
+	if err != nil {
+		return c.probeVersions(ctx, tid)
+	}
+
+	start, err := firstVersion(tid)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := VersionRange(start, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	// versions can be pulled from the cdn, so each one is checked
+	versions := []int{}
+	for _, v := range releases {
+		ok, err := c.hasVersion(ctx, tid, int(v))
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			versions = append(versions, int(v))
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrTitleNotOnCDN
+	}
+
+	return versions, nil
+}
+
+// DownloadAllVersions downloads every published version of tid into
+// dest/v<version>.
+func (c *HacClient) DownloadAllVersions(tid, dest string) ([]TitleManifest, error) {
+	return c.DownloadAllVersionsContext(context.Background(), tid, dest)
+}
+
+func (c *HacClient) DownloadAllVersionsContext(ctx context.Context, tid, dest string) ([]TitleManifest, error) {
+	versions, err := c.GetVersionsContext(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []TitleManifest{}
+	for _, v := range versions {
+		m, err := c.DownloadTitleContext(ctx, tid, v, filepath.Join(dest, fmt.Sprintf("v%d", v)))
+		if err != nil {
+			return manifests, fmt.Errorf("v%d: %w", v, err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	return manifests, nil
+}
+
+// firstVersion returns the lowest possible version of tid, patches start at
+// v65536.
+func firstVersion(tid string) (Version, error) {
+	id, err := ParseTitleID(tid)
+	if err != nil {
+		return 0, err
+	}
+
+	if id.IsUpdate() {
+		return ReleaseVersion(1), nil
+	}
+
+	return 0, nil
+}
+
+// probeVersions asks the cdn for every version of tid until one is missing.
+func (c *HacClient) probeVersions(ctx context.Context, tid string) ([]int, error) {
+	start, err := firstVersion(tid)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []int{}
+	for v := start; v.Release() < start.Release()+maxProbedVersions; v = v.Next() {
+		ok, err := c.hasVersion(ctx, tid, int(v))
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			break
+		}
+
+		versions = append(versions, int(v))
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrTitleNotOnCDN
+	}
+
+	return versions, nil
+}
+
+func (c *HacClient) hasVersion(ctx context.Context, tid string, ver int) (bool, error) {
+	_, err := c.getCNMTID(ctx, "a", tid, ver)
+	if errors.Is(err, ErrTitleNotOnCDN) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
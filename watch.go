@@ -0,0 +1,194 @@
+package libhac
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UpdateEvent struct {
+	TitleID         string
+	Version         int
+	PreviousVersion int
+	// Manifest and Err are only set if the watcher downloads updates.
+	Manifest *TitleManifest
+	Err      error
+}
+
+// defaultWatchInterval is used for UpdateWatchers without an Interval.
+const defaultWatchInterval = 6 * time.Hour
+
+// UpdateWatcher periodically checks the version list for new versions of a
+// set of titles.
+type UpdateWatcher struct {
+	// Interval defaults to 6 hours if it's not positive.
+	Interval time.Duration
+	// DownloadDir is optional, new versions are downloaded into
+	// DownloadDir/<tid>_v<version> if it's set.
+	DownloadDir string
+	// OnUpdate has to be set before Run is called.
+	OnUpdate func(UpdateEvent)
+
+	client *HacClient
+	mu     sync.Mutex
+	titles map[string]int
+}
+
+func NewUpdateWatcher(c *HacClient, interval time.Duration) *UpdateWatcher {
+	return &UpdateWatcher{
+		Interval: interval,
+		client:   c,
+		titles:   map[string]int{},
+	}
+}
+
+// Watch adds tid with the newest version already known, use -1 to get an
+// update for the current version on the first check.
+func (w *UpdateWatcher) Watch(tid string, knownVersion int) {
+	w.mu.Lock()
+	w.titles[strings.ToLower(tid)] = knownVersion
+	w.mu.Unlock()
+}
+
+func (w *UpdateWatcher) Unwatch(tid string) {
+	w.mu.Lock()
+	delete(w.titles, strings.ToLower(tid))
+	w.mu.Unlock()
+}
+
+// Titles returns the watched titles with their newest known version.
+func (w *UpdateWatcher) Titles() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	titles := map[string]int{}
+	for k, v := range w.titles {
+		titles[k] = v
+	}
+
+	return titles
+}
+
+// Check compares the watched titles against the version list once and
+// returns an event for every title with a new version.
+func (w *UpdateWatcher) Check(ctx context.Context) ([]UpdateEvent, error) {
+	vl, err := w.client.GetVersionListContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := w.Titles()
+	ids := make([]string, 0, len(titles))
+	for k := range titles {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+
+	events := []UpdateEvent{}
+	for _, tid := range ids {
+		latest, err := vl.LatestVersion(tid)
+		if err != nil || latest <= titles[tid] {
+			continue
+		}
+
+		e := UpdateEvent{TitleID: tid, Version: latest, PreviousVersion: titles[tid]}
+
+		if w.DownloadDir != "" {
+			e.Manifest, e.Err = w.download(ctx, tid, latest)
+		}
+
+		// a failed download is retried on the next check
+		if e.Err == nil {
+			w.mu.Lock()
+			if _, ok := w.titles[tid]; ok {
+				w.titles[tid] = latest
+			}
+			w.mu.Unlock()
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func (w *UpdateWatcher) download(ctx context.Context, tid string, ver int) (*TitleManifest, error) {
+	dl := UpdateTitleID(tid, ver)
+
+	m, err := w.client.DownloadTitleContext(ctx, dl, ver, filepath.Join(w.DownloadDir, fmt.Sprintf("%s_v%d", dl, ver)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// UpdateTitleID returns the patch title id for versions of applications
+// above 0, which is what has to be downloaded for them.
+func UpdateTitleID(tid string, ver int) string {
+	id, err := ParseTitleID(tid)
+	if err != nil || ver == 0 || !id.IsApplication() {
+		return tid
+	}
+
+	return id.UpdateID().String()
+}
+
+// Run checks for updates every Interval until ctx is canceled, calling
+// OnUpdate for every new version. Errors fetching the version list are
+// reported as events without a title id.
+func (w *UpdateWatcher) Run(ctx context.Context) error {
+	return w.run(ctx, w.OnUpdate)
+}
+
+func (w *UpdateWatcher) run(ctx context.Context, onUpdate func(UpdateEvent)) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		events, err := w.Check(ctx)
+		if err != nil && ctx.Err() == nil {
+			events = []UpdateEvent{{Err: err}}
+		}
+
+		for _, e := range events {
+			if onUpdate != nil {
+				onUpdate(e)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Updates runs the watcher in the background and delivers events on the
+// returned channel, which is closed once ctx is canceled. OnUpdate isn't
+// called.
+func (w *UpdateWatcher) Updates(ctx context.Context) <-chan UpdateEvent {
+	ch := make(chan UpdateEvent)
+
+	go func() {
+		w.run(ctx, func(e UpdateEvent) {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+			}
+		})
+		close(ch)
+	}()
+
+	return ch
+}
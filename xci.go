@@ -0,0 +1,118 @@
+package libhac
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type XCIHeader struct {
+	Signature        [0x100]byte
+	Magic            [4]byte
+	SecureAreaStart  uint32
+	BackupAreaStart  uint32
+	TitleKeyDecIndex uint8
+	RomSize          uint8
+	Version          uint8
+	Flags            uint8
+	PackageID        uint64
+	ValidDataEnd     uint64
+	IV               [0x10]byte
+	HFS0Offset       uint64
+	HFS0HeaderSize   uint64
+	HFS0HeaderHash   [0x20]byte
+	InitialDataHash  [0x20]byte
+	SecureModeFlag   uint32
+	TitleKeyFlag     uint32
+	KeyFlag          uint32
+	NormalAreaEnd    uint32
+}
+
+type XCI struct {
+	r      io.ReaderAt
+	c      io.Closer
+	Header XCIHeader
+	Root   *HFS0Reader
+}
+
+func NewXCIReader(r io.ReaderAt) (*XCI, error) {
+	h := XCIHeader{}
+	err := binary.Read(io.NewSectionReader(r, 0, 0x190), binary.LittleEndian, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(h.Magic[:]) != "HEAD" {
+		return nil, errors.New("invalid xci magic")
+	}
+
+	root, err := NewHFS0Reader(io.NewSectionReader(r, int64(h.HFS0Offset), 1<<62))
+	if err != nil {
+		return nil, err
+	}
+
+	return &XCI{r: r, Header: h, Root: root}, nil
+}
+
+func OpenXCI(path string) (*XCI, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := NewXCIReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	x.c = f
+
+	return x, nil
+}
+
+func (x *XCI) Close() error {
+	if x.c == nil {
+		return nil
+	}
+
+	return x.c.Close()
+}
+
+// Partition opens one of the root partitions, usually "update", "normal",
+// "secure" and on newer cards "logo".
+func (x *XCI) Partition(name string) (*HFS0Reader, error) {
+	r, err := x.Root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHFS0Reader(r)
+}
+
+// NCAs lists the NCAs in a partition.
+func (x *XCI) NCAs(partition string) ([]HFS0Entry, error) {
+	p, err := x.Partition(partition)
+	if err != nil {
+		return nil, err
+	}
+
+	ncas := []HFS0Entry{}
+	for _, v := range p.Entries {
+		if strings.HasSuffix(v.Name, ".nca") {
+			ncas = append(ncas, v)
+		}
+	}
+
+	return ncas, nil
+}
+
+func (x *XCI) Extract(partition, out string, names ...string) error {
+	p, err := x.Partition(partition)
+	if err != nil {
+		return err
+	}
+
+	return p.Extract(out, names...)
+}
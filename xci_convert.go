@@ -0,0 +1,125 @@
+package libhac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type ConvertOptions struct {
+	// TitleKey is only needed to inject a ticket for titles using rights id
+	// crypto. TicketTemplate is optional, a common ticket is generated
+	// without it.
+	TitleKey       string
+	TicketTemplate string
+	// CertChain is a .cert file or any cetk to take the certificate chain
+	// from, it's packed next to the injected ticket.
+	CertChain string
+	// Log is optional and gets the files packed into the nsp.
+	Log Logger
+}
+
+// ConvertXCIToNSP packs the NCAs of an XCI's secure partition into an NSP,
+// generating a cnmt.xml for every meta found.
+func ConvertXCIToNSP(xciPath, out string, keys *Keyset, opts ConvertOptions) error {
+	x, err := OpenXCI(xciPath)
+	if err != nil {
+		return err
+	}
+	defer x.Close()
+
+	secure, err := x.Partition("secure")
+	if err != nil {
+		return err
+	}
+
+	ncas := []NSPEntry{}
+	metas := []NSPEntry{}
+	extra := []NSPEntry{}
+	for _, v := range secure.Entries {
+		if !strings.HasSuffix(v.Name, ".nca") {
+			continue
+		}
+
+		r := io.NewSectionReader(secure.r, v.Offset, v.Size)
+		if !strings.HasSuffix(v.Name, ".cnmt.nca") {
+			ncas = append(ncas, NSPEntry{v.Name, v.Size, r})
+			continue
+		}
+		metas = append(metas, NSPEntry{v.Name, v.Size, r})
+
+		cnmt, err := readCNMTFromNCA(r, keys, v.Name)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, io.NewSectionReader(r, 0, v.Size))
+		if err != nil {
+			return err
+		}
+
+		xml, err := generateCNMTXML(cnmt, v.Size, h.Sum(nil))
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(v.Name, ".nca") + ".xml"
+		extra = append(extra, NSPEntry{name, int64(len(xml)), bytes.NewReader(xml)})
+
+		if opts.TitleKey != "" {
+			tik, rightsID, err := titleTicket(cnmt, opts)
+			if err != nil {
+				return err
+			}
+
+			extra = append(extra, NSPEntry{rightsID + ".tik", int64(len(tik)), bytes.NewReader(tik)})
+
+			if opts.CertChain != "" {
+				cert, err := ReadCertChain(opts.CertChain)
+				if err != nil {
+					return err
+				}
+
+				extra = append(extra, NSPEntry{rightsID + ".cert", int64(len(cert)), bytes.NewReader(cert)})
+			}
+		}
+	}
+
+	if len(metas) == 0 {
+		return errors.New("no meta nca found in the secure partition")
+	}
+
+	nsp, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer nsp.Close()
+
+	w := NewNSPWriter(nsp)
+	w.Log = opts.Log
+	for _, v := range append(append(ncas, metas...), extra...) {
+		w.Add(v.Name, v.Size, v.Reader)
+	}
+
+	return w.Close()
+}
+
+func titleTicket(cnmt CNMT, opts ConvertOptions) ([]byte, string, error) {
+	template, err := ticketTemplate(opts.TicketTemplate)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rightsID := GetRightsID(cnmt.IDString(), cnmt.MasterKeyRevisionString())
+
+	tik, err := patchTicket(template, opts.TitleKey, cnmt.MasterKeyRevisionString(), rightsID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tik, rightsID, nil
+}
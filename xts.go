@@ -0,0 +1,189 @@
+package libhac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// XTSCipher is AES-128-XTS as used for NCA headers and BIS partitions.
+// Nintendo's variant uses a big-endian sector number as the tweak.
+type XTSCipher struct {
+	SectorSize int
+
+	k1, k2 cipher.Block
+}
+
+// NewXTSCipher returns a cipher for the 0x20 byte key, which is both AES
+// keys concatenated. NCA headers use 0x200 byte sectors, BIS 0x4000.
+func NewXTSCipher(key []byte, sectorSize int) (*XTSCipher, error) {
+	if len(key) != 0x20 {
+		return nil, errors.New("xts key must be 0x20 bytes")
+	}
+
+	if sectorSize <= 0 || sectorSize%aes.BlockSize != 0 {
+		return nil, errors.New("xts sector size must be a multiple of the aes block size")
+	}
+
+	k1, err := aes.NewCipher(key[:0x10])
+	if err != nil {
+		return nil, err
+	}
+
+	k2, err := aes.NewCipher(key[0x10:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &XTSCipher{sectorSize, k1, k2}, nil
+}
+
+// Decrypt decrypts whole sectors in place, the first being sector.
+func (x *XTSCipher) Decrypt(data []byte, sector uint64) error {
+	return x.crypt(data, sector, x.k1.Decrypt)
+}
+
+// Encrypt encrypts whole sectors in place, the first being sector.
+func (x *XTSCipher) Encrypt(data []byte, sector uint64) error {
+	return x.crypt(data, sector, x.k1.Encrypt)
+}
+
+func (x *XTSCipher) crypt(data []byte, sector uint64, crypt func(dst, src []byte)) error {
+	if len(data)%x.SectorSize != 0 {
+		return errors.New("data is not a multiple of the sector size")
+	}
+
+	for off := 0; off < len(data); off += x.SectorSize {
+		var tweak [aes.BlockSize]byte
+		binary.BigEndian.PutUint64(tweak[8:], sector)
+		x.k2.Encrypt(tweak[:], tweak[:])
+
+		for i := off; i < off+x.SectorSize; i += aes.BlockSize {
+			b := data[i : i+aes.BlockSize]
+			xorBlock(b, tweak[:])
+			crypt(b, b)
+			xorBlock(b, tweak[:])
+			mulAlpha(&tweak)
+		}
+
+		sector++
+	}
+
+	return nil
+}
+
+// xtsReaderAt decrypts an XTS encrypted io.ReaderAt.
+type xtsReaderAt struct {
+	r      io.ReaderAt
+	c      *XTSCipher
+	sector uint64
+}
+
+// NewXTSReader returns a reader decrypting r, whose first sector has the
+// number sector. Reads don't need to be sector aligned.
+func NewXTSReader(r io.ReaderAt, c *XTSCipher, sector uint64) io.ReaderAt {
+	return &xtsReaderAt{r, c, sector}
+}
+
+func (x *xtsReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	ss := int64(x.c.SectorSize)
+	start := off / ss * ss
+	end := (off + int64(len(p)) + ss - 1) / ss * ss
+
+	buf := make([]byte, end-start)
+	n, err := x.r.ReadAt(buf, start)
+
+	// only whole sectors can be decrypted
+	n = n / int(ss) * int(ss)
+	if derr := x.c.Decrypt(buf[:n], x.sector+uint64(start/ss)); derr != nil {
+		return 0, derr
+	}
+
+	skip := int(off - start)
+	if n <= skip {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	copied := copy(p, buf[skip:n])
+	if copied < len(p) && err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return copied, err
+}
+
+// XTSWriter encrypts everything written to it to the underlying writer one
+// sector at a time.
+type XTSWriter struct {
+	w      io.Writer
+	c      *XTSCipher
+	sector uint64
+	buf    []byte
+}
+
+// NewXTSWriter returns a writer encrypting to w, the first sector written
+// has the number sector.
+func NewXTSWriter(w io.Writer, c *XTSCipher, sector uint64) *XTSWriter {
+	return &XTSWriter{w: w, c: c, sector: sector}
+}
+
+func (x *XTSWriter) Write(p []byte) (int, error) {
+	x.buf = append(x.buf, p...)
+
+	full := len(x.buf) / x.c.SectorSize * x.c.SectorSize
+	if full == 0 {
+		return len(p), nil
+	}
+
+	err := x.c.Encrypt(x.buf[:full], x.sector)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = x.w.Write(x.buf[:full])
+	if err != nil {
+		return 0, err
+	}
+
+	x.sector += uint64(full / x.c.SectorSize)
+	x.buf = append(x.buf[:0], x.buf[full:]...)
+
+	return len(p), nil
+}
+
+// Close checks that only whole sectors were written, it doesn't close the
+// underlying writer.
+func (x *XTSWriter) Close() error {
+	if len(x.buf) != 0 {
+		return errors.New("xts data is not a multiple of the sector size")
+	}
+
+	return nil
+}
+
+// decryptXTS decrypts whole sectors of data in place.
+func decryptXTS(key, data []byte, sectorSize int, sector uint64) error {
+	c, err := NewXTSCipher(key, sectorSize)
+	if err != nil {
+		return err
+	}
+
+	return c.Decrypt(data, sector)
+}
+
+func mulAlpha(tweak *[aes.BlockSize]byte) {
+	carry := tweak[15] >> 7
+	for i := 15; i > 0; i-- {
+		tweak[i] = tweak[i]<<1 | tweak[i-1]>>7
+	}
+	tweak[0] <<= 1
+
+	if carry != 0 {
+		tweak[0] ^= 0x87
+	}
+}
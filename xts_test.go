@@ -0,0 +1,119 @@
+package libhac_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/jakibaki/libhac"
+)
+
+func newTestXTSCipher(t *testing.T) *libhac.XTSCipher {
+	c, err := libhac.NewXTSCipher(bytes.Repeat([]byte{0x42}, 0x20), 0x200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestXTSRoundTrip(t *testing.T) {
+	c := newTestXTSCipher(t)
+
+	plain := make([]byte, 0x800)
+	rand.New(rand.NewSource(1)).Read(plain)
+
+	data := append([]byte{}, plain...)
+	err := c.Encrypt(data, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, plain) {
+		t.Fatal("encrypting didn't change the data")
+	}
+
+	// the same sector encrypts differently at another sector number
+	other := append([]byte{}, plain[:0x200]...)
+	c.Encrypt(other, 4)
+	if bytes.Equal(other, data[:0x200]) {
+		t.Error("sector number isn't part of the tweak")
+	}
+
+	err = c.Decrypt(data, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, plain) {
+		t.Error("decrypting didn't restore the data")
+	}
+
+	if err := c.Encrypt(make([]byte, 0x210), 0); err == nil {
+		t.Error("partial sector didn't fail")
+	}
+}
+
+func TestXTSReaderWriter(t *testing.T) {
+	c := newTestXTSCipher(t)
+
+	plain := make([]byte, 0x1000)
+	rand.New(rand.NewSource(2)).Read(plain)
+
+	enc := &bytes.Buffer{}
+	w := libhac.NewXTSWriter(enc, c, 1)
+	// odd write sizes have to be buffered into whole sectors
+	for off := 0; off < len(plain); off += 0x123 {
+		end := off + 0x123
+		if end > len(plain) {
+			end = len(plain)
+		}
+		if _, err := w.Write(plain[off:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := libhac.NewXTSReader(bytes.NewReader(enc.Bytes()), c, 1)
+
+	got := make([]byte, len(plain))
+	if _, err := r.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("reader didn't decrypt what the writer encrypted")
+	}
+
+	// unaligned reads
+	part := make([]byte, 0x345)
+	if _, err := r.ReadAt(part, 0x1f0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, plain[0x1f0:0x1f0+0x345]) {
+		t.Error("unaligned read returned the wrong data")
+	}
+
+	n, err := r.ReadAt(make([]byte, 0x100), 0xf80)
+	if n != 0x80 || err == nil {
+		t.Errorf("read past the end got %d, %v", n, err)
+	}
+
+	w = libhac.NewXTSWriter(ioutil.Discard, c, 0)
+	w.Write(make([]byte, 0x201))
+	if err := w.Close(); err == nil {
+		t.Error("closing after a partial sector didn't fail")
+	}
+}
+
+func TestNewXTSCipherErrors(t *testing.T) {
+	if _, err := libhac.NewXTSCipher(make([]byte, 0x10), 0x200); err == nil {
+		t.Error("short key didn't fail")
+	}
+	if _, err := libhac.NewXTSCipher(make([]byte, 0x20), 0x201); err == nil {
+		t.Error("unaligned sector size didn't fail")
+	}
+	if _, err := libhac.NewXTSCipher(make([]byte, 0x20), 0); err == nil {
+		t.Error("zero sector size didn't fail")
+	}
+}